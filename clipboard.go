@@ -0,0 +1,93 @@
+// This file is part of rpn, a simple and useful CLI RPN calculator.
+// For further information, check https://github.com/marcopaganini/rpn
+//
+// (C) Sep/2024 by Marco Paganini <paganini AT paganini DOT net>
+
+package main
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// clipboardCmd holds the commands used to copy to and paste from the system
+// clipboard.
+type clipboardCmd struct {
+	copy  []string
+	paste []string
+}
+
+// systemClipboard is the clipboard used by the copy and paste operations.
+// Copies fall back to OSC 52 on out, if it is a terminal.
+type systemClipboard struct {
+	out *os.File
+}
+
+// clipboardCmds contains the supported clipboard programs, in order of
+// preference. Wayland programs are only used under Wayland.
+var clipboardCmds = []clipboardCmd{
+	{copy: []string{"wl-copy"}, paste: []string{"wl-paste", "--no-newline"}},
+	{copy: []string{"xclip", "-selection", "clipboard"}, paste: []string{"xclip", "-selection", "clipboard", "-o"}},
+	{copy: []string{"xsel", "--clipboard", "--input"}, paste: []string{"xsel", "--clipboard", "--output"}},
+	{copy: []string{"pbcopy"}, paste: []string{"pbpaste"}},
+}
+
+// findClipboard returns the first clipboard program found in the PATH.
+func findClipboard() (clipboardCmd, error) {
+	for _, c := range clipboardCmds {
+		if c.copy[0] == "wl-copy" && os.Getenv("WAYLAND_DISPLAY") == "" {
+			continue
+		}
+		if _, err := exec.LookPath(c.copy[0]); err == nil {
+			return c, nil
+		}
+	}
+	return clipboardCmd{}, errors.New("unable to find clipboard program (wl-copy, xclip, xsel, pbcopy)")
+}
+
+// osc52 returns the OSC 52 terminal escape sequence that sets the clipboard
+// to s. Most modern terminals support it, including over ssh.
+func osc52(s string) string {
+	return "\033]52;c;" + base64.StdEncoding.EncodeToString([]byte(s)) + "\a"
+}
+
+// copyToClipboard places s on the system clipboard. Without a clipboard
+// program, it falls back to OSC 52 if w is a terminal.
+func copyToClipboard(w io.Writer, s string) error {
+	c, err := findClipboard()
+	if err != nil {
+		if f, ok := w.(*os.File); ok && isTerminal(f) {
+			_, err = fmt.Fprint(w, osc52(s))
+		}
+		return err
+	}
+	cmd := exec.Command(c.copy[0], c.copy[1:]...)
+	cmd.Stdin = strings.NewReader(s)
+	return cmd.Run()
+}
+
+// pasteFromClipboard returns the contents of the system clipboard.
+func pasteFromClipboard() (string, error) {
+	c, err := findClipboard()
+	if err != nil {
+		return "", err
+	}
+	out, err := exec.Command(c.paste[0], c.paste[1:]...).Output()
+	return string(out), err
+}
+
+// Copy places s on the system clipboard (rpn.Clipboard interface).
+func (x systemClipboard) Copy(s string) error {
+	return copyToClipboard(x.out, s)
+}
+
+// Paste returns the contents of the system clipboard (rpn.Clipboard
+// interface).
+func (x systemClipboard) Paste() (string, error) {
+	return pasteFromClipboard()
+}
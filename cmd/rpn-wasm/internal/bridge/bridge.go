@@ -0,0 +1,72 @@
+// This file is part of rpn, a simple and useful CLI RPN calculator.
+// For further information, check https://github.com/marcopaganini/rpn
+//
+// (C) Sep/2024 by Marco Paganini <paganini AT paganini DOT net>
+
+// Package bridge converts calls from JavaScript into calculator operations,
+// and their results into values that can be passed back to JavaScript
+// (strings, []any and map[string]any).
+package bridge
+
+import (
+	"errors"
+
+	"github.com/marcopaganini/rpn/pkg/rpn"
+)
+
+// Bridge holds the persistent calculator used by Eval.
+type Bridge struct {
+	calc *rpn.Calculator
+}
+
+// New returns a new Bridge with an empty stack.
+func New() *Bridge {
+	return &Bridge{calc: rpn.New()}
+}
+
+// Eval evaluates args[0] using the persistent calculator and returns an
+// object with "result" (x, formatted), "stack" (all items, x last), and
+// "error" (empty on success).
+func (b *Bridge) Eval(args []string) map[string]any {
+	var ret map[string]any
+	if len(args) != 1 {
+		ret = result(nil, errors.New("usage: rpn.eval(expr)"))
+	} else {
+		ret = result(b.calc, b.calc.Eval(args[0]))
+	}
+	stack := []any{}
+	for _, n := range b.calc.Stack() {
+		stack = append(stack, b.calc.Format(n))
+	}
+	ret["stack"] = stack
+	return ret
+}
+
+// Calc evaluates args[0] using a new calculator and returns an object with
+// "result" and "error".
+func (b *Bridge) Calc(args []string) map[string]any {
+	if len(args) != 1 {
+		return result(nil, errors.New("usage: rpn.calc(expr)"))
+	}
+	c := rpn.New()
+	return result(c, c.Eval(args[0]))
+}
+
+// Reset clears the stack, modes, registers and macros used by Eval.
+func (b *Bridge) Reset() {
+	b.calc = rpn.New()
+}
+
+// result returns an object with the formatted top of the stack of c (if
+// any) and the error message, if err is not nil.
+func result(c *rpn.Calculator, err error) map[string]any {
+	ret := map[string]any{"result": "", "error": ""}
+	if err != nil {
+		ret["error"] = err.Error()
+		return ret
+	}
+	if x, err := c.Top(); err == nil {
+		ret["result"] = c.Format(x)
+	}
+	return ret
+}
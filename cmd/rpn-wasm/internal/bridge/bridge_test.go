@@ -0,0 +1,46 @@
+// This file is part of rpn, a simple and useful CLI RPN calculator.
+// For further information, check https://github.com/marcopaganini/rpn
+//
+// (C) Sep/2024 by Marco Paganini <paganini AT paganini DOT net>
+
+package bridge
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestBridge(t *testing.T) {
+	b := New()
+
+	casetests := []struct {
+		fn   func([]string) map[string]any
+		args []string
+		want map[string]any
+	}{
+		{fn: b.Eval, args: []string{"2 3 +"}, want: map[string]any{"result": "5", "stack": []any{"5"}, "error": ""}},
+		{fn: b.Eval, args: []string{"4 *"}, want: map[string]any{"result": "20", "stack": []any{"20"}, "error": ""}},
+		{fn: b.Eval, args: []string{"foo"}, want: map[string]any{"result": "", "stack": []any{"20"}, "error": `not a number or operator: "foo"`}},
+		{fn: b.Eval, args: []string{"d"}, want: map[string]any{"result": "", "stack": []any{}, "error": ""}},
+		{fn: b.Eval, args: nil, want: map[string]any{"result": "", "stack": []any{}, "error": "usage: rpn.eval(expr)"}},
+		{fn: b.Calc, args: []string{"1 3 / 3 fmt"}, want: map[string]any{"result": "0.333", "error": ""}},
+		{fn: b.Calc, args: []string{"+"}, want: map[string]any{"result": "", "error": "this operation requires at least 2 items in the stack"}},
+		{fn: b.Calc, args: []string{"1", "2"}, want: map[string]any{"result": "", "error": "usage: rpn.calc(expr)"}},
+		// Errors in operations on an empty stack don't stop the runtime.
+		{fn: b.Calc, args: []string{"fmt"}, want: map[string]any{"result": "", "error": "this operation requires at least 1 items in the stack"}},
+		{fn: b.Eval, args: []string{"fmt"}, want: map[string]any{"result": "", "stack": []any{}, "error": "this operation requires at least 1 items in the stack"}},
+		// Calc doesn't change the persistent stack.
+		{fn: b.Eval, args: []string{"7"}, want: map[string]any{"result": "7", "stack": []any{"7"}, "error": ""}},
+	}
+	for _, tt := range casetests {
+		if got := tt.fn(tt.args); !reflect.DeepEqual(got, tt.want) {
+			t.Fatalf("diff: args: %q, want: %v, got: %v", tt.args, tt.want, got)
+		}
+	}
+
+	b.Reset()
+	want := map[string]any{"result": "", "stack": []any{}, "error": ""}
+	if got := b.Eval([]string{""}); !reflect.DeepEqual(got, want) {
+		t.Fatalf("diff: after Reset, want: %v, got: %v", want, got)
+	}
+}
@@ -0,0 +1,57 @@
+// This file is part of rpn, a simple and useful CLI RPN calculator.
+// For further information, check https://github.com/marcopaganini/rpn
+//
+// (C) Sep/2024 by Marco Paganini <paganini AT paganini DOT net>
+
+//go:build js && wasm
+
+// Command rpn-wasm exposes the rpn calculator to JavaScript. It defines a
+// global "rpn" object with the following functions:
+//
+//	rpn.eval(expr)  Evaluate expr using a persistent stack. Returns an object
+//	                with "result" (x, formatted), "stack" (all items, x last),
+//	                and "error" (empty on success).
+//	rpn.calc(expr)  Evaluate expr with a new stack. Returns an object with
+//	                "result" and "error".
+//	rpn.reset()     Clear the stack, modes, registers and macros.
+package main
+
+import (
+	"syscall/js"
+
+	"github.com/marcopaganini/rpn/cmd/rpn-wasm/internal/bridge"
+	"github.com/marcopaganini/rpn/pkg/rpn"
+)
+
+// Build is filled by go build -ldflags during build.
+var Build string
+
+// jsFunc wraps fn in a function callable from JavaScript, converting all
+// arguments to strings.
+func jsFunc(fn func([]string) map[string]any) js.Func {
+	return js.FuncOf(func(_ js.Value, args []js.Value) any {
+		strs := make([]string, len(args))
+		for i, v := range args {
+			strs[i] = v.String()
+		}
+		return fn(strs)
+	})
+}
+
+func main() {
+	rpn.Build = Build
+	b := bridge.New()
+
+	js.Global().Set("rpn", js.ValueOf(map[string]any{
+		"version": Build,
+		"eval":    jsFunc(b.Eval),
+		"calc":    jsFunc(b.Calc),
+		"reset": js.FuncOf(func(_ js.Value, _ []js.Value) any {
+			b.Reset()
+			return nil
+		}),
+	}))
+
+	// Keep the program alive to serve calls from JavaScript.
+	select {}
+}
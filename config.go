@@ -0,0 +1,210 @@
+// This file is part of rpn, a simple and useful CLI RPN calculator.
+// For further information, check https://github.com/marcopaganini/rpn
+//
+// (C) Sep/2024 by Marco Paganini <paganini AT paganini DOT net>
+
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/marcopaganini/rpn/pkg/rpn"
+)
+
+// configType holds the defaults read from the configuration file.
+type configType struct {
+	decimals intFlag           // Number of decimals
+	base     intFlag           // Output base
+	degmode  bool              // Degrees mode
+	colors   string            // Colors, in the RPN_COLORS format
+	history  int               // Maximum number of lines in the readline history (0 = default)
+	saveLast bool              // Save single-command results for --cont
+	aliases  map[string]string // Alias (macro) name -> expression
+}
+
+// configFile returns the path of the default configuration file, usually
+// $XDG_CONFIG_HOME/rpn/config.
+func configFile() (string, error) {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "rpn", "config"), nil
+}
+
+// loadConfig reads the configuration file fname. An empty fname reads the
+// default configuration file, which may not exist.
+func loadConfig(fname string) (configType, error) {
+	optional := fname == ""
+	if optional {
+		var err error
+		if fname, err = configFile(); err != nil {
+			return configType{}, nil
+		}
+	}
+	f, err := os.Open(fname)
+	if err != nil {
+		if optional && errors.Is(err, fs.ErrNotExist) {
+			return configType{}, nil
+		}
+		return configType{}, err
+	}
+	defer f.Close()
+	return parseConfig(f, fname)
+}
+
+// parseConfig parses a configuration file in a simple subset of TOML: one
+// "key = value" per line, where value is a number or a quoted string, and an
+// optional [aliases] section defining macros. Comments start with "#".
+// Example:
+//
+//	decimals = 4
+//	base = 16
+//	angle = "deg"
+//	colors = "x=1;36:res=34"
+//	history = 1000
+//	save_last = true
+//
+//	[aliases]
+//	avg = "mean"
+//	double = "2 *"
+func parseConfig(r io.Reader, fname string) (configType, error) {
+	cfg := configType{aliases: map[string]string{}}
+	section := ""
+
+	scanner := bufio.NewScanner(r)
+	for lineno := 1; scanner.Scan(); lineno++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		errorf := func(format string, a ...any) error {
+			return fmt.Errorf("%s:%d: %s", fname, lineno, fmt.Sprintf(format, a...))
+		}
+
+		if strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]") {
+			section = strings.TrimSpace(line[1 : len(line)-1])
+			if section != "aliases" {
+				return cfg, errorf("unknown section: %q", section)
+			}
+			continue
+		}
+		key, value, ok := strings.Cut(line, "=")
+		if !ok {
+			return cfg, errorf("expected key = value: %q", line)
+		}
+		key = strings.TrimSpace(key)
+		value, err := configValue(value)
+		if err != nil {
+			return cfg, errorf("%s: %v", key, err)
+		}
+
+		if section == "aliases" {
+			cfg.aliases[key] = value
+			continue
+		}
+		switch key {
+		case "decimals":
+			n, err := strconv.Atoi(value)
+			if err != nil || n < 0 {
+				return cfg, errorf("decimals must be a positive integer")
+			}
+			cfg.decimals = intFlag{n: n, set: true}
+		case "base":
+			n, err := strconv.Atoi(value)
+			if err != nil {
+				return cfg, errorf("base must be an integer")
+			}
+			// The calculator knows the valid bases.
+			if err := rpn.New().SetBase(n); err != nil {
+				return cfg, errorf("%v", err)
+			}
+			cfg.base = intFlag{n: n, set: true}
+		case "angle":
+			if value != "deg" && value != "rad" {
+				return cfg, errorf("angle must be deg or rad")
+			}
+			cfg.degmode = value == "deg"
+		case "colors":
+			cfg.colors = value
+		case "history":
+			n, err := strconv.Atoi(value)
+			if err != nil || n < 1 {
+				return cfg, errorf("history must be a positive integer")
+			}
+			cfg.history = n
+		case "save_last":
+			b, err := strconv.ParseBool(value)
+			if err != nil {
+				return cfg, errorf("save_last must be true or false")
+			}
+			cfg.saveLast = b
+		default:
+			return cfg, errorf("unknown key: %q", key)
+		}
+	}
+	return cfg, scanner.Err()
+}
+
+// configValue returns a configuration value without surrounding spaces,
+// quotes and trailing comments.
+func configValue(s string) (string, error) {
+	s = strings.TrimSpace(s)
+	switch {
+	case strings.HasPrefix(s, `"`):
+		end := strings.LastIndex(s, `"`)
+		if end == 0 {
+			return "", errors.New("missing closing quote")
+		}
+		return strconv.Unquote(s[:end+1])
+	case strings.HasPrefix(s, "'"):
+		end := strings.LastIndex(s, "'")
+		if end == 0 {
+			return "", errors.New("missing closing quote")
+		}
+		return s[1:end], nil
+	}
+	if before, _, ok := strings.Cut(s, "#"); ok {
+		s = strings.TrimSpace(before)
+	}
+	return s, nil
+}
+
+// applyConfig sets the defaults in cfg in c.
+func applyConfig(c *rpn.Calculator, cfg configType) error {
+	if cfg.decimals.set {
+		if err := c.SetDecimals(cfg.decimals.n); err != nil {
+			return err
+		}
+	}
+	if cfg.base.set {
+		if err := c.SetBase(cfg.base.n); err != nil {
+			return err
+		}
+	}
+	c.SetDegrees(cfg.degmode)
+	for name, expr := range cfg.aliases {
+		if err := c.Define(name, expr); err != nil {
+			return fmt.Errorf("alias %s: %w", name, err)
+		}
+	}
+	return nil
+}
+
+// pluginDir returns the directory holding plugins, usually
+// $XDG_CONFIG_HOME/rpn/plugins.
+func pluginDir() (string, error) {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "rpn", "plugins"), nil
+}
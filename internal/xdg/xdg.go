@@ -0,0 +1,26 @@
+// This file is part of rpn, a simple and useful CLI RPN calculator.
+// For further information, check https://github.com/marcopaganini/rpn
+//
+// (C) Sep/2024 by Marco Paganini <paganini AT paganini DOT net>
+
+// Package xdg returns the paths of the files rpn keeps between runs.
+package xdg
+
+import (
+	"os"
+	"path/filepath"
+)
+
+// StateFile returns the path of a file kept between runs of the program.
+// These files live under $XDG_STATE_HOME/rpn (default: ~/.local/state/rpn).
+func StateFile(name string) (string, error) {
+	dir := os.Getenv("XDG_STATE_HOME")
+	if dir == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return "", err
+		}
+		dir = filepath.Join(home, ".local", "state")
+	}
+	return filepath.Join(dir, "rpn", name), nil
+}
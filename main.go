@@ -5,212 +5,742 @@
 package main
 
 import (
+	"bufio"
 	"errors"
+	"flag"
 	"fmt"
+	"io"
+	"io/fs"
 	"log"
 	"os"
-	"regexp"
+	"path/filepath"
 	"strconv"
 	"strings"
+	"text/template"
 
-	"github.com/chzyer/readline"
 	"github.com/ericlagergren/decimal"
 	"github.com/fatih/color"
+	"github.com/marcopaganini/rpn/internal/xdg"
+	"github.com/marcopaganini/rpn/pkg/rpn"
+)
+
+type (
+	// optionsType holds the command-line options.
+	optionsType struct {
+		initCmds    stringList // Commands to run before entering interactive mode
+		exprs       stringList // Expressions to evaluate, printing each result
+		interactive bool       // Enter interactive mode after running scripts
+		scripts     []string   // Script files to run before entering interactive mode
+		listen      string     // Unix socket path to listen on (instead of the terminal)
+		strict      bool       // Unknown tokens abort single-command and script execution
+		leftover    string     // What to do with leftover stack items (ignore, warn, fail)
+		check       bool       // Validate the script files passed as arguments
+		cont        bool       // Push the result of the previous single-command execution
+		saveLast    bool       // Save the result of single-command execution for --cont
+		persist     bool       // Save and restore the stack and modes between interactive sessions
+		file        string     // Script file to execute (printing the result)
+		norc        bool       // Don't run the startup file (~/.rpnrc)
+		keepGoing   bool       // Continue with the next line of scripts after errors
+		noColor     bool       // Disable colors in all output
+		format      string     // Output format of single-command execution
+		stdin       bool       // Read one expression per line from stdin (filter mode)
+		apply       string     // Expression applied to all numbers read from stdin
+		quiet       bool       // Suppress warnings, notes and decorations
+		infix       bool       // Evaluate infix (algebraic) expressions
+		version     bool       // Print the version and exit
+		force       bool       // Let selfupdate replace development builds and downgrade
+		decimals    intFlag    // Number of decimals in results
+		prec        intFlag    // Working precision in significant digits
+		obase       intFlag    // Output base
+		ibase       intFlag    // Base for numbers entered without a prefix
+		config      string     // Configuration file (default: $XDG_CONFIG_HOME/rpn/config)
+		cfg         configType // Defaults read from the configuration file
+	}
+
+	// stringList is a flag.Value that accumulates the values of a flag
+	// that can be repeated in the command-line.
+	stringList []string
+
+	// intFlag is a flag.Value holding an integer that records whether it
+	// was set in the command-line.
+	intFlag struct {
+		n   int
+		set bool
+	}
 )
 
 var (
 	// Build is filled by go build -ldflags during build.
-	Build        string
-	programTitle = "rpn - a simple CLI RPN calculator"
+	Build string
 
-	// These are functions to be used to print in color.
-	errorMsg = color.New(color.FgRed).SprintFunc()
-	warnMsg  = color.New(color.FgMagenta).SprintFunc()
-	bold     = color.New(color.Bold).SprintFunc()
+	// quiet suppresses warnings and notes (--quiet).
+	quiet bool
 )
 
-// atof takes a string as an argument and return a decimal object representing
-// that string. Strings starting in 0x or 0X are treated as hex strings.
-// Strings starting in o or 0 are treated as octal strings. Non decimal strings
-// are converted to a uint64 intermediate representation and thus limited to
-// how much a uint64 can hold.
-func atof(s string) (*decimal.Big, error) {
-	base := 10
-	switch {
-	case (strings.HasPrefix(s, "0b") || strings.HasPrefix(s, "0B")) && len(s) > 2:
-		s = s[2:]
-		base = 2
-	case (strings.HasPrefix(s, "0x") || strings.HasPrefix(s, "0X")) && len(s) > 2:
-		s = s[2:]
-		base = 16
-	// Numbers starting with 0 must account for 0.xx fractional numbers not
-	// being octal numbers.
-	case (strings.HasPrefix(s, "0") || strings.HasPrefix(s, "o")) && !strings.HasPrefix(s, "0.") && len(s) > 1:
-		s = s[1:]
-		base = 8
+// String returns the values in the list (flag.Value interface).
+func (x *stringList) String() string {
+	return strings.Join(*x, ", ")
+}
+
+// Set adds a new value to the list (flag.Value interface).
+func (x *stringList) Set(s string) error {
+	*x = append(*x, s)
+	return nil
+}
+
+// String returns the value as a string (flag.Value interface).
+func (x *intFlag) String() string {
+	if !x.set {
+		return ""
+	}
+	return strconv.Itoa(x.n)
+}
+
+// Set parses and sets the value (flag.Value interface).
+func (x *intFlag) Set(s string) error {
+	n, err := strconv.Atoi(s)
+	if err != nil {
+		return errors.New("must be an integer")
+	}
+	x.n, x.set = n, true
+	return nil
+}
+
+// runScript executes all lines in a script file. Execution stops at the first
+// error and the error is returned with the file name and line number. If the
+// calculator keeps going after errors (see rpn.Calculator.SetKeepGoing),
+// errors are printed to stderr and execution continues with the next line.
+func runScript(c *rpn.Calculator, fname string) error {
+	f, err := os.Open(fname)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for lineno := 1; scanner.Scan(); lineno++ {
+		_, err := c.Exec(scanner.Text())
+		if err == nil {
+			continue
+		}
+		err = fmt.Errorf("%s:%d: %w", fname, lineno, err)
+		// Log errors and continue with the next line if requested.
+		if !c.KeepGoing() || errors.Is(err, rpn.ErrQuit) {
+			return err
+		}
+		fmt.Fprintln(os.Stderr, rpn.ErrorMsg("ERROR: ", err))
+	}
+	return scanner.Err()
+}
+
+// runFilter evaluates each line read from r as an independent expression,
+// starting with an empty stack, and prints the top of the stack after each
+// line to w (see rpn.Calculator.PrintResult). Empty lines and comments are
+// ignored. The first error stops execution, unless the calculator keeps
+// going after errors.
+func runFilter(c *rpn.Calculator, r io.Reader, w io.Writer, format string) error {
+	scanner := bufio.NewScanner(r)
+	for lineno := 1; scanner.Scan(); lineno++ {
+		c.Clear()
+		_, err := c.Exec(scanner.Text())
+		if errors.Is(err, rpn.ErrQuit) {
+			return nil
+		}
+		if err != nil {
+			err = fmt.Errorf("stdin:%d: %w", lineno, err)
+			if !c.KeepGoing() {
+				return err
+			}
+			fmt.Fprintln(os.Stderr, rpn.ErrorMsg("ERROR: ", err))
+			continue
+		}
+		if c.Len() > 0 {
+			if err := c.PrintResult(w, format); err != nil {
+				return err
+			}
+		}
 	}
+	return scanner.Err()
+}
 
-	if base == 10 {
-		var d decimal.Big
-		if _, ok := d.SetString(s); !ok || d.IsNaN(0) {
-			return nil, errors.New("unable to convert number")
+// runApply pushes all numbers read from r and runs expr, printing the result
+// to w (see rpn.Calculator.PrintResult). A single operator that takes two
+// arguments (E.g. max or +) reduces the whole stack, as in "fold max".
+func runApply(c *rpn.Calculator, r io.Reader, w io.Writer, expr, format string) error {
+	scanner := bufio.NewScanner(r)
+	for lineno := 1; scanner.Scan(); lineno++ {
+		nums, err := c.ParseNumbers(scanner.Text())
+		if err != nil {
+			return fmt.Errorf("stdin:%d: %w", lineno, err)
 		}
-		return &d, nil
+		c.Push(nums...)
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	if c.Len() == 0 {
+		return errors.New("no numbers read from stdin")
+	}
+
+	if n, ok := c.NumArgs(strings.TrimSpace(expr)); ok && n == 2 {
+		expr = "fold " + expr
 	}
+	if _, err := c.Exec(expr); err != nil && !errors.Is(err, rpn.ErrQuit) {
+		return err
+	}
+	if c.Len() == 0 {
+		return nil
+	}
+	return c.PrintResult(w, format)
+}
 
-	// Non-base 10 numbers are limited to uint64 sizes.
-	ret, err := strconv.ParseUint(s, base, 64)
+// runRC runs the startup file (~/.rpnrc), if it exists.
+func runRC(c *rpn.Calculator) error {
+	home, err := os.UserHomeDir()
 	if err != nil {
-		return nil, err
+		return err
 	}
-	return bigUint(ret), nil
+	err = runScript(c, filepath.Join(home, ".rpnrc"))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	return err
 }
 
-// calc contains the bulk of the calculator code. It takes a stack and an
-// optional string argument. If string the string is not empty, it executes the
-// oeprations in the string and returns. If the string is empty, it enters a
-// readline loop accepting commands from the user.
-func calc(stack *stackType, cmd string) error {
-	// Wait for entry until Ctrl-D or q is issued
-	var (
-		line string
-		err  error
-		rl   *readline.Instance
-	)
+// checkLeftover checks if the stack contains more than one item after a
+// non-interactive execution, which usually indicates a forgotten operator.
+// Depending on action, it prints a warning to stderr or returns an error.
+func checkLeftover(c *rpn.Calculator, action string) error {
+	n := c.Len()
+	if n <= 1 {
+		return nil
+	}
+	switch action {
+	case "warn":
+		if quiet {
+			return nil
+		}
+		fmt.Fprintln(os.Stderr, rpn.WarnMsg(fmt.Sprintf("Warning: %d items left on the stack", n)))
+	case "fail":
+		return fmt.Errorf("%d items left on the stack", n)
+	}
+	return nil
+}
 
-	ctx := decimal.Context128
+// lastResultFile returns the path of the file holding the result of the last
+// single-command execution.
+func lastResultFile() (string, error) {
+	return xdg.StateFile("last")
+}
 
-	// Single command execution?
-	single := (cmd != "")
+// saveLastResult saves n as the result of the last single-command execution.
+func saveLastResult(n *decimal.Big) error {
+	fname, err := lastResultFile()
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(fname), 0o755); err != nil {
+		return err
+	}
+	return os.WriteFile(fname, []byte(n.String()+"\n"), 0o644)
+}
+
+// saveResult saves the top of the stack in c for --cont, if enabled with
+// --save-last or in the configuration file. Runs using --cont always save
+// their result, so chains can go on. Failing to save is not an error, as the
+// result was already printed.
+func saveResult(c *rpn.Calculator, opts optionsType) {
+	if !opts.saveLast && !opts.cfg.saveLast && !opts.cont {
+		return
+	}
+	if x, err := c.Top(); err == nil {
+		_ = saveLastResult(x)
+	}
+}
+
+// loadLastResult returns the result of the last single-command execution.
+func loadLastResult() (*decimal.Big, error) {
+	fname, err := lastResultFile()
+	if err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(fname)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, errors.New("no previous result to continue from (see --save-last)")
+	}
+	if err != nil {
+		return nil, err
+	}
+	n, ok := new(decimal.Big).SetString(strings.TrimSpace(string(data)))
+	if !ok {
+		return nil, fmt.Errorf("invalid previous result in %s", fname)
+	}
+	return n, nil
+}
 
-	// Operations
-	ops := newOpsType(ctx, stack)
-	opmap := ops.opmap()
+// configure sets the modes and aliases in the configuration file and the
+// command-line options in c.
+func configure(c *rpn.Calculator, opts optionsType) error {
+	c.SetStrict(opts.strict)
+	c.SetKeepGoing(opts.keepGoing)
+	c.SetQuiet(opts.quiet)
+	c.SetInfix(opts.infix)
+	if err := applyConfig(c, opts.cfg); err != nil {
+		return err
+	}
+	if opts.decimals.set {
+		if err := c.SetDecimals(opts.decimals.n); err != nil {
+			return err
+		}
+	}
+	if opts.obase.set {
+		if err := c.SetBase(opts.obase.n); err != nil {
+			return err
+		}
+	}
+	if opts.ibase.set {
+		if err := c.SetInputBase(opts.ibase.n); err != nil {
+			return err
+		}
+	}
+	if opts.prec.set {
+		if err := c.SetPrecision(opts.prec.n); err != nil {
+			return err
+		}
+	}
+	return nil
+}
 
-	if !single {
-		rl, err = readline.New("> ")
+// checkScripts validates the script files in fnames (see
+// rpn.Calculator.Check), printing all problems found to w. The calculator is
+// set up as in calc, so aliases, macros defined in the startup file (unless
+// --norc is used) and the input base are known. It returns false if any
+// problems were found.
+func checkScripts(w io.Writer, fnames []string, opts optionsType) (bool, error) {
+	c := rpn.New()
+	if err := configure(c, opts); err != nil {
+		return false, err
+	}
+	if !opts.norc {
+		if err := runRC(c); err != nil && !errors.Is(err, rpn.ErrQuit) {
+			return false, err
+		}
+		c.Clear()
+	}
+
+	ok := true
+	for _, fname := range fnames {
+		var problems []string
+		f, err := os.Open(fname)
+		if err == nil {
+			problems, err = c.Check(f, fname)
+			f.Close()
+		}
 		if err != nil {
-			log.Fatal(err)
+			problems = append(problems, err.Error())
+		}
+		for _, p := range problems {
+			fmt.Fprintln(w, p)
 		}
-		defer rl.Close()
+		ok = ok && len(problems) == 0
+	}
+	return ok, nil
+}
+
+// calc contains the bulk of the calculator code. It takes a calculator, an
+// optional string argument, and the command-line options. If the string is
+// not empty, it executes the operations in the string and returns. If the
+// string is empty, it executes the initialization commands (if any) and
+// enters a readline loop accepting commands from the user.
+func calc(c *rpn.Calculator, cmd string, opts optionsType) error {
+	if err := configure(c, opts); err != nil {
+		return err
+	}
+	c.SetOutput(os.Stdout)
+	c.SetClipboard(systemClipboard{out: os.Stdout})
+	// Help goes through a pager, unless sent to a connection.
+	if opts.listen == "" {
+		c.SetPager(func() (io.WriteCloser, error) { return newPager() })
 	}
 
-	// Remove all extraneous characters from the input. This will silently
-	// remove undesirable formatting characters, making cut/paste operations
-	// simpler. If you add a new operation as a single special character, make
-	// sure it's represented here.
-	cleanRe := regexp.MustCompile(`[^-+./*%^=[:alnum:]\s]`)
+	// Non-interactive results honor --base and --decimals, unless a format
+	// was given.
+	base := opts.cfg.base
+	if opts.obase.set {
+		base = opts.obase
+	}
+	switch {
+	case opts.format != "":
+	case base.set && base.n != 10:
+		opts.format = "{{.Fmt}}"
+	case opts.decimals.set:
+		opts.format = fmt.Sprintf("%%.%df", opts.decimals.n)
+	}
+	// Undecorated results when the output is not a terminal (E.g. a pipe).
+	c.SetPlain(opts.quiet || (opts.listen == "" && !isTerminal(os.Stdout)))
 
-	for {
-		// Save a copy of the stack so we can restore it to the previous state
-		// before this line was processed (in case of errors.)
-		stack.save()
+	// Registers saved with "save".
+	if err := c.LoadRegisters(); err != nil {
+		fmt.Fprintln(os.Stderr, rpn.ErrorMsg("ERROR: Unable to load registers: ", err))
+	}
 
-		if ops.debug {
-			stack.print(ctx, ops.base, ops.decimals)
+	// Continue from the result of the previous single-command execution.
+	if opts.cont {
+		n, err := loadLastResult()
+		if err != nil {
+			return err
 		}
+		c.Push(n)
+	}
 
-		// By default, use the passed command. If no command, initialize readline.
-		line = cmd
-		if !single {
-			line, err = rl.Readline()
-			if err != nil { // io.EOF
-				break
-			}
+	// Script file execution? The final result is printed as in single
+	// command mode.
+	if opts.file != "" {
+		if err := runScript(c, opts.file); err != nil && !errors.Is(err, rpn.ErrQuit) {
+			return err
 		}
-		// Comment?
-		if strings.HasPrefix(line, "#") {
-			continue
+		if c.Len() > 0 {
+			if err := c.PrintResult(os.Stdout, opts.format); err != nil {
+				return err
+			}
 		}
+		return checkLeftover(c, opts.leftover)
+	}
 
-		line = strings.TrimSpace(line)
-		line = cleanRe.ReplaceAllString(line, "")
-
-		// Split into fields and process
-		autoprint := false
-		for _, token := range strings.Fields(line) {
-			// Check operator map
-			handler, ok := opmap[token]
-			if ok {
-				results, remove, err := operation(handler, stack)
-				if err != nil {
-					if single {
-						return err
-					}
-					fmt.Printf(errorMsg("ERROR: %v\n"), err)
-					stack.restore()
-					break
-				}
-				// If the particular handler does not ignore results from the
-				// function, set autoprint to true. This will cause the top of
-				// the stack results to be printed.
-				autoprint = (len(results) > 0 || remove > 0)
-
-				if !single {
-					// Set readline prompt based on base and degrees/radian mode.
-					switch {
-					case ops.degmode:
-						rl.SetPrompt("deg> ")
-					case ops.base == 10:
-						rl.SetPrompt("> ")
-					case ops.base == 8:
-						rl.SetPrompt("oct> ")
-					case ops.base == 16:
-						rl.SetPrompt("hex> ")
-					case ops.base == 2:
-						rl.SetPrompt("bin> ")
-					}
-				}
-				continue
+	// Expressions passed with -e share the stack. Each result is printed.
+	if len(opts.exprs) > 0 {
+		for _, expr := range opts.exprs {
+			_, err := c.Exec(expr)
+			if errors.Is(err, rpn.ErrQuit) {
+				return nil
 			}
-
-			// Help
-			if token == "help" || token == "h" || token == "?" {
-				if err := ops.help(); err != nil {
-					fmt.Println(errorMsg(err))
+			if err != nil {
+				return fmt.Errorf("%q: %w", expr, err)
+			}
+			if c.Len() > 0 {
+				if err := c.PrintResult(os.Stdout, opts.format); err != nil {
+					return err
 				}
-				continue
 			}
+		}
+		saveResult(c, opts)
+		return checkLeftover(c, opts.leftover)
+	}
 
-			if token == "quit" || token == "exit" || token == "q" {
-				fmt.Printf("Bye.\n")
-				os.Exit(0)
-			}
+	// Read numbers from stdin and reduce them.
+	if opts.apply != "" {
+		if err := runApply(c, os.Stdin, os.Stdout, opts.apply, opts.format); err != nil {
+			return err
+		}
+		return checkLeftover(c, opts.leftover)
+	}
 
-			// At this point, it's either a number or not recognized.
-			// If anything fails, restore stack and stop token processing.
-			n, err := atof(token)
-			if err != nil {
-				fmt.Printf(errorMsg("Not a number or operator: %q.\n"), token)
-				fmt.Println(errorMsg("Use \"help\" for online help."))
-				stack.restore()
-				break
+	// Filter mode: one expression per line from stdin.
+	if opts.stdin {
+		return runFilter(c, os.Stdin, os.Stdout, opts.format)
+	}
+
+	// Single command execution?
+	if cmd != "" {
+		if c.Debug() {
+			c.PrintStack(os.Stdout)
+		}
+		autoprint, err := c.Exec(cmd)
+		if errors.Is(err, rpn.ErrQuit) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		// Print numbers entered alone, useful for base conversions.
+		if autoprint || onlyNumbers(c, cmd) {
+			if err := c.PrintResult(os.Stdout, opts.format); err != nil {
+				return err
 			}
-			// Valid number
-			stack.push(n)
-			continue
 		}
+		saveResult(c, opts)
+		return checkLeftover(c, opts.leftover)
+	}
 
-		if autoprint {
-			if single {
-				fmt.Println(stack.top()) // plain print to stdout
-			} else {
-				stack.printTop(ctx, ops.base, ops.decimals) // pretty print to terminal
-			}
+	// Restore the previous interactive session.
+	if opts.persist && opts.listen == "" {
+		if err := loadSession(c); err != nil {
+			fmt.Printf(rpn.ErrorMsg("ERROR: Unable to restore session: %v\n"), err)
 		}
+	}
 
-		// Break after the first iteration if a command is passed.
-		if single {
-			break
+	// Initialization commands and scripts behave as if typed by the user,
+	// but results are not printed.
+	if !opts.norc {
+		if err := runRC(c); err != nil && !errors.Is(err, rpn.ErrQuit) {
+			fmt.Printf(rpn.ErrorMsg("ERROR: %v\n"), err)
+		}
+	}
+	for _, line := range opts.initCmds {
+		_, err := c.Exec(line)
+		if errors.Is(err, rpn.ErrQuit) {
+			return nil
+		}
+		if err != nil {
+			fmt.Printf(rpn.ErrorMsg("ERROR: %q: %v\n"), line, err)
+		}
+	}
+	for _, fname := range opts.scripts {
+		err := runScript(c, fname)
+		if errors.Is(err, rpn.ErrQuit) {
+			return nil
+		}
+		if err != nil {
+			fmt.Printf(rpn.ErrorMsg("ERROR: %v\n"), err)
+		}
+	}
+	c.SetStrict(false)
+
+	// Serve connections on a unix socket instead of the terminal?
+	if opts.listen != "" {
+		return listen(c, opts.listen)
+	}
+
+	if err := interactive(c, opts); err != nil {
+		return err
+	}
+
+	if opts.persist {
+		return saveSession(c)
+	}
+	return nil
+}
+
+// onlyNumbers returns true if line contains only numbers.
+func onlyNumbers(c *rpn.Calculator, line string) bool {
+	nums, err := c.ParseNumbers(line)
+	return err == nil && len(nums) > 0
+}
+
+// noColor returns true if colors are disabled with --no-color or the
+// NO_COLOR environment variable (https://no-color.org).
+func noColor(opts optionsType) bool {
+	return opts.noColor || os.Getenv("NO_COLOR") != ""
+}
+
+// isTerminal returns true if f is a terminal (character device).
+func isTerminal(f *os.File) bool {
+	fi, err := f.Stat()
+	return err == nil && fi.Mode()&os.ModeCharDevice != 0
+}
+
+// validateOptions checks the values of the command-line options.
+func validateOptions(opts optionsType) error {
+	if opts.leftover != "ignore" && opts.leftover != "warn" && opts.leftover != "fail" {
+		return fmt.Errorf("invalid value for --leftover: %q", opts.leftover)
+	}
+	if opts.decimals.set && opts.decimals.n < 0 {
+		return fmt.Errorf("invalid value for --decimals: %d", opts.decimals.n)
+	}
+	// The calculator knows the valid ranges.
+	c := rpn.New()
+	if opts.prec.set {
+		if err := c.SetPrecision(opts.prec.n); err != nil {
+			return fmt.Errorf("invalid value for --prec: %d (%v)", opts.prec.n, err)
+		}
+	}
+	if opts.obase.set {
+		if err := c.SetBase(opts.obase.n); err != nil {
+			return fmt.Errorf("invalid value for --base: %d (%v)", opts.obase.n, err)
+		}
+	}
+	if opts.ibase.set {
+		if err := c.SetInputBase(opts.ibase.n); err != nil {
+			return fmt.Errorf("invalid value for --ibase: %d (%v)", opts.ibase.n, err)
+		}
+	}
+	if strings.Contains(opts.format, "{{") {
+		if _, err := template.New("format").Parse(opts.format); err != nil {
+			return fmt.Errorf("invalid value for --format: %w", err)
 		}
 	}
 	return nil
 }
 
+// parseFlags parses the command-line arguments and returns the options and
+// the remaining (non-flag) arguments. Parsing stops at the first argument
+// that is not a flag, including negative numbers, so "rpn -5 3 +" still works.
+func parseFlags(args []string) (optionsType, []string, error) {
+	opts := optionsType{}
+
+	fs := flag.NewFlagSet("rpn", flag.ContinueOnError)
+	fs.Var(&opts.initCmds, "init", "Run commands before entering interactive mode (may be repeated)")
+	fs.Var(&opts.exprs, "e", "Evaluate an expression and print the result (may be repeated, sharing the stack)")
+	fs.BoolVar(&opts.interactive, "i", false, "Run the script files passed as arguments and enter interactive mode")
+	fs.BoolVar(&opts.strict, "strict", true, "Abort single-command and script execution on unknown tokens")
+	fs.StringVar(&opts.leftover, "leftover", "ignore", "Action when single-command execution leaves more than one item in the stack: ignore, warn, or fail")
+	fs.StringVar(&opts.file, "f", "", "Execute the script file and print the result")
+	fs.BoolVar(&opts.norc, "norc", false, "Don't run the startup file (~/.rpnrc) before entering interactive mode")
+	fs.BoolVar(&opts.keepGoing, "keep-going", false, "Report errors in script files and continue with the next line")
+	fs.BoolVar(&opts.check, "check", false, "Validate the script files passed as arguments without executing them")
+	fs.BoolVar(&opts.cont, "cont", false, "Push the result saved by the previous single-command execution (see --save-last) before running")
+	fs.BoolVar(&opts.saveLast, "save-last", false, "Save the result of single-command execution, to be used with --cont")
+	fs.BoolVar(&opts.persist, "persist", false, "Save the stack and modes on exit and restore them on the next interactive session")
+	fs.BoolVar(&opts.noColor, "no-color", false, "Disable colors in all output (also disabled by the NO_COLOR environment variable)")
+	fs.StringVar(&opts.format, "format", "", "Output format of single-command and -f execution: a printf-style format (E.g. %.2f) or a template (E.g. '{{.Raw}} {{.Human}}')")
+	fs.BoolVar(&opts.stdin, "stdin", false, "Read one expression per line from stdin and print one result per line (default when stdin is not a terminal)")
+	fs.StringVar(&opts.apply, "apply", "", "Push all numbers read from stdin and apply an operation (E.g. sum, mean, max)")
+	fs.BoolVar(&opts.quiet, "quiet", false, "Suppress warnings, notes and decorations, printing only bare results")
+	fs.BoolVar(&opts.quiet, "q", false, "Same as --quiet")
+	fs.BoolVar(&opts.infix, "infix", false, "Evaluate infix (algebraic) expressions (E.g. '(2+3)*4^2'), as in alg mode")
+	fs.BoolVar(&opts.version, "version", false, "Print the version and exit")
+	fs.BoolVar(&opts.force, "force", false, "Let selfupdate replace development builds and newer versions with the latest release")
+	fs.Var(&opts.decimals, "decimals", "Number of decimals in results (as in fmt)")
+	fs.Var(&opts.prec, "prec", "Working precision in significant digits (as in prec)")
+	fs.Var(&opts.obase, "base", "Output base, from 2 to 36 (E.g. 16 for hexadecimal)")
+	fs.Var(&opts.obase, "obase", "Same as --base")
+	fs.Var(&opts.ibase, "ibase", "Base for numbers entered without a prefix, from 2 to 36")
+	fs.StringVar(&opts.config, "config", "", "Read defaults from this configuration file instead of $XDG_CONFIG_HOME/rpn/config")
+	fs.StringVar(&opts.listen, "listen", "", "Accept commands on a unix socket at this path, sharing one stack")
+
+	// Find the first argument that is not a flag or a flag value.
+	ix := 0
+	for ix < len(args) {
+		arg := args[ix]
+		if arg == "--" || arg == "-" || !strings.HasPrefix(arg, "-") {
+			break
+		}
+		if _, err := rpn.ParseNumber(arg); err == nil {
+			break
+		}
+		ix++
+
+		// Skip the value of non-boolean flags, unless passed as --flag=value.
+		name := strings.TrimLeft(arg, "-")
+		if strings.Contains(name, "=") {
+			continue
+		}
+		if f := fs.Lookup(name); f != nil {
+			if b, ok := f.Value.(interface{ IsBoolFlag() bool }); !ok || !b.IsBoolFlag() {
+				ix++
+			}
+		}
+	}
+	ix = min(ix, len(args))
+
+	if err := fs.Parse(args[:ix]); err != nil {
+		return opts, nil, err
+	}
+	// Unlike parsing errors, validation errors are not printed by fs.
+	if err := validateOptions(opts); err != nil {
+		fmt.Fprintln(fs.Output(), err)
+		return opts, nil, err
+	}
+	rest := args[ix:]
+	if len(rest) > 0 && rest[0] == "--" {
+		rest = rest[1:]
+	}
+	return opts, rest, nil
+}
+
 func main() {
-	stack := &stackType{}
+	rpn.Build = Build
+
+	opts, args, err := parseFlags(os.Args[1:])
+	if err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			os.Exit(0)
+		}
+		os.Exit(2)
+	}
+
+	if opts.version {
+		fmt.Println("rpn version:", rpn.Version())
+		return
+	}
+
+	quiet = opts.quiet
+
+	// Disable colors everywhere (https://no-color.org), and when the output
+	// is not a terminal (E.g. redirected to a file).
+	if noColor(opts) || !isTerminal(os.Stdout) {
+		color.NoColor = true
+	}
+
+	cfg, err := loadConfig(opts.config)
+	if err != nil {
+		log.Fatal(err)
+	}
+	opts.cfg = cfg
+
+	// Operations from plugins must be registered before creating the stack.
+	if dir, err := pluginDir(); err == nil {
+		if err := loadPlugins(dir); err != nil {
+			fmt.Fprintln(os.Stderr, rpn.WarnMsg("Error loading plugins: ", err))
+		}
+	}
+
+	// Custom stack colors. RPN_COLORS overrides the configuration file.
+	if cfg.colors != "" {
+		if err := rpn.SetColors(cfg.colors); err != nil {
+			fmt.Fprintln(os.Stderr, rpn.WarnMsg("Ignoring colors in the configuration file: ", err))
+		}
+	}
+	if spec := os.Getenv("RPN_COLORS"); spec != "" {
+		if err := rpn.SetColors(spec); err != nil {
+			fmt.Fprintln(os.Stderr, rpn.WarnMsg("Ignoring RPN_COLORS: ", err))
+		}
+	}
+
+	// Update the binary to the latest release and exit.
+	if len(args) == 1 && args[0] == "selfupdate" {
+		exe, err := os.Executable()
+		if err == nil {
+			exe, err = filepath.EvalSymlinks(exe)
+		}
+		if err == nil {
+			err = selfUpdate(os.Stdout, releasesURL, exe, Build, opts.force)
+		}
+		if err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	// Validate scripts and exit.
+	if opts.check {
+		ok, err := checkScripts(os.Stdout, args, opts)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if !ok {
+			os.Exit(1)
+		}
+		return
+	}
+
+	if opts.file != "" && len(args) > 0 {
+		log.Fatalf("Extra arguments not allowed with -f: %q", args)
+	}
+
+	if len(opts.exprs) > 0 && len(args) > 0 {
+		log.Fatalf("Extra arguments not allowed with -e: %q", args)
+	}
+	if opts.apply != "" && len(args) > 0 {
+		log.Fatalf("Extra arguments not allowed with --apply: %q", args)
+	}
+
+	// Without arguments, use filter mode if stdin is not a terminal.
+	if len(args) == 0 && len(opts.exprs) == 0 && opts.apply == "" && !opts.interactive && opts.listen == "" && opts.file == "" && !isTerminal(os.Stdin) {
+		opts.stdin = true
+	}
+	if opts.stdin && len(args) > 0 {
+		log.Fatalf("Extra arguments not allowed with --stdin: %q", args)
+	}
+
+	// With -i or --listen, arguments are script files to run first.
+	cmd := strings.Join(args, " ")
+	if opts.interactive || opts.listen != "" {
+		opts.scripts = args
+		cmd = ""
+	}
 
-	if err := calc(stack, strings.Join(os.Args[1:], " ")); err != nil {
+	if err := calc(rpn.New(), cmd, opts); err != nil {
 		log.Fatal(err)
 	}
 }
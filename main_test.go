@@ -6,248 +6,657 @@
 package main
 
 import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
 	"os"
+	"path/filepath"
+	"runtime"
 	"strings"
 	"testing"
 
 	"github.com/ericlagergren/decimal"
+	"github.com/fatih/color"
+	"github.com/marcopaganini/rpn/internal/xdg"
+	"github.com/marcopaganini/rpn/pkg/rpn"
 )
 
-// This precision is used to compare results in tests.  Use two digits less
-// than the max (34) to simplify rounding issues.
-const defaultTestPrecision = 32
+// TestMain keeps the tests from writing to the user's cache and state
+// directories.
+func TestMain(m *testing.M) {
+	dir, err := os.MkdirTemp("", "rpn-test")
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	os.Setenv("XDG_CACHE_HOME", filepath.Join(dir, "cache"))
+	os.Setenv("XDG_STATE_HOME", filepath.Join(dir, "state"))
+	code := m.Run()
+	os.RemoveAll(dir)
+	os.Exit(code)
+}
+
+// bigUint returns a *decimal.Big from an uint64.
+func bigUint(n uint64) *decimal.Big {
+	return new(decimal.Big).SetUint64(n)
+}
+
+// top returns the top of the stack in c, failing the test if it's empty.
+func top(t *testing.T, c *rpn.Calculator) *decimal.Big {
+	t.Helper()
+	x, err := c.Top()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return x
+}
+
+func TestRunScript(t *testing.T) {
+	fname := filepath.Join(t.TempDir(), "script.rpn")
+	script := "# Comment\n1 2 +\n\n10 *\n"
+	if err := os.WriteFile(fname, []byte(script), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	c := rpn.New()
+	if err := runScript(c, fname); err != nil {
+		t.Fatalf("Got error %q, want no error", err)
+	}
+	if x := top(t, c); x.Cmp(bigUint(30)) != 0 {
+		t.Fatalf("diff: want: 30, got: %s", x)
+	}
+
+	// Errors must report the file and line number.
+	if err := os.WriteFile(fname, []byte("1\n0 fmt\n-1 fmt\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	err := runScript(c, fname)
+	if err == nil || !strings.HasPrefix(err.Error(), fname+":3:") {
+		t.Fatalf("diff: want error at %s:3, got: %v", fname, err)
+	}
+
+	if err := runScript(c, filepath.Join(t.TempDir(), "missing.rpn")); err == nil {
+		t.Fatalf("Got no error for missing file, want error")
+	}
+
+	// Keep going after errors (--keep-going or "set onerror continue").
+	if err := os.WriteFile(fname, []byte("1\nfoo\n2 +\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	for _, keepGoing := range []string{"--keep-going", "set onerror continue"} {
+		c = rpn.New()
+		c.SetStrict(true)
+		if keepGoing == "--keep-going" {
+			c.SetKeepGoing(true)
+		} else if err := c.Eval(keepGoing); err != nil {
+			t.Fatal(err)
+		}
+		if err := runScript(c, fname); err != nil {
+			t.Fatalf("diff: %s: got error %q, want no error", keepGoing, err)
+		}
+		if x := top(t, c); x.Cmp(bigUint(3)) != 0 {
+			t.Fatalf("diff: %s: want: 3, got: %s", keepGoing, x)
+		}
+	}
+	if err := c.Eval("set onerror foo"); err == nil {
+		t.Fatalf("Got no error for invalid onerror value, want error")
+	}
+}
 
-func TestRPN(t *testing.T) {
-	ctx := decimal.Context128
+func TestScriptFile(t *testing.T) {
+	fname := filepath.Join(t.TempDir(), "script.rpn")
+	script := "# Price with taxes\n100 2 *\n1.1 *\n"
+	if err := os.WriteFile(fname, []byte(script), 0644); err != nil {
+		t.Fatal(err)
+	}
+	c := rpn.New()
+	if err := calc(c, "", optionsType{file: fname, strict: true}); err != nil {
+		t.Fatalf("Got error %q, want no error", err)
+	}
+	if x := top(t, c); x.Cmp(bigUint(220)) != 0 {
+		t.Fatalf("diff: want: 220, got: %s", x)
+	}
 
+	if err := os.WriteFile(fname, []byte("1 2\nfoo\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := calc(rpn.New(), "", optionsType{file: fname, strict: true}); err == nil {
+		t.Fatalf("Got no error, want error")
+	}
+}
+
+func TestRunRC(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+
+	// A missing startup file is not an error.
+	c := rpn.New()
+	if err := runRC(c); err != nil {
+		t.Fatalf("Got error %q, want no error", err)
+	}
+
+	rc := "# Defaults\ndeg 4 fmt\ndef half [ 2 / ]\n"
+	if err := os.WriteFile(filepath.Join(home, ".rpnrc"), []byte(rc), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := runRC(c); err != nil {
+		t.Fatalf("Got error %q, want no error", err)
+	}
+	if got := c.Prompt("%a %p"); got != "deg 4" {
+		t.Fatalf("diff: want modes: %q, got: %q", "deg 4", got)
+	}
+	if err := c.Eval("10 half"); err != nil {
+		t.Fatalf("Got error %q with macro \"half\", want no error", err)
+	}
+}
+
+func TestServer(t *testing.T) {
+	c := rpn.New()
+	srv := &server{calc: c}
+
+	client, conn := net.Pipe()
+	go srv.serve(conn)
+
+	want := []string{"= 3", "= 30", "ERROR: this operation requires at least 2 items in the stack", "Bye."}
+	scanner := bufio.NewScanner(client)
+	for i, line := range []string{"1 2 +", "10 *", "c +", "q"} {
+		fmt.Fprintln(client, line)
+		if !scanner.Scan() {
+			t.Fatalf("Unexpected end of connection: %v", scanner.Err())
+		}
+		if got := scanner.Text(); got != want[i] {
+			t.Fatalf("diff: input: %q, want: %q, got: %q", line, want[i], got)
+		}
+	}
+	if x := top(t, c); x.Cmp(bigUint(30)) != 0 {
+		t.Fatalf("diff: want stack top: 30, got: %s", x)
+	}
+}
+
+func TestCheckLeftover(t *testing.T) {
+	c := rpn.New()
+	c.Push(bigUint(1))
+	for _, action := range []string{"ignore", "warn", "fail"} {
+		if err := checkLeftover(c, action); err != nil {
+			t.Fatalf("diff: action: %s, got error %q with one item, want no error", action, err)
+		}
+	}
+
+	c.Push(bigUint(2))
+	if err := checkLeftover(c, "warn"); err != nil {
+		t.Fatalf("Got error %q, want no error", err)
+	}
+	if err := checkLeftover(c, "fail"); err == nil {
+		t.Fatalf("Got no error, want error")
+	}
+}
+
+func TestContinue(t *testing.T) {
+	t.Setenv("XDG_STATE_HOME", t.TempDir())
+
+	if err := calc(rpn.New(), "2 *", optionsType{cont: true}); err == nil {
+		t.Fatalf("Got no error without a previous result, want error")
+	}
+	// Results are only saved when asked to.
+	if err := calc(rpn.New(), "5 3 +", optionsType{}); err != nil {
+		t.Fatalf("Got error %q, want no error", err)
+	}
+	if _, err := loadLastResult(); err == nil {
+		t.Fatalf("Got a saved result without --save-last, want error")
+	}
+	if err := calc(rpn.New(), "5 3 +", optionsType{saveLast: true}); err != nil {
+		t.Fatalf("Got error %q, want no error", err)
+	}
+	c := rpn.New()
+	if err := calc(c, "2 *", optionsType{cont: true}); err != nil {
+		t.Fatalf("Got error %q, want no error", err)
+	}
+	if x := top(t, c); x.Cmp(bigUint(16)) != 0 {
+		t.Fatalf("diff: want stack top: 16, got: %s", x)
+	}
+
+	// The new result is saved as well.
+	n, err := loadLastResult()
+	if err != nil {
+		t.Fatalf("Got error %q, want no error", err)
+	}
+	if n.Cmp(bigUint(16)) != 0 {
+		t.Fatalf("diff: want last result: 16, got: %s", n)
+	}
+}
+
+func TestSession(t *testing.T) {
+	t.Setenv("XDG_STATE_HOME", t.TempDir())
+
+	// No session file is not an error.
+	c := rpn.New()
+	if err := loadSession(c); err != nil {
+		t.Fatalf("Got error %q, want no error", err)
+	}
+
+	if err := c.Eval("1 2.5 deg"); err != nil {
+		t.Fatalf("Got error %q, want no error", err)
+	}
+	if err := saveSession(c); err != nil {
+		t.Fatalf("Got error %q, want no error", err)
+	}
+	c = rpn.New()
+	if err := loadSession(c); err != nil {
+		t.Fatalf("Got error %q, want no error", err)
+	}
+	if got := c.Prompt("%n %a"); got != "2 deg" {
+		t.Fatalf("diff: want: %q, got: %q", "2 deg", got)
+	}
+
+	// Errors in the session file report the file name.
+	fname, err := sessionFile()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(fname, []byte("foo"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := loadSession(c); err == nil || !strings.HasPrefix(err.Error(), fname+":") {
+		t.Fatalf("diff: want error from %s, got: %v", fname, err)
+	}
+}
+
+func TestVars(t *testing.T) {
+	t.Setenv("XDG_STATE_HOME", t.TempDir())
+
+	if err := calc(rpn.New(), "85.5 sto rate 2 sto fx save", optionsType{}); err != nil {
+		t.Fatalf("Got error %q, want no error", err)
+	}
+	fname, err := xdg.StateFile("vars")
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(fname)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "fx 2\nrate 85.5\n"; string(data) != want {
+		t.Fatalf("diff: want vars file: %q, got: %q", want, data)
+	}
+
+	// Registers are restored on startup.
+	c := rpn.New()
+	if err := calc(c, "rcl rate rcl fx *", optionsType{}); err != nil {
+		t.Fatalf("Got error %q, want no error", err)
+	}
+	if x := top(t, c); x.Cmp(bigUint(171)) != 0 {
+		t.Fatalf("diff: want stack top: 171, got: %s", x)
+	}
+
+	// Invalid vars file.
+	if err := os.WriteFile(fname, []byte("rate\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := rpn.New().LoadRegisters(); err == nil {
+		t.Fatalf("Got no error with an invalid vars file, want error")
+	}
+
+	if err := calc(rpn.New(), "purge", optionsType{}); err != nil {
+		t.Fatalf("Got error %q, want no error", err)
+	}
+	if err := calc(rpn.New(), "rcl rate", optionsType{}); err == nil {
+		t.Fatalf("Got no error after purge, want error")
+	}
+}
+
+func TestCheckScripts(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	if err := os.WriteFile(filepath.Join(home, ".rpnrc"), []byte("def half [ 2 / ]\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	fname := filepath.Join(t.TempDir(), "script.rpn")
+	if err := os.WriteFile(fname, []byte("ff double half\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := parseConfig(strings.NewReader("[aliases]\ndouble = \"2 *\"\n"), "config")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Aliases, macros from the startup file and the input base are known.
+	var out bytes.Buffer
+	opts := optionsType{cfg: cfg, ibase: intFlag{n: 16, set: true}}
+	ok, err := checkScripts(&out, []string{fname}, opts)
+	if err != nil {
+		t.Fatalf("Got error %q, want no error", err)
+	}
+	if !ok || out.Len() != 0 {
+		t.Fatalf("diff: want no problems, got: %q", out.String())
+	}
+
+	// Without them, all three tokens are unknown.
+	out.Reset()
+	ok, err = checkScripts(&out, []string{fname}, optionsType{norc: true})
+	if err != nil {
+		t.Fatalf("Got error %q, want no error", err)
+	}
+	if ok || strings.Count(out.String(), "not a number or operator") != 3 {
+		t.Fatalf("diff: want 3 problems, got: %q", out.String())
+	}
+}
+
+func TestSelfUpdate(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("test uses tar.gz archives")
+	}
+
+	// Create a fake release archive containing the new binary.
+	newBinary := []byte("#!/bin/sh\necho new\n")
+	archiveName := fmt.Sprintf("rpn_9.9.9_%s_%s.tar.gz", runtime.GOOS, runtime.GOARCH)
+	var archive bytes.Buffer
+	gz := gzip.NewWriter(&archive)
+	tw := tar.NewWriter(gz)
+	tw.WriteHeader(&tar.Header{Name: "rpn", Mode: 0755, Size: int64(len(newBinary)), Typeflag: tar.TypeReg})
+	tw.Write(newBinary)
+	tw.Close()
+	gz.Close()
+	sum := sha256.Sum256(archive.Bytes())
+	sums := hex.EncodeToString(sum[:]) + "  " + archiveName + "\n"
+
+	var srv *httptest.Server
+	srv = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/latest":
+			fmt.Fprintf(w, `{"tag_name": "v9.9.9", "assets": [
+				{"name": %q, "browser_download_url": "%s/archive"},
+				{"name": "rpn_9.9.9_checksums.txt", "browser_download_url": "%s/sums"}]}`, archiveName, srv.URL, srv.URL)
+		case "/archive":
+			w.Write(archive.Bytes())
+		case "/sums":
+			fmt.Fprint(w, sums)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+
+	exe := filepath.Join(t.TempDir(), "rpn")
+	if err := os.WriteFile(exe, []byte("old"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	casetests := []struct {
+		current   string
+		force     bool
+		want      string
+		wantError bool
+	}{
+		{current: "1.0.0", want: string(newBinary)},
+		{current: "9.9.9-rc.1", want: string(newBinary)},
+		{current: "9.9.9", want: "old"},
+		{current: "10.0.0", want: "old", wantError: true},
+		{current: "9.10.0", want: "old", wantError: true},
+		{current: "10.0.0", force: true, want: string(newBinary)},
+		{current: "", want: "old", wantError: true},
+		{current: "abc1234", want: "old", wantError: true},
+		{current: "", force: true, want: string(newBinary)},
+	}
+	for _, tt := range casetests {
+		if err := os.WriteFile(exe, []byte("old"), 0755); err != nil {
+			t.Fatal(err)
+		}
+		err := selfUpdate(io.Discard, srv.URL+"/latest", exe, tt.current, tt.force)
+		if tt.wantError != (err != nil) {
+			t.Fatalf("current: %q, force: %v, want error: %v, got: %v", tt.current, tt.force, tt.wantError, err)
+		}
+		got, err := os.ReadFile(exe)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(got) != tt.want {
+			t.Fatalf("diff: current: %q, force: %v, want: %q, got: %q", tt.current, tt.force, tt.want, got)
+		}
+	}
+
+	// Checksum mismatches must not replace the executable.
+	os.WriteFile(exe, []byte("old"), 0755)
+	sums = strings.Repeat("0", 64) + "  " + archiveName + "\n"
+	if err := selfUpdate(io.Discard, srv.URL+"/latest", exe, "1.0.0", false); err == nil {
+		t.Fatalf("Got no error on checksum mismatch, want error")
+	}
+	if got, _ := os.ReadFile(exe); string(got) != "old" {
+		t.Fatalf("diff: executable replaced after checksum mismatch")
+	}
+}
+
+func TestSemver(t *testing.T) {
+	casetests := []struct {
+		a, b string
+		want int
+	}{
+		{"1.2.3", "1.2.3", 0},
+		{"v1.2.3", "1.2.3+build.5", 0},
+		{"1.2.3", "1.2.4", -1},
+		{"1.10.0", "1.9.9", 1},
+		{"2.0.0", "10.0.0", -1},
+		{"1.0.0-rc.1", "1.0.0", -1},
+		{"1.0.0-alpha", "1.0.0-alpha.1", -1},
+		{"1.0.0-alpha.1", "1.0.0-alpha.beta", -1},
+		{"1.0.0-beta.2", "1.0.0-beta.11", -1},
+		{"1.0.0-rc.1", "1.0.0-beta.11", 1},
+	}
+	for _, tt := range casetests {
+		a, err := parseSemver(tt.a)
+		if err != nil {
+			t.Fatalf("%q: got error: %v", tt.a, err)
+		}
+		b, err := parseSemver(tt.b)
+		if err != nil {
+			t.Fatalf("%q: got error: %v", tt.b, err)
+		}
+		if got := a.compare(b); got != tt.want {
+			t.Fatalf("diff: %q vs %q, want: %d, got: %d", tt.a, tt.b, tt.want, got)
+		}
+	}
+	for _, s := range []string{"", "abc1234", "1.2", "1.2.3.4", "1.02.3", "1.2.x", "1.2.3-", "1.2.3-rc..1"} {
+		if _, err := parseSemver(s); err == nil {
+			t.Fatalf("%q: got no error, want error", s)
+		}
+	}
+}
+
+func TestRunFilter(t *testing.T) {
 	casetests := []struct {
 		input     string
-		want      *decimal.Big
+		keepGoing bool
+		want      string
 		wantError bool
-		precision int
 	}{
-		// Note: We use a "continuous" stack across operations.
-		// Basic operations.
-		{input: "1 2 +", want: bigUint(3)},
-		{input: "4 5 8 + + +", want: bigUint(20)},
-		{input: "5 -", want: bigUint(15)},
-		{input: "3 /", want: bigUint(5)},
-		{input: "3.5 *", want: bigFloat("17.5")},
-		{input: "2 *", want: bigFloat("35.0")},
-		{input: "chs", want: bigFloat("-35.0")},
-		{input: "inv", want: bigFloat("-0.02857142857142857142857142857142857")},
-		{input: "inv", want: bigFloat("-35.00000000000000000000000000000000")},
-		{input: "chs", want: bigUint(35)},
-		{input: "3 ^", want: bigUint(42875)},
-		{input: "10 mod", want: bigUint(5)},
-		{input: "fac", want: bigUint(120)},
-		{input: "10 %", want: bigUint(12)},
-		{input: "2 ^", want: bigUint(144)},
-		{input: "sqr", want: bigUint(12)},
-		{input: "3 ^", want: bigUint(1728)},
-		{input: "cbr", want: bigUint(12)},
-		{input: "c 1 2 3 4 sum", want: bigUint(10)},
-		{input: "c 1 2 x", want: bigUint(1)},
-		{input: "x", want: bigUint(2)},
-		{input: "c", want: bigUint(0)},
-		{input: "0.25 4 *", want: bigUint(1)},
-		{input: ".2 5 *", want: bigUint(1)},
-		{input: "$2,500.00 €3,500.00 +", want: bigUint(6000)},
-		{input: "2 12345 ^", want: bigFloat(
-			"164171010688258216356020741663906501410127235530735881272116" +
-				"103087925094171390144280159034536439457734870419127140401667" +
-				"195510331085657185332721089236401193044493457116299768844344" +
-				"303479235489462436380672117015123283299131391904179287678259" +
-				"173308536738761981139958654880852234908448338817289014166774" +
-				"169869251339379828599748492918775437864739032217778051333882" +
-				"990074116246281269364933724892342134504702491040016637557429" +
-				"810893780765197418589477584716543480995722533317862352141459" +
-				"217781316266211186486157019262080414077670264642736018426998" +
-				"113523445732680856144329876972273300703392584997729207197971" +
-				"083945700345494092400147186997307012069454068489589035676979" +
-				"448169848060836924945824197706493306108258511936030341393221" +
-				"586423523264452449403781993352421885094664052270795527632721" +
-				"896121424813173522474674395886155092203404036730748474781710" +
-				"715745446135468098139831824083259647919175273503681561172684" +
-				"624283384438504776503000432241604550454374116320822227191911" +
-				"322123484085063926350606342197146407841178028071147192533942" +
-				"517270553513988142925976090769695456221159699052583533011331" +
-				"652079347093098173086975483539274464023357456484465482927479" +
-				"569437320368592222760278170306076733438801098370797675711274" +
-				"671054970711442158930561684343135774118741594506702833147396" +
-				"758825015850042983343690345185995956235143825771620543546030" +
-				"664562647854656431302644574119873820215595718618624485232422" +
-				"006575550007068883734241454686368856734496265385908809403972" +
-				"494685137741122866896719678053937285818409751670320140501843" +
-				"039224040735870096889596273419106389103662095318937990625980" +
-				"136711988237421962315266686856089505981438440850638067589321" +
-				"141759499017023839596858455548192000140085142294166987063499" +
-				"024792681334843159790936321351919859758669569200541507612099" +
-				"780909705198902176026219872201715422096090343686272984351441" +
-				"594569506778041062663266799342793856313801540959815845788584" +
-				"759033248828248561586450271172777240971795656082001848115815" +
-				"260930521663167480173886064019118572778281516735157779555888" +
-				"167787064432558595410843987446497881666288423233170060413025" +
-				"924629950477303342180149398926073618582715358742250388958231" +
-				"281694757980523791263699450732952325727664209947786063982561" +
-				"775327638504516918570101319391698412388607603742484414748268" +
-				"389669129118026878969735782286841116842656410574647607524418" +
-				"900720328045377993386279808768990376289424757351052369393977" +
-				"137871998119168898493037938756635621557623138404459266598837" +
-				"784229325799838782026060481496865561757031839002257091802876" +
-				"949248392744175669112242088439883248336310597001257385980776" +
-				"961529351198877747193531054956881808332177946751404038228718" +
-				"567911769630971553915410012677600002457982207465176670752102" +
-				"117002773980548089696530972476439694599881281812973217265853" +
-				"884727906535479745854085338851105144585481994156206497436745" +
-				"899944877732531412541279014300324594890623941145509856940982" +
-				"863769834430048120562966797907114102689879364945689860493474" +
-				"954538422367719507882513166051007352994068319251450666676648" +
-				"368200564329382998758875760414259654004977261309988267319806" +
-				"354856051784553990936610634733375984159028722378614984450255" +
-				"386315585631994503350002142910493190254825610707400589976364" +
-				"985748467955131077971641882672895854571236368282811336220769" +
-				"174784720113331269084746524204124263475054112841630933586166" +
-				"195036115696469686075600480420563557567616835633252622327172" +
-				"811002146392754445051182169805284630259703542633955126179520" +
-				"113059629914229833688535925729676778028406897316106101038469" +
-				"119090984567152591962365415039646394591503830797626339246986" +
-				"057077758611413664914168745375266786298141171496573941614387" +
-				"744125843685677063619782918759823106021054037757857761587472" +
-				"240835040580447360544029064930412569943169729238102162312218" +
-				"687930203068055400275795180972382856696655279408212344832"), precision: 34},
-		{input: "10 6144 ^", want: bigFloat("1" + strings.Repeat("0", 6144)), precision: 34},
-		{input: "10 6145 ^", want: bigFloat("+Infinity")},
-		{input: "10 34 ^ 1 -", want: bigFloat("9999999999999999999999999999999999")},
-		{input: "c", want: bigUint(0)},
-		// Invalid operator should not cause changes to stack.
-		{input: "foobar", want: bigUint(0)},
-
-		// Trigonometric functions.
-		{input: "deg 90 sin", want: bigFloat("1")},
-		{input: "rad 90 PI * 180 / sin", want: bigUint(1)},
-		{input: "deg 0 cos", want: bigUint(1)},
-		{input: "rad 60 PI * 180 / cos", want: bigFloat("0.5")},
-		{input: "deg 30 tan", want: func() *decimal.Big { // Sqrt(3) / 3)
-			square := big().Quo(bigUint(1), bigUint(2))
-			z := ctx.Pow(big(), bigUint(3), square)
-			return z.Quo(z, bigUint(3))
-		}()},
-		{input: "rad 60 PI * 180 / tan", want: ctx.Pow(big(), bigUint(3), big().Quo(bigUint(1), bigUint(2)))}, // Sqrt(3)
-		{input: "deg -1 180 * PI / asin", want: big().Quo(ctx.Pi(big()), bigUint(2)).SetSignbit(true)},        // -Pi / 2
-		{input: "rad -1 asin", want: big().Quo(ctx.Pi(big()), bigUint(2)).SetSignbit(true)},                   // -Pi / 2
-		{input: "rad 0 acos", want: big().Quo(ctx.Pi(big()), bigUint(2))},                                     // Pi / 2
-		{input: "rad 1 acos", want: bigUint(0)},
-		{input: "deg 0.5 180 * PI / acos", want: big().Quo(ctx.Pi(big()), bigUint(3))}, // Pi / 3
-		{input: "rad 3 sqr atan", want: big().Quo(ctx.Pi(big()), bigUint(3))},          // Pi / 3
-		{input: "deg 1 180 * PI / atan", want: big().Quo(ctx.Pi(big()), bigUint(4))},   // Pi / 4
-
-		// Log functions
-		{input: "c E ln", want: bigUint(1)},
-		{input: "c 1000 log", want: bigUint(3)},
-
-		// Bitwise operations and base input modes.
-		{input: "0x00ff 0xff00 or", want: bigUint(0xffff)},
-		{input: "0x0ff0 and", want: bigUint(0x0ff0)},
-		{input: "0x1ee1 xor", want: bigUint(0x1111)},
-		{input: "1 lshift", want: bigUint(0x2222)},
-		{input: "1 lshift", want: bigUint(0x4444)},
-		{input: "2 rshift", want: bigUint(0x1111)},
-		{input: "0b00100010 0B01000100 015 o20 0x1000 0x2000 + + + + +", want: bigUint(12419)},
-		{input: "c", want: bigUint(0)},
-
-		// Bitwise operations and base input modes.
-		{input: "0x00ff 0xff00 or", want: bigUint(0xffff)},
-		{input: "0x0ff0 and", want: bigUint(0x0ff0)},
-		{input: "0x1ee1 xor", want: bigUint(0x1111)},
-		{input: "1 lshift", want: bigUint(0x2222)},
-		{input: "1 lshift", want: bigUint(0x4444)},
-		{input: "2 rshift", want: bigUint(0x1111)},
-		{input: "0b00100010 0B01000100 015 o20 0x1000 0x2000 + + + + +", want: bigUint(12419)},
-		{input: "d d", want: bigUint(0)},
-
-		// Miscellaneous operations
-		{input: "212 f2c", want: bigUint(100)},
-		{input: "c2f", want: bigUint(212)},
-		{input: "-40 f2c", want: bigFloat("-40")},
-		{input: "-10 c2f", want: bigUint(14)},
-		{input: "0 c2f", want: bigUint(32)},
-		{input: "c", want: bigUint(0)},
-		{input: "1 dup dup sum", want: bigUint(3)},
-		{input: "c", want: bigUint(0)},
-	}
-
-	stack := &stackType{}
+		{input: "3 4 *\n10 2 /\n", want: "12\n5\n"},
+		{input: "1 2 +\n\n# comment\n5\n", want: "3\n5\n"},
+		{input: "1 2 +\n3 *\n", want: "3\n", wantError: true},
+		{input: "1 2 +\nfoo\n2 2 *\n", want: "3\n", wantError: true},
+		{input: "1 2 +\nfoo\n2 2 *\n", keepGoing: true, want: "3\n4\n"},
+		{input: "1 2 +\nq\n2 2 *\n", want: "3\n"},
+	}
+	for _, tt := range casetests {
+		c := rpn.New()
+		c.SetStrict(true)
+		c.SetKeepGoing(tt.keepGoing)
 
+		buf := &bytes.Buffer{}
+		err := runFilter(c, strings.NewReader(tt.input), buf, "")
+		if tt.wantError != (err != nil) {
+			t.Fatalf("input: %q, want error: %v, got: %v", tt.input, tt.wantError, err)
+		}
+		if !tt.wantError && buf.String() != tt.want {
+			t.Fatalf("diff: input: %q, want: %q, got: %q", tt.input, tt.want, buf.String())
+		}
+	}
+}
+
+func TestRunApply(t *testing.T) {
+	casetests := []struct {
+		input     string
+		expr      string
+		want      string
+		wantError bool
+	}{
+		{input: "1\n2\n3\n4\n", expr: "sum", want: "10\n"},
+		{input: "1 2 3 4\n", expr: "mean", want: "2.5\n"},
+		{input: "3 9 2\n# comment\n5\n", expr: "max", want: "9\n"},
+		{input: "3 9 2\n", expr: "fold min", want: "2\n"},
+		{input: "2 3 4\n", expr: "*", want: "24\n"},
+		{input: "1 foo 3\n", expr: "sum", wantError: true},
+		{input: "", expr: "sum", wantError: true},
+	}
 	for _, tt := range casetests {
-		err := calc(stack, tt.input)
-		if !tt.wantError {
-			if err != nil {
-				t.Fatalf("Got error %q, want no error", err)
-			}
-			precision := defaultTestPrecision
-			if tt.precision != 0 {
-				precision = tt.precision
-			}
-			got := decimal.WithPrecision(precision).Set(stack.top())
-			want := decimal.WithPrecision(precision).Set(tt.want)
-			if got.CmpTotal(want) != 0 {
-				t.Fatalf("diff: input: %s, want: %s, got: %s", tt.input, want, got)
-			}
-			continue
+		c := rpn.New()
+		c.SetStrict(true)
+
+		buf := &bytes.Buffer{}
+		err := runApply(c, strings.NewReader(tt.input), buf, tt.expr, "")
+		if tt.wantError != (err != nil) {
+			t.Fatalf("input: %q, want error: %v, got: %v", tt.input, tt.wantError, err)
+		}
+		if !tt.wantError && buf.String() != tt.want {
+			t.Fatalf("diff: input: %q, expr: %q, want: %q, got: %q", tt.input, tt.expr, tt.want, buf.String())
 		}
+	}
+}
+
+func TestOSC52(t *testing.T) {
+	if got, want := osc52("0.333333"), "\033]52;c;MC4zMzMzMzM=\a"; got != want {
+		t.Fatalf("diff: want: %q, got: %q", want, got)
+	}
+}
 
-		// Here, we want to see an error.
-		if err == nil {
-			t.Errorf("Got no error, want error")
+func TestOnlyNumbers(t *testing.T) {
+	casetests := []struct {
+		input string
+		ibase int
+		want  bool
+	}{
+		{"255", 10, true},
+		{"1 0xff -3.5", 10, true},
+		{"ff", 16, true},
+		{"ff", 10, false},
+		{"1 2 +", 10, false},
+		{"", 10, false},
+	}
+	c := rpn.New()
+	for _, tt := range casetests {
+		if err := c.SetInputBase(tt.ibase); err != nil {
+			t.Fatal(err)
+		}
+		if got := onlyNumbers(c, tt.input); got != tt.want {
+			t.Fatalf("diff: input: %q, ibase: %d, want: %v, got: %v", tt.input, tt.ibase, tt.want, got)
 		}
 	}
 }
 
-func TestFormatNumber(t *testing.T) {
-	ctx := decimal.Context128
+func TestParseConfig(t *testing.T) {
+	casetests := []struct {
+		input     string
+		wantError bool
+	}{
+		{input: ""},
+		{input: "# comment\ndecimals = 4\nbase = 16\nangle = \"deg\"\n"},
+		{input: "colors = 'x=1;36:res=34'\nhistory = 1000 # lines\n"},
+		{input: "save_last = true\n"},
+		{input: "[aliases]\ndouble = \"2 *\"\navg = mean\n"},
+		{input: "foo = 1\n", wantError: true},
+		{input: "decimals\n", wantError: true},
+		{input: "decimals = -1\n", wantError: true},
+		{input: "base = 37\n", wantError: true},
+		{input: "angle = \"grad\"\n", wantError: true},
+		{input: "history = 0\n", wantError: true},
+		{input: "save_last = \"yes\"\n", wantError: true},
+		{input: "angle = \"deg\n", wantError: true},
+		{input: "[foo]\n", wantError: true},
+	}
+	for _, tt := range casetests {
+		_, err := parseConfig(strings.NewReader(tt.input), "config")
+		if tt.wantError != (err != nil) {
+			t.Fatalf("input: %q, want error: %v, got: %v", tt.input, tt.wantError, err)
+		}
+	}
 
+	cfg, _ := parseConfig(strings.NewReader("decimals = 2\nangle = 'deg'\n[aliases]\ndouble = \"2 *\"\n"), "config")
+	c := rpn.New()
+	if err := applyConfig(c, cfg); err != nil {
+		t.Fatalf("got error: %v", err)
+	}
+	if got := c.Prompt("%p %a"); got != "2 deg" {
+		t.Fatalf("diff: unexpected configuration: want: %q, got: %q", "2 deg", got)
+	}
+	if err := c.Eval("21 double"); err != nil {
+		t.Fatalf("got error: %v", err)
+	}
+	if x := top(t, c); x.Cmp(bigUint(42)) != 0 {
+		t.Fatalf("diff: alias \"double\": want: 42, got: %s", x)
+	}
+}
+
+func TestParseFlags(t *testing.T) {
 	casetests := []struct {
-		base  int
-		input *decimal.Big
-		want  string
+		args      []string
+		wantInit  []string
+		wantExprs []string
+		wantArgs  []string
+		wantError bool
 	}{
-		// Decimal
-		{10, bigUint(0), "0"},
-		{10, bigUint(1), "1"},
-		{10, bigUint(999), "999"},
-		{10, bigUint(1000), "1000 (1,000)"},
-		{10, bigUint(1000000), "1000000 (1,000,000)"},
-		{10, bigUint(1000000000000000), "1000000000000000 (1,000,000,000,000,000)"},
-		{10, bigFloat("10000.333333"), "10000.333333 (10,000.333333)"},
-		{10, bigFloat("-10000.333333"), "-10000.333333 (-10,000.333333)"},
-		{10, ctx.Quo(big(), bigUint(567), bigUint(999)), "0.567568"},
-		{10, ctx.Pow(big(), bigUint(2), bigUint(64)), "18446744073709551616 (18,446,744,073,709,551,616)"},
-		{10, ctx.Pow(big(), bigUint(2), bigUint(1234567890)), "Infinity"},
-		{10, ctx.Quo(big(), bigUint(0), bigUint(0)), "NaN"},
-		{10, ctx.Quo(big(), bigUint(1), bigUint(0)), "Infinity"},
-		{10, ctx.Quo(big(), bigFloat("-1"), bigUint(0)), "-Infinity"},
-
-		// Binary
-		{2, bigUint(0b11111111), "0b11111111"},
-		{2, big().Add(bigUint(0b11111111), bigFloat("0.5")), "0b11111111 (truncated from 255.5)"},
-		{2, big().Add(bigUint(0b11111111), bigFloat("0.5")).SetSignbit(true), "-0b11111111 (truncated from -255.5)"},
-
-		// Octal
-		{8, bigUint(0377), "0377"},
-		{8, big().Add(bigUint(0377), bigFloat("0.5")), "0377 (truncated from 255.5)"},
-		{8, big().Add(bigUint(0377), bigFloat("0.5")).SetSignbit(true), "-0377 (truncated from -255.5)"},
-
-		// Hex
-		{16, bigUint(0xff), "0xff"},
-		{16, big().Add(bigUint(0xff), bigFloat("0.5")).SetSignbit(true), "-0xff (truncated from -255.5)"},
+		{args: []string{}, wantArgs: []string{}},
+		{args: []string{"1", "2", "+"}, wantArgs: []string{"1", "2", "+"}},
+		{args: []string{"-5", "3", "+"}, wantArgs: []string{"-5", "3", "+"}},
+		{args: []string{"-0xff", "-"}, wantArgs: []string{"-0xff", "-"}},
+		{args: []string{"--init", "deg 4 fmt"}, wantInit: []string{"deg 4 fmt"}, wantArgs: []string{}},
+		{args: []string{"--init=deg", "-init", "4 fmt", "-1", "2"}, wantInit: []string{"deg", "4 fmt"}, wantArgs: []string{"-1", "2"}},
+		{args: []string{"--init", "-1 chs"}, wantInit: []string{"-1 chs"}, wantArgs: []string{}},
+		{args: []string{"--", "--init", "1"}, wantArgs: []string{"--init", "1"}},
+		{args: []string{"-i", "setup.rpn"}, wantArgs: []string{"setup.rpn"}},
+		{args: []string{"--leftover", "fail", "1"}, wantArgs: []string{"1"}},
+		{args: []string{"--cont", "2", "*"}, wantArgs: []string{"2", "*"}},
+		{args: []string{"--save-last", "5", "3", "+"}, wantArgs: []string{"5", "3", "+"}},
+		{args: []string{"-f", "script.rpn"}, wantArgs: []string{}},
+		{args: []string{"--keep-going", "-f", "script.rpn"}, wantArgs: []string{}},
+		{args: []string{"--format", "{{.Raw}}", "1"}, wantArgs: []string{"1"}},
+		{args: []string{"-e", "2 3 *", "-e", "-1 +"}, wantExprs: []string{"2 3 *", "-1 +"}, wantArgs: []string{}},
+		{args: []string{"--format", "{{.Raw", "1"}, wantError: true},
+		{args: []string{"-q", "1", "2", "+"}, wantArgs: []string{"1", "2", "+"}},
+		{args: []string{"--version"}, wantArgs: []string{}},
+		{args: []string{"--decimals", "2", "--prec=50", "1", "3", "/"}, wantArgs: []string{"1", "3", "/"}},
+		{args: []string{"--decimals", "-1", "1"}, wantError: true},
+		{args: []string{"--decimals", "x", "1"}, wantError: true},
+		{args: []string{"--prec", "0", "1"}, wantError: true},
+		{args: []string{"--base", "16", "255"}, wantArgs: []string{"255"}},
+		{args: []string{"--obase=2", "--ibase", "16", "ff"}, wantArgs: []string{"ff"}},
+		{args: []string{"--base", "37", "1"}, wantError: true},
+		{args: []string{"--ibase", "1", "1"}, wantError: true},
+		{args: []string{"--leftover", "foo", "1"}, wantError: true},
+		{args: []string{"--init"}, wantError: true},
+		{args: []string{"--foobar", "1"}, wantError: true},
 	}
 	for _, tt := range casetests {
-		got := formatNumber(ctx, tt.input, tt.base, 6)
-		if got != tt.want {
-			t.Fatalf("diff: base: %d, input: %v, want: %q, got: %q", tt.base, tt.input, tt.want, got)
+		opts, args, err := parseFlags(tt.args)
+		if tt.wantError {
+			if err == nil {
+				t.Fatalf("diff: args: %q, got no error, want error", tt.args)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("diff: args: %q, got error %q, want no error", tt.args, err)
+		}
+		if strings.Join(opts.initCmds, "|") != strings.Join(tt.wantInit, "|") || strings.Join(args, "|") != strings.Join(tt.wantArgs, "|") {
+			t.Fatalf("diff: args: %q, want init: %q, args: %q, got init: %q, args: %q", tt.args, tt.wantInit, tt.wantArgs, opts.initCmds, args)
+		}
+		if strings.Join(opts.exprs, "|") != strings.Join(tt.wantExprs, "|") {
+			t.Fatalf("diff: args: %q, want exprs: %q, got: %q", tt.args, tt.wantExprs, opts.exprs)
 		}
-		continue
 	}
 }
 
@@ -256,3 +665,95 @@ func Example_main() {
 	main()
 	// Output: 0
 }
+
+// runMain runs main with args, reading stdin from a file containing input
+// and returning everything written to stdout. Colors are enabled before the
+// call, so only main can disable them.
+func runMain(t *testing.T, input string, args ...string) string {
+	t.Helper()
+	dir := t.TempDir()
+	stdin, err := os.Create(filepath.Join(dir, "stdin"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stdin.Close()
+	if _, err := stdin.WriteString(input); err != nil {
+		t.Fatal(err)
+	}
+	stdin.Seek(0, io.SeekStart)
+	stdout, err := os.Create(filepath.Join(dir, "stdout"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stdout.Close()
+
+	oldArgs, oldStdin, oldStdout, oldNoColor := os.Args, os.Stdin, os.Stdout, color.NoColor
+	defer func() {
+		os.Args, os.Stdin, os.Stdout, color.NoColor = oldArgs, oldStdin, oldStdout, oldNoColor
+	}()
+	os.Args = append([]string{"rpn", "--norc"}, args...)
+	os.Stdin, os.Stdout, color.NoColor = stdin, stdout, false
+	main()
+
+	out, err := os.ReadFile(stdout.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(out)
+}
+
+func TestNoColor(t *testing.T) {
+	t.Setenv("NO_COLOR", "")
+	if noColor(optionsType{}) {
+		t.Fatalf("noColor without --no-color and NO_COLOR: got true, want false")
+	}
+	if out := runMain(t, "", "--no-color", "1", "2", "p"); strings.Contains(out, "\x1b[") {
+		t.Fatalf("--no-color: got ANSI escapes in output: %q", out)
+	}
+	t.Setenv("NO_COLOR", "1")
+	if out := runMain(t, "", "1", "2", "p"); strings.Contains(out, "\x1b[") {
+		t.Fatalf("NO_COLOR: got ANSI escapes in output: %q", out)
+	}
+}
+
+func TestPlainOutput(t *testing.T) {
+	t.Setenv("NO_COLOR", "")
+	script := filepath.Join(t.TempDir(), "empty.rpn")
+	if err := os.WriteFile(script, nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	// Interactive session with stdin and stdout redirected: no colors,
+	// prompts or decorations.
+	want := "5\n===== Stack =====\n x: 5\n1000000\n"
+	if got := runMain(t, "2 3 +\np\n1000000 1 *\nq\n", "-i", script); got != want {
+		t.Fatalf("diff: want: %q, got: %q", want, got)
+	}
+	if got := runMain(t, "", "1000000", "1", "*"); got != "1000000\n" {
+		t.Fatalf("diff: want: %q, got: %q", "1000000\n", got)
+	}
+}
+
+func Example_invalidColors() {
+	// The warning about invalid colors goes to stderr.
+	os.Setenv("RPN_COLORS", "x=foo")
+	defer os.Unsetenv("RPN_COLORS")
+	os.Args = []string{"rpn", "2", "3", "+"}
+	main()
+	// Output: 5
+}
+
+func TestLoadPlugins(t *testing.T) {
+	// A missing directory is not an error.
+	if err := loadPlugins(filepath.Join(t.TempDir(), "missing")); err != nil {
+		t.Fatalf("got error: %v", err)
+	}
+	// Invalid plugins are reported.
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "bad.so"), []byte("not a plugin"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := loadPlugins(dir); err == nil {
+		t.Fatalf("invalid plugin: got no error, want error")
+	}
+}
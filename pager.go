@@ -10,39 +10,47 @@ import (
 	"io"
 	"os"
 	"os/exec"
+
+	"github.com/fatih/color"
 )
 
-// struct pager contains information about a pager object.
+// struct pager contains information about a pager object. It implements
+// io.WriteCloser.
 type pager struct {
-	w            io.WriteCloser
-	cmd          *exec.Cmd
-	colorSupport bool
+	w       io.WriteCloser
+	cmd     *exec.Cmd
+	noColor bool // Value of color.NoColor before the pager was started
 }
 
 // newPager creates a new pager object and executes the pager.  If no suitable
-// pager binary is found, os.Writer will point to the standard output.
-func newPager() (pager, error) {
+// pager binary is found, output goes to the standard output. Colors are
+// disabled until Close if the pager doesn't support them.
+func newPager() (*pager, error) {
 	// Look for a pager and set output to stdout if none found.
 	prog, colorSupport, err := findPager()
 	if err != nil {
-		return pager{
-			w:            os.Stdout,
-			colorSupport: colorSupport}, nil
+		return &pager{
+			w:       os.Stdout,
+			noColor: color.NoColor}, nil
 	}
 
 	cmd := exec.Command(prog[0], prog[1:]...)
 	w, err := cmd.StdinPipe()
 	if err != nil {
-		return pager{}, err
+		return nil, err
 	}
 	cmd.Stdout = os.Stdout
 	cmd.Stderr = os.Stderr
 	cmd.Start()
 
-	return pager{
-		w:            w,
-		cmd:          cmd,
-		colorSupport: colorSupport}, nil
+	ret := &pager{
+		w:       w,
+		cmd:     cmd,
+		noColor: color.NoColor}
+	if !colorSupport {
+		color.NoColor = true
+	}
+	return ret, nil
 }
 
 // findPager returns a suitable pager program in the PATH whether it supports
@@ -57,10 +65,17 @@ func findPager() ([]string, bool, error) {
 	return nil, false, errors.New("unable to find pager program (less, more, etc)")
 }
 
-// wait closes the input and waits for the command to finish.
-func (x pager) wait() error {
+// Write writes p to the pager (io.Writer interface).
+func (x *pager) Write(p []byte) (int, error) {
+	return x.w.Write(p)
+}
+
+// Close closes the input, waits for the command to finish and restores color
+// support (io.Closer interface).
+func (x *pager) Close() error {
+	color.NoColor = x.noColor
 	// Do nothing if we're outputting to stdout.
-	if x.w == os.Stdout {
+	if x.cmd == nil {
 		return nil
 	}
 	x.w.Close()
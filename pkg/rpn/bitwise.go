@@ -0,0 +1,189 @@
+// This file is part of rpn, a simple and useful CLI RPN calculator.
+// For further information, check https://github.com/marcopaganini/rpn
+//
+// (C) Sep/2024 by Marco Paganini <paganini AT paganini DOT net>
+
+package rpn
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	mathbig "math/big"
+	"math/bits"
+	"slices"
+	"strconv"
+
+	"github.com/ericlagergren/decimal"
+)
+
+// defaultWordSize is the word size (in bits) used by bitwise operations and
+// non-decimal output.
+const defaultWordSize = 64
+
+// wordSizes contains the valid word sizes.
+var wordSizes = []int{8, 16, 32, 64, 128}
+
+// validWordSize returns true if bits is a valid word size.
+func validWordSize(bits int) bool {
+	return slices.Contains(wordSizes, bits)
+}
+
+// wordMask returns a number with the lowest bits set (2^bits - 1).
+func wordMask(bits int) *mathbig.Int {
+	m := new(mathbig.Int).Lsh(mathbig.NewInt(1), uint(bits))
+	return m.Sub(m, mathbig.NewInt(1))
+}
+
+// inWord returns true if n fits in a word of the given size, either as an
+// unsigned number or as a signed (two's complement) number.
+func inWord(n *mathbig.Int, bits int) bool {
+	if n.Sign() >= 0 {
+		return n.BitLen() <= bits
+	}
+	// -2^(bits-1) is the smallest signed number.
+	min := new(mathbig.Int).Lsh(mathbig.NewInt(-1), uint(bits-1))
+	return n.Cmp(min) >= 0
+}
+
+// signedWord returns the signed (two's complement) value of the word n.
+func signedWord(n *mathbig.Int, bits int) *mathbig.Int {
+	z := new(mathbig.Int).Set(n)
+	if z.Bit(bits-1) == 1 {
+		z.Sub(z, new(mathbig.Int).Lsh(mathbig.NewInt(1), uint(bits)))
+	}
+	return z
+}
+
+// fromWord converts the result of a bitwise operation back to a decimal,
+// interpreting it as a signed number in signed mode.
+func (x displayType) fromWord(n *mathbig.Int) *decimal.Big {
+	if x.signed {
+		return intToBig(signedWord(n, x.wordSize))
+	}
+	return intToBig(n)
+}
+
+// toWord converts v to an unsigned integer with the given number of bits.
+// The fractional part of v is truncated and negative numbers are converted
+// to their two's complement. A note is written to the output if v does not
+// fit in a word, unless quiet is set.
+func (x *opsType) toWord(v *decimal.Big, bits int) *mathbig.Int {
+	if !v.IsFinite() {
+		if !x.quiet {
+			fmt.Fprintf(x.out, warnMsg("Note: %f truncated to 0 (%d bits)\n"), v, bits)
+		}
+		return new(mathbig.Int)
+	}
+	n := v.Int(nil)
+	if (!inWord(n, bits) || !v.IsInt()) && !x.quiet {
+		fmt.Fprintf(x.out, warnMsg("Note: %f truncated to %d (%d bits)\n"), v, n.And(n, wordMask(bits)), bits)
+	}
+	return n.And(n, wordMask(bits))
+}
+
+// shiftCount returns the number of bits to shift or rotate, from x.
+func shiftCount(x *decimal.Big) (uint64, error) {
+	n, ok := x.Uint64()
+	if !ok || !x.IsInt() {
+		return 0, errors.New("number of bits must be a positive integer")
+	}
+	return n, nil
+}
+
+// rotate rotates the word n count bits to the left within a word of the
+// given size. Use bits-count to rotate to the right.
+func rotate(n *mathbig.Int, count uint64, bits int) *mathbig.Int {
+	count %= uint64(bits)
+	hi := new(mathbig.Int).Lsh(n, uint(count))
+	lo := new(mathbig.Int).Rsh(n, uint(uint64(bits)-count))
+	return hi.Or(hi, lo).And(hi, wordMask(bits))
+}
+
+// bitIndex returns the bit number in x, which must be inside the word.
+func bitIndex(x *decimal.Big, bits int) (int, error) {
+	n, ok := x.Uint64()
+	if !ok || !x.IsInt() || n >= uint64(bits) {
+		return 0, fmt.Errorf("bit number must be an integer between 0 and %d", bits-1)
+	}
+	return int(n), nil
+}
+
+// popCount returns the number of bits set in n.
+func popCount(n *mathbig.Int) int {
+	count := 0
+	for _, w := range n.Bits() {
+		count += bits.OnesCount(uint(w))
+	}
+	return count
+}
+
+// byteSwap reverses the order of the bytes in a word of the given size.
+func byteSwap(n *mathbig.Int, bits int) *mathbig.Int {
+	buf := n.FillBytes(make([]byte, bits/8))
+	slices.Reverse(buf)
+	return new(mathbig.Int).SetBytes(buf)
+}
+
+// floatLayout describes the bit layout of an IEEE-754 binary float.
+type floatLayout struct {
+	bits     int // Total number of bits
+	expBits  int // Number of exponent bits
+	mantBits int // Number of mantissa (fraction) bits
+	bias     int // Exponent bias
+}
+
+var (
+	float32Layout = floatLayout{bits: 32, expBits: 8, mantBits: 23, bias: 127}
+	float64Layout = floatLayout{bits: 64, expBits: 11, mantBits: 52, bias: 1023}
+)
+
+// floatBits returns the IEEE-754 bit pattern of x as a float with the given
+// layout.
+func floatBits(x *decimal.Big, layout floatLayout) uint64 {
+	f, _ := strconv.ParseFloat(x.String(), layout.bits)
+	if layout.bits == 32 {
+		return uint64(math.Float32bits(float32(f)))
+	}
+	return math.Float64bits(f)
+}
+
+// bitsFloat returns the float value of an IEEE-754 bit pattern, using the
+// shortest decimal representation that converts back to the same float.
+func bitsFloat(b uint64, layout floatLayout) *decimal.Big {
+	var f float64
+	if layout.bits == 32 {
+		f = float64(math.Float32frombits(uint32(b)))
+	} else {
+		f = math.Float64frombits(b)
+	}
+	switch {
+	case math.IsNaN(f):
+		return big().SetNaN(false)
+	case math.IsInf(f, 0):
+		return big().SetInf(f < 0)
+	}
+	z, _ := big().SetString(strconv.FormatFloat(f, 'g', -1, layout.bits))
+	return z
+}
+
+// printFloatBits displays the sign, exponent, and mantissa of the IEEE-754
+// bit pattern b.
+func printFloatBits(w io.Writer, b uint64, layout floatLayout) {
+	sign := b >> (layout.bits - 1)
+	exp := (b >> layout.mantBits) & (1<<layout.expBits - 1)
+	mant := b & (1<<layout.mantBits - 1)
+
+	fmt.Fprintf(w, "  sign:     %d\n", sign)
+	switch exp {
+	case 0:
+		fmt.Fprintf(w, "  exponent: %0*b (zero/subnormal)\n", layout.expBits, exp)
+	case 1<<layout.expBits - 1:
+		fmt.Fprintf(w, "  exponent: %0*b (infinity/NaN)\n", layout.expBits, exp)
+	default:
+		fmt.Fprintf(w, "  exponent: %0*b (%d - %d = %d)\n", layout.expBits, exp, exp, layout.bias, int(exp)-layout.bias)
+	}
+	fmt.Fprintf(w, "  mantissa: %0*b\n", layout.mantBits, mant)
+	fmt.Fprintf(w, "  hex:      0x%0*x\n", layout.bits/4, b)
+}
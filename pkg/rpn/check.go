@@ -0,0 +1,113 @@
+// This file is part of rpn, a simple and useful CLI RPN calculator.
+// For further information, check https://github.com/marcopaganini/rpn
+//
+// (C) Sep/2024 by Marco Paganini <paganini AT paganini DOT net>
+
+package rpn
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+)
+
+// cmdArgs returns the number of tokens a command would consume from the list
+// of tokens following it, based on the argument specification used by help.
+// Arguments in the form <arg> are mandatory and match any token. Arguments
+// in the form [word] are optional and only match that exact word. Blocks
+// ([ ... ]) are not consumed, so their contents can also be checked.
+func cmdArgs(handler cmdhandler, tokens []string) (int, error) {
+	n := 0
+	for _, arg := range strings.Fields(handler.args) {
+		switch {
+		case strings.HasPrefix(arg, "<"):
+			if n >= len(tokens) {
+				return n, fmt.Errorf("%s: missing argument %s", handler.cmd, arg)
+			}
+			if tokens[n] == "[" {
+				if _, _, err := macroArg(tokens[n:]); err != nil {
+					return n, fmt.Errorf("%s: %v", handler.cmd, err)
+				}
+				return n, nil
+			}
+			n++
+		case strings.HasPrefix(arg, "["):
+			if n < len(tokens) && tokens[n] == strings.Trim(arg, "[]") {
+				n++
+			}
+		}
+	}
+	return n, nil
+}
+
+// checkScript validates a script read from r without executing it. It
+// returns a list of problems found (unknown operators, malformed numbers,
+// missing arguments, unbalanced blocks), each prefixed by fname and the line
+// number.
+func checkScript(ops *opsType, r io.Reader, fname string) ([]string, error) {
+	opmap := ops.opmap()
+	cmdmap := ops.cmdmap()
+	macros := map[string]bool{}
+	for name := range ops.macros {
+		macros[name] = true
+	}
+
+	var problems []string
+	ibase := ops.ibase
+	scanner := bufio.NewScanner(r)
+	for lineno := 1; scanner.Scan(); lineno++ {
+		tokens := tokenize(scanner.Text())
+
+		// Blocks must be balanced within each line. Unclosed blocks passed
+		// as command arguments are already reported by cmdArgs.
+		depth := 0
+		reported := false
+
+		for i := 0; i < len(tokens); i++ {
+			token := tokens[i]
+			switch token {
+			case "[":
+				depth++
+				continue
+			case "]":
+				if depth == 0 {
+					problems = append(problems, fmt.Sprintf("%s:%d: unexpected \"]\"", fname, lineno))
+				} else {
+					depth--
+				}
+				continue
+			}
+			if handler, ok := cmdmap[token]; ok {
+				// Macros can be used after being defined, and numbers
+				// are read in the current input base.
+				switch {
+				case token == "def" && i+1 < len(tokens):
+					macros[tokens[i+1]] = true
+				case token == "ibase" && i+1 < len(tokens):
+					if base, err := parseBase(tokens[i+1]); err == nil {
+						ibase = base
+					}
+				}
+				n, err := cmdArgs(handler, tokens[i+1:])
+				if err != nil {
+					problems = append(problems, fmt.Sprintf("%s:%d: %v", fname, lineno, err))
+					reported = reported || (i+1+n < len(tokens) && tokens[i+1+n] == "[")
+				}
+				i += n
+				continue
+			}
+			if _, ok := opmap[token]; ok || macros[token] || slices.Contains(helpTokens, token) || slices.Contains(quitTokens, token) || ansRe.MatchString(token) {
+				continue
+			}
+			if _, err := parseNumber(token, ibase); err != nil {
+				problems = append(problems, fmt.Sprintf("%s:%d: not a number or operator: %q", fname, lineno, token))
+			}
+		}
+		if depth > 0 && !reported {
+			problems = append(problems, fmt.Sprintf("%s:%d: missing \"]\" at the end of block", fname, lineno))
+		}
+	}
+	return problems, scanner.Err()
+}
@@ -0,0 +1,37 @@
+// This file is part of rpn, a simple and useful CLI RPN calculator.
+// For further information, check https://github.com/marcopaganini/rpn
+//
+// (C) Sep/2024 by Marco Paganini <paganini AT paganini DOT net>
+
+package rpn
+
+import (
+	"errors"
+	"strings"
+
+	"github.com/ericlagergren/decimal"
+)
+
+// Clipboard is the clipboard used by the copy and paste operations (see
+// Calculator.SetClipboard).
+type Clipboard interface {
+	Copy(s string) error
+	Paste() (string, error)
+}
+
+// errNoClipboard is returned by copy and paste without a clipboard.
+var errNoClipboard = errors.New("no clipboard available")
+
+// extractNumbers returns all numbers in s, cleaned as regular input (E.g.
+// "$1,234.50" is 1234.5). Anything else is ignored.
+func extractNumbers(s string, ibase int) []*decimal.Big {
+	ret := []*decimal.Big{}
+	for _, line := range strings.Split(s, "\n") {
+		for _, token := range tokenize(line) {
+			if n, err := parseNumber(token, ibase); err == nil {
+				ret = append(ret, n)
+			}
+		}
+	}
+	return ret
+}
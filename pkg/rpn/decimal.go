@@ -0,0 +1,631 @@
+// This file is part of rpn, a simple and useful CLI RPN calculator.
+// For further information, check https://github.com/marcopaganini/rpn
+//
+// (C) Sep/2024 by Marco Paganini <paganini AT paganini DOT net>
+package rpn
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"math"
+	mathbig "math/big"
+	"strconv"
+	"strings"
+
+	"github.com/ericlagergren/decimal"
+)
+
+// maxScale is the largest exponent of numbers, well beyond the decimal128
+// limit (6144), so large results like 100000 fac don't overflow. Integers of
+// this size are still quick to convert to binary (E.g. for bitwise
+// operations).
+const maxScale = 1000000
+
+// maxFixedScale is the largest exponent of numbers displayed in fixed point
+// notation. Larger (and smaller) numbers use scientific notation.
+const maxFixedScale = 6144
+
+// bigContext is the context of numbers created by big: decimal128 with
+// exponents up to maxScale.
+var bigContext = func() decimal.Context {
+	ctx := decimal.Context128
+	ctx.MaxScale, ctx.MinScale = maxScale, -maxScale
+	return ctx
+}()
+
+// errFactorial is returned when a factorial exceeds the largest number.
+var errFactorial = fmt.Errorf("factorial overflow (maximum is 1E+%d)", maxScale)
+
+// big returns a new *decimal.Big
+func big() *decimal.Big {
+	return decimal.WithContext(bigContext)
+}
+
+// bigUint returns a new *decimal.Big from an uint64.
+func bigUint(n uint64) *decimal.Big {
+	return big().SetUint64(n)
+}
+
+// bigFloat returns a new *decimal.Big from a string
+// Using a float64 here will introduce rounding errors.
+func bigFloat(s string) *decimal.Big {
+	r, _ := big().SetString(s)
+	return r
+}
+
+// truncate returns the integer part of x, rounding towards zero.
+func truncate(ctx decimal.Context, x *decimal.Big) *decimal.Big {
+	if x.Signbit() {
+		return ctx.Ceil(big(), x)
+	}
+	return ctx.Floor(big(), x)
+}
+
+// quoInt returns the integer part of y/x. The quotient is truncated towards
+// zero. Quotients too large to be represented exactly in the current
+// precision are computed from the rounded division.
+func quoInt(ctx decimal.Context, y, x *decimal.Big) *decimal.Big {
+	z := ctx.QuoInt(big(), y, x)
+	if z.IsNaN(0) && !y.IsNaN(0) && !x.IsNaN(0) && !x.IsInf(0) {
+		return truncate(ctx, ctx.Quo(z, y, x))
+	}
+	return z
+}
+
+// factorial returns n! rounded to the precision of ctx. The product is
+// calculated exactly using binary splitting. Results that can't be
+// represented in ctx return an error without being calculated.
+func factorial(ctx decimal.Context, n uint64) (*decimal.Big, error) {
+	// log10(n!) = lgamma(n+1) / ln(10). Leave some room for floating
+	// point errors and let rounding decide close to the limit.
+	if lg, _ := math.Lgamma(float64(n) + 1); lg/math.Ln10 > float64(ctx.MaxScale)+2 {
+		return nil, errFactorial
+	}
+	fact := new(mathbig.Int).MulRange(1, int64(n))
+	z := ctx.Round(big().SetBigMantScale(fact, 0))
+	if z.IsInf(0) {
+		return nil, errFactorial
+	}
+	return z, nil
+}
+
+// fixedPoint returns true if n is displayed in fixed point notation.
+func fixedPoint(n *decimal.Big) bool {
+	if !n.IsFinite() || n.Sign() == 0 {
+		return true
+	}
+	exp := n.Precision() - n.Scale() - 1
+	return exp >= -maxFixedScale && exp <= maxFixedScale
+}
+
+// sciNumber formats n in scientific notation with the given number of
+// decimals (E.g. 2.824229E+456573).
+func sciNumber(n *decimal.Big, decimals int) string {
+	return fmt.Sprintf("%.*E", decimals+1, n)
+}
+
+// bernoulli contains the Bernoulli numbers B2, B4, ..., B40 used by the
+// Stirling series in lnGammaStirling.
+var bernoulli = [][2]string{
+	{"1", "6"},
+	{"-1", "30"},
+	{"1", "42"},
+	{"-1", "30"},
+	{"5", "66"},
+	{"-691", "2730"},
+	{"7", "6"},
+	{"-3617", "510"},
+	{"43867", "798"},
+	{"-174611", "330"},
+	{"854513", "138"},
+	{"-236364091", "2730"},
+	{"8553103", "6"},
+	{"-23749461029", "870"},
+	{"8615841276005", "14322"},
+	{"-7709321041217", "510"},
+	{"2577687858367", "6"},
+	{"-26315271553053477373", "1919190"},
+	{"2929993913841559", "6"},
+	{"-261082718496449122051", "13530"},
+}
+
+// gamma returns Γ(x) calculated at the precision of ctx. Arguments are
+// shifted to x >= 40 using Γ(x) = Γ(x+1)/x, where the Stirling series
+// converges quickly. Arguments below 1/2 use the reflection formula
+// Γ(x) = π / (sin(πx) Γ(1-x)).
+func gamma(ctx decimal.Context, x *decimal.Big) (*decimal.Big, error) {
+	if x.IsNaN(0) || x.IsInf(0) {
+		return big().Copy(x), nil
+	}
+	if x.IsInt() {
+		if x.Sign() <= 0 {
+			return nil, errors.New("gamma is undefined for zero and negative integers")
+		}
+		// Γ(n) = (n-1)!
+		n, ok := x.Uint64()
+		if !ok {
+			return nil, errFactorial
+		}
+		return factorial(ctx, n-1)
+	}
+
+	// Work with extra precision to absorb rounding errors.
+	wctx := ctx
+	wctx.Precision += 16
+
+	if x.Cmp(bigFloat("0.5")) < 0 {
+		g, err := gamma(wctx, wctx.Sub(big(), bigUint(1), x))
+		if err != nil {
+			return nil, err
+		}
+		pi := wctx.Pi(big())
+		s := wctx.Sin(big(), wctx.Mul(big(), pi, x))
+		z := wctx.Quo(big(), pi, wctx.Mul(s, s, g))
+		return ctx.Round(z), nil
+	}
+
+	// Γ(x) = Γ(z) / (x (x+1) ... (z-1))
+	z := big().Copy(x)
+	prod := bigUint(1)
+	for z.Cmp(bigUint(40)) < 0 {
+		wctx.Mul(prod, prod, z)
+		wctx.Add(z, z, bigUint(1))
+	}
+
+	// ln Γ(z) = (z-1/2) ln(z) - z + ln(2π)/2 + Σ B2k / (2k (2k-1) z^(2k-1))
+	lg := wctx.Mul(big(), wctx.Sub(big(), z, bigFloat("0.5")), wctx.Log(big(), z))
+	wctx.Sub(lg, lg, z)
+	twopi := wctx.Mul(big(), bigUint(2), wctx.Pi(big()))
+	wctx.Add(lg, lg, wctx.Quo(big(), wctx.Log(big(), twopi), bigUint(2)))
+
+	z2 := wctx.Mul(big(), z, z)
+	zpow := big().Copy(z)
+	for ix, b := range bernoulli {
+		k := uint64(2 * (ix + 1))
+		den := wctx.Mul(big(), bigFloat(b[1]), bigUint(k*(k-1)))
+		wctx.Mul(den, den, zpow)
+		wctx.Add(lg, lg, wctx.Quo(big(), bigFloat(b[0]), den))
+		wctx.Mul(zpow, zpow, z2)
+	}
+
+	ret := wctx.Quo(big(), wctx.Exp(big(), lg), prod)
+	return ctx.Round(ret), nil
+}
+
+// root returns the nth root of y. Negative values of y are only accepted
+// for odd integer roots.
+func root(ctx decimal.Context, y, n *decimal.Big) (*decimal.Big, error) {
+	if n.Sign() == 0 {
+		return nil, errors.New("zeroth root is undefined")
+	}
+	if y.Signbit() && y.Sign() != 0 {
+		odd := n.IsInt() && ctx.Rem(big(), n, bigUint(2)).Sign() != 0
+		if !odd {
+			return nil, errors.New("negative numbers only have odd integer roots")
+		}
+		z, err := root(ctx, big().Neg(y), n)
+		if err != nil {
+			return nil, err
+		}
+		return z.Neg(z), nil
+	}
+
+	// Work with extra precision to absorb rounding errors in 1/n.
+	wctx := ctx
+	wctx.Precision += 5
+	e := wctx.Quo(big(), bigUint(1), n)
+	return ctx.Round(wctx.Pow(big(), y, e)), nil
+}
+
+// hypot returns sqrt(x²+y²). The smaller value is scaled by the larger one
+// before squaring, so results stay finite when x² or y² would overflow.
+func hypot(ctx decimal.Context, x, y *decimal.Big) *decimal.Big {
+	p := big().Abs(x)
+	q := big().Abs(y)
+	if p.IsNaN(0) || q.IsNaN(0) || p.IsInf(0) || q.IsInf(0) {
+		return ctx.Hypot(big(), p, q)
+	}
+	if p.Cmp(q) < 0 {
+		p, q = q, p
+	}
+	if p.Sign() == 0 {
+		return bigUint(0)
+	}
+	// p * sqrt(1 + (q/p)²)
+	r := ctx.Quo(big(), q, p)
+	ctx.Mul(r, r, r)
+	ctx.Add(r, r, bigUint(1))
+	ctx.Mul(r, p, ctx.Sqrt(r, r))
+
+	// Drop the trailing zeroes introduced by the scaling (50.00 -> 50).
+	ctx.Reduce(r)
+	if r.Scale() < 0 && r.Precision()-r.Scale() <= ctx.Precision {
+		ctx.Quantize(r, 0)
+	}
+	return r
+}
+
+// quadratic returns the real roots of ax²+bx+c = 0 in ascending order. If
+// the roots are complex, it returns nil and the real and imaginary parts of
+// the roots (re ± im i).
+func quadratic(ctx decimal.Context, a, b, c *decimal.Big) (roots []*decimal.Big, re, im *decimal.Big) {
+	// disc = b² - 4ac
+	disc := ctx.Mul(big(), b, b)
+	ctx.Sub(disc, disc, ctx.Mul(big(), bigUint(4), ctx.Mul(big(), a, c)))
+	twoa := ctx.Mul(big(), bigUint(2), a)
+
+	if disc.Sign() < 0 {
+		re = ctx.Quo(big(), big().Neg(b), twoa)
+		im = ctx.Quo(big(), ctx.Sqrt(big(), big().Neg(disc)), twoa)
+		return nil, re, big().Abs(im)
+	}
+
+	// Avoid cancellation: q = -(b + sign(b) sqrt(disc)) / 2, x1 = q/a, x2 = c/q.
+	sq := ctx.Sqrt(big(), disc)
+	if b.Signbit() {
+		sq.Neg(sq)
+	}
+	q := ctx.Add(big(), b, sq)
+	ctx.Quo(q, q.Neg(q), bigUint(2))
+	if q.Sign() == 0 {
+		return []*decimal.Big{bigUint(0), bigUint(0)}, nil, nil
+	}
+	x1 := ctx.Quo(big(), q, a)
+	x2 := ctx.Quo(big(), c, q)
+	if x1.Cmp(x2) > 0 {
+		x1, x2 = x2, x1
+	}
+	return []*decimal.Big{x1, x2}, nil, nil
+}
+
+// commafWithDigits idea comes from the humanize library, but was modified to
+// work with decimal numbers.
+func commafWithDigits(n *decimal.Big, decimals int) string {
+	// Make a copy so we won't modify the original value (passed by pointer).
+	v := big().Copy(n)
+
+	buf := &bytes.Buffer{}
+	if v.Sign() < 0 {
+		buf.Write([]byte{'-'})
+		// Make v positive
+		v.SetSignbit(false)
+	}
+
+	comma := []byte{','}
+
+	f := fmt.Sprintf("%%.%df", decimals)
+	parts := strings.Split(fmt.Sprintf(f, v), ".")
+
+	pos := 0
+	if len(parts[0])%3 != 0 {
+		pos += len(parts[0]) % 3
+		buf.WriteString(parts[0][:pos])
+		buf.Write(comma)
+	}
+	for ; pos < len(parts[0]); pos += 3 {
+		buf.WriteString(parts[0][pos : pos+3])
+		buf.Write(comma)
+	}
+	buf.Truncate(buf.Len() - 1)
+
+	if len(parts) > 1 {
+		buf.Write([]byte{'.'})
+		buf.WriteString(parts[1])
+	}
+	return stripTrailingDigits(buf.String(), decimals)
+}
+
+// nearestFraction returns the fraction num/den closest to the finite number
+// n, with den no greater than maxDen. Exact is true if num/den equals n.
+func nearestFraction(n *decimal.Big, maxDen int64) (num, den *mathbig.Int, exact bool) {
+	r := n.Rat(nil)
+	neg := r.Sign() < 0
+	r.Abs(r)
+	x := new(mathbig.Rat).Set(r)
+	max := mathbig.NewInt(maxDen)
+
+	// Continued fraction convergents of x: p0/q0 and p1/q1 are the previous
+	// two, starting with 0/1 and 1/0.
+	p0, q0 := mathbig.NewInt(0), mathbig.NewInt(1)
+	p1, q1 := mathbig.NewInt(1), mathbig.NewInt(0)
+	for {
+		a := new(mathbig.Int).Quo(x.Num(), x.Denom())
+		q2 := new(mathbig.Int).Add(new(mathbig.Int).Mul(a, q1), q0)
+		if q2.Cmp(max) > 0 {
+			// The best approximation is either the last convergent or the
+			// largest semiconvergent with a denominator in range.
+			k := new(mathbig.Int).Quo(new(mathbig.Int).Sub(max, q0), q1)
+			sp := new(mathbig.Int).Add(new(mathbig.Int).Mul(k, p1), p0)
+			sq := new(mathbig.Int).Add(new(mathbig.Int).Mul(k, q1), q0)
+			d1 := new(mathbig.Rat).Sub(r, new(mathbig.Rat).SetFrac(p1, q1))
+			d2 := new(mathbig.Rat).Sub(r, new(mathbig.Rat).SetFrac(sp, sq))
+			if d2.Abs(d2).Cmp(d1.Abs(d1)) < 0 {
+				p1, q1 = sp, sq
+			}
+			break
+		}
+		p2 := new(mathbig.Int).Add(new(mathbig.Int).Mul(a, p1), p0)
+		p0, q0, p1, q1 = p1, q1, p2, q2
+
+		x.Sub(x, new(mathbig.Rat).SetInt(a))
+		if x.Sign() == 0 {
+			exact = true
+			break
+		}
+		x.Inv(x)
+	}
+	if neg {
+		p1.Neg(p1)
+	}
+	return p1, q1, exact
+}
+
+// separatorType holds the thousands separator and decimal mark used in the
+// humanized form of decimal numbers.
+type separatorType struct {
+	group   string // Thousands (group) separator
+	decimal string // Decimal mark
+	indian  bool   // Indian (lakh/crore) grouping: 1,23,45,678
+}
+
+// separators contains the separator styles accepted by the sep command. The
+// empty name is the default style.
+var separators = map[string]separatorType{
+	"":           {group: ",", decimal: "."},
+	"comma":      {group: ",", decimal: "."},
+	"period":     {group: ".", decimal: ","},
+	"space":      {group: " ", decimal: "."},
+	"underscore": {group: "_", decimal: "."},
+	"indian":     {group: ",", decimal: ".", indian: true},
+}
+
+// apply converts a number formatted by commafWithDigits to use the
+// separators in x.
+func (x separatorType) apply(s string) string {
+	sign := ""
+	if strings.HasPrefix(s, "-") {
+		sign, s = "-", s[1:]
+	}
+	intpart, frac, hasFrac := strings.Cut(s, ".")
+	digits := strings.ReplaceAll(intpart, ",", "")
+
+	// Split the integer digits in groups, from right to left.
+	groups := []string{}
+	size := 3
+	for len(digits) > size {
+		groups = append([]string{digits[len(digits)-size:]}, groups...)
+		digits = digits[:len(digits)-size]
+		if x.indian {
+			size = 2
+		}
+	}
+	groups = append([]string{digits}, groups...)
+
+	ret := sign + strings.Join(groups, x.group)
+	if hasFrac {
+		ret += x.decimal + frac
+	}
+	return ret
+}
+
+func stripTrailingDigits(s string, digits int) string {
+	// Remove insignificant zeroes after period (if any).
+	if strings.Contains(s, ".") {
+		s = strings.TrimRight(s, "0")
+		s = strings.TrimRight(s, ".")
+	}
+
+	// Trim string to .<digits>
+	if i := strings.Index(s, "."); i >= 0 {
+		if digits <= 0 {
+			return s[:i]
+		}
+		i++
+		if i+digits >= len(s) {
+			return s
+		}
+		return s[:i+digits]
+	}
+	return s
+}
+
+// formatNumber formats the number using the base and decimals in disp. For
+// bases different than 10, non-integer floating numbers are truncated and
+// numbers are shown as words of disp.wordSize bits: negative numbers use two's
+// complement or, in signed mode, words with the highest bit set are shown as
+// negative numbers. Decimal numbers are followed by their humanized form (with
+// thousands separators or SI prefixes) when it differs from the plain number.
+func formatNumber(ctx decimal.Context, n *decimal.Big, disp displayType) string {
+	base, decimals := disp.base, disp.decimals
+	wordSize := disp.wordSize
+	if wordSize == 0 {
+		wordSize = defaultWordSize
+	}
+
+	// Print NaN without suffix numbers.
+	if n.IsNaN(0) {
+		return strings.TrimRight(fmt.Sprint(n), "0123456789")
+	}
+	if n.IsInf(0) {
+		return fmt.Sprint(n)
+	}
+	if !fixedPoint(n) {
+		if base != 10 {
+			return fmt.Sprintf("Invalid number: non decimal base only supports %d-bit numbers (see wsize).", wordSize)
+		}
+		return sciNumber(n, decimals)
+	}
+
+	// clean = double as ascii, without non-significant decimal zeroes.
+	f := fmt.Sprintf("%%.%df", decimals)
+	clean := stripTrailingDigits(fmt.Sprintf(f, n), decimals)
+
+	var (
+		word   *mathbig.Int
+		suffix string
+	)
+
+	buf := &bytes.Buffer{}
+	if base != 10 {
+		// Truncate floating point numbers to their integer representation.
+		if !n.IsInt() {
+			suffix = fmt.Sprintf(" (truncated from %s)", clean)
+		}
+		word = n.Int(nil)
+		if !inWord(word, wordSize) {
+			return fmt.Sprintf("Invalid number: non decimal base only supports %d-bit numbers (see wsize).", wordSize)
+		}
+		word.And(word, wordMask(wordSize))
+		if disp.signed {
+			word = signedWord(word, wordSize)
+			if word.Sign() < 0 {
+				buf.Write([]byte{'-'})
+				word.Neg(word)
+			}
+		}
+	}
+
+	switch {
+	case base == 2:
+		buf.WriteString(fmt.Sprintf("0b%b%s", word, suffix))
+	case base == 8:
+		buf.WriteString(fmt.Sprintf("0%o%s", word, suffix))
+	case base == 16:
+		buf.WriteString(fmt.Sprintf("0x%x%s", word, suffix))
+	case base != 10:
+		// Other bases use the base#digits notation (E.g. 36#zz).
+		buf.WriteString(fmt.Sprintf("%d#%s%s", base, word.Text(base), suffix))
+	case disp.currency:
+		buf.WriteString(currencyNumber(n, disp))
+	default:
+		h := humanize(ctx, n, disp)
+		// By default, only print the humanized format when it differs from
+		// the original value.
+		if !disp.plain && (disp.showHuman == humanAlways || (disp.showHuman == humanAuto && h != clean)) {
+			suffix = " (" + h + ")"
+		}
+		buf.WriteString(clean + suffix)
+	}
+
+	return buf.String()
+}
+
+// humanize returns the humanized form of a decimal number, in the style
+// selected in disp (E.g. 1,500,000, 1.5M, or 1.43 MiB).
+func humanize(ctx decimal.Context, n *decimal.Big, disp displayType) string {
+	if !fixedPoint(n) {
+		return sciNumber(n, disp.decimals)
+	}
+	switch disp.human {
+	case humanSI:
+		return siNumber(n, disp.decimals)
+	case humanBytes:
+		return bytesNumber(ctx, n)
+	}
+	return separators[disp.sep].apply(commafWithDigits(n, disp.decimals))
+}
+
+// currencySymbols contains the symbols accepted by the currency command.
+// Symbols are named since the input parser removes them from the command line.
+var currencySymbols = map[string]string{
+	"none":   "",
+	"dollar": "$",
+	"euro":   "€",
+	"pound":  "£",
+	"yen":    "¥",
+}
+
+// currencyNumber formats a decimal number as money, with two decimals,
+// thousands separators and the currency symbol (E.g. -$1,234.50).
+func currencyNumber(n *decimal.Big, disp displayType) string {
+	s := fmt.Sprintf("%.2f", n)
+	sign := ""
+	if strings.HasPrefix(s, "-") {
+		s = s[1:]
+		// Avoid "-0.00" when small negative values round to zero.
+		if strings.Trim(s, "0.") != "" {
+			sign = "-"
+		}
+	}
+	return sign + disp.symbol + separators[disp.sep].apply(s)
+}
+
+// siPrefixes contains the SI prefixes from 10^-30 (quecto) to 10^30 (quetta).
+var siPrefixes = []string{"q", "r", "y", "z", "a", "f", "p", "n", "µ", "m", "", "k", "M", "G", "T", "P", "E", "Z", "Y", "R", "Q"}
+
+// siNumber formats a decimal number using SI prefixes (E.g. 1.5M, 450µ).
+func siNumber(n *decimal.Big, decimals int) string {
+	if n.Sign() == 0 {
+		return "0"
+	}
+	// Adjusted exponent, rounded down to a multiple of 3.
+	exp := n.Precision() - n.Scale() - 1
+	idx := exp / 3
+	if exp < 0 && exp%3 != 0 {
+		idx--
+	}
+	// Numbers out of the range of the prefixes use thousands separators.
+	if idx < -len(siPrefixes)/2 || idx > len(siPrefixes)/2 {
+		return commafWithDigits(n, decimals)
+	}
+
+	m := big().Copy(n)
+	m.SetScale(n.Scale() + idx*3)
+	f := fmt.Sprintf("%%.%df", decimals)
+	return stripTrailingDigits(fmt.Sprintf(f, m), decimals) + siPrefixes[idx+len(siPrefixes)/2]
+}
+
+// bytePrefixes contains the binary (IEC) prefixes used for byte sizes.
+var bytePrefixes = []string{"B", "KiB", "MiB", "GiB", "TiB", "PiB", "EiB", "ZiB", "YiB"}
+
+// bytesNumber formats a number of bytes using binary prefixes, with up to two
+// decimals (E.g. 1.44 GiB).
+func bytesNumber(ctx decimal.Context, n *decimal.Big) string {
+	m := big().Abs(n)
+	idx := 0
+	for idx < len(bytePrefixes)-1 && m.Cmp(bigUint(1024)) >= 0 {
+		ctx.Quo(m, m, bigUint(1024))
+		idx++
+	}
+	if n.Signbit() {
+		m.Neg(m)
+	}
+	return stripTrailingDigits(fmt.Sprintf("%.2f", m), 2) + " " + bytePrefixes[idx]
+}
+
+// superscriptDigits maps regular digits and the minus sign into their
+// Unicode superscript equivalents.
+var superscriptDigits = strings.NewReplacer(
+	"0", "⁰", "1", "¹", "2", "²", "3", "³", "4", "⁴",
+	"5", "⁵", "6", "⁶", "7", "⁷", "8", "⁸", "9", "⁹", "-", "⁻")
+
+// prettyNumber formats a decimal number using Unicode characters: the proper
+// minus sign, thin spaces to group thousands, and superscripts for the
+// exponent of very large or very small numbers (E.g. 1.5×10²³).
+func prettyNumber(n *decimal.Big, decimals int) string {
+	if n.IsNaN(0) {
+		return "NaN"
+	}
+	if n.IsInf(0) {
+		if n.Signbit() {
+			return "−∞"
+		}
+		return "∞"
+	}
+
+	// Adjusted exponent, or the exponent of the number in scientific notation.
+	exp := n.Precision() - n.Scale() - 1
+
+	var s string
+	if n.Sign() != 0 && (exp >= 12 || exp <= -5) {
+		// Mantissa = n / 10^exp.
+		m := big().Copy(n)
+		m.SetScale(n.Scale() + exp)
+		f := fmt.Sprintf("%%.%df", decimals)
+		s = stripTrailingDigits(fmt.Sprintf(f, m), decimals) + "×10" + superscriptDigits.Replace(strconv.Itoa(exp))
+	} else {
+		s = strings.ReplaceAll(commafWithDigits(n, decimals), ",", "\u2009")
+	}
+	return strings.Replace(s, "-", "−", 1)
+}
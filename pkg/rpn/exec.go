@@ -0,0 +1,483 @@
+// This file is part of rpn, a simple and useful CLI RPN calculator.
+// For further information, check https://github.com/marcopaganini/rpn
+//
+// (C) Sep/2024 by Marco Paganini <paganini AT paganini DOT net>
+package rpn
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	mathbig "math/big"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"text/template"
+	"unicode/utf8"
+
+	"github.com/ericlagergren/decimal"
+)
+
+// unknownTokenError is returned when a token is not a number, operator,
+// command, or macro.
+type unknownTokenError string
+
+var (
+	// Build is the program version, set by the rpn command.
+	Build        string
+	programTitle = "rpn - a simple CLI RPN calculator"
+
+	// Tokens used to display help and quit the program.
+	helpTokens = []string{"help", "h", "?"}
+	quitTokens = []string{"quit", "exit", "q"}
+
+	// historyRe matches history references (!! and !n).
+	historyRe = regexp.MustCompile(`!(!|[0-9]+)`)
+
+	// ansRe matches result history references (ans, ans2, ans3, etc).
+	ansRe = regexp.MustCompile(`^ans([0-9]*)$`)
+
+	// ErrQuit is returned by Exec when the user asks to quit.
+	ErrQuit = errors.New("quit")
+
+	// Remove all extraneous characters from the input. This will silently
+	// remove undesirable formatting characters, making cut/paste operations
+	// simpler. If you add a new operation as a single special character, make
+	// sure it's represented here.
+	cleanRe = regexp.MustCompile(`[^-+./*%^=<>!_\[\][:alnum:]\s]`)
+
+	// charRe matches character literals (E.g. 'a'), which are replaced by
+	// their Unicode code points before cleaning the input.
+	charRe = regexp.MustCompile(`'(.)'`)
+)
+
+// Error returns the error message (error interface).
+func (x unknownTokenError) Error() string {
+	return fmt.Sprintf("not a number or operator: %q", string(x))
+}
+
+// atof takes a string as an argument and return a decimal object representing
+// that string. Strings starting in 0x or 0X are treated as hex strings.
+// Strings starting in o or 0 are treated as octal strings. Non decimal strings
+// may use underscores between digits as separators (E.g. 0xdead_beef) and are
+// converted to a uint64 intermediate representation and thus limited to how
+// much a uint64 can hold. A leading minus sign is applied after parsing the
+// number in any base (E.g. -0xff = -255).
+func atof(s string) (*decimal.Big, error) {
+	neg := false
+	if strings.HasPrefix(s, "-") && len(s) > 1 {
+		neg = true
+		s = s[1:]
+	}
+
+	base := 10
+	switch {
+	case (strings.HasPrefix(s, "0b") || strings.HasPrefix(s, "0B")) && len(s) > 2:
+		s = s[2:]
+		base = 2
+	case (strings.HasPrefix(s, "0x") || strings.HasPrefix(s, "0X")) && len(s) > 2:
+		s = s[2:]
+		base = 16
+	// Numbers starting with 0 must account for 0.xx fractional numbers not
+	// being octal numbers.
+	case (strings.HasPrefix(s, "0") || strings.HasPrefix(s, "o")) && !strings.HasPrefix(s, "0.") && len(s) > 1:
+		s = s[1:]
+		base = 8
+	}
+
+	if base == 10 {
+		var d decimal.Big
+		if _, ok := d.SetString(s); !ok || d.IsNaN(0) || (neg && d.Signbit()) {
+			return nil, errors.New("unable to convert number")
+		}
+		if neg {
+			d.Neg(&d)
+		}
+		return &d, nil
+	}
+
+	// Underscores are accepted as digit separators, but only between digits.
+	if strings.HasPrefix(s, "_") || strings.HasSuffix(s, "_") || strings.Contains(s, "__") {
+		return nil, fmt.Errorf("invalid digit separator in number: %q", s)
+	}
+	s = strings.ReplaceAll(s, "_", "")
+
+	// Non-base 10 numbers are limited to uint64 sizes.
+	ret, err := strconv.ParseUint(s, base, 64)
+	if err != nil {
+		return nil, err
+	}
+	n := bigUint(ret)
+	if neg {
+		n.Neg(n)
+	}
+	return n, nil
+}
+
+// parseNumber converts a string to a number. Integers written in the input
+// base ibase (E.g. ff with ibase 16) are parsed in that base. Anything else,
+// including numbers with a base prefix, goes through atof.
+func parseNumber(s string, ibase int) (*decimal.Big, error) {
+	if ibase == 10 || ibase == 0 {
+		return atof(s)
+	}
+	digits := strings.TrimPrefix(s, "-")
+	if digits != "" && !strings.HasPrefix(digits, "_") && !strings.HasSuffix(digits, "_") && !strings.Contains(digits, "__") {
+		if n, ok := new(mathbig.Int).SetString(strings.ReplaceAll(digits, "_", ""), ibase); ok {
+			if strings.HasPrefix(s, "-") {
+				n.Neg(n)
+			}
+			return intToBig(n), nil
+		}
+	}
+	return atof(s)
+}
+
+// tokenize splits a line of input into tokens, after removing all extraneous
+// characters. Comment lines return no tokens.
+func tokenize(line string) []string {
+	if strings.HasPrefix(line, "#") {
+		return nil
+	}
+	line = strings.TrimSpace(line)
+	line = charRe.ReplaceAllStringFunc(line, func(m string) string {
+		r, _ := utf8.DecodeRuneInString(m[1:])
+		return " " + strconv.Itoa(int(r)) + " "
+	})
+	// "Δ%" is the same as pct, and would become "%" after cleaning.
+	line = strings.ReplaceAll(line, "Δ%", " pct ")
+	line = cleanRe.ReplaceAllString(line, "")
+	// Brackets delimit blocks and don't need spaces around them.
+	line = strings.NewReplacer("[", " [ ", "]", " ] ").Replace(line)
+	return strings.Fields(line)
+}
+
+// execute processes all tokens in a line of input against the operations and
+// stack in ops. It returns true if the top of the stack should be printed
+// after the line has been processed. In case of errors, the stack, modes and
+// registers are restored to their state before the line was processed. A quit
+// command returns ErrQuit.
+func execute(ops *opsType, line string) (bool, error) {
+	stack := ops.stack
+
+	// Save the state so we can restore it to the previous state before this
+	// line was processed (in case of errors.)
+	ops.lineStart = ops.state()
+
+	tokens := tokenize(line)
+
+	// In infix mode, lines that are not valid infix expressions run as RPN,
+	// unless they look like infix (E.g. have parenthesis).
+	infix := false
+	if ops.infix {
+		rpn, err := ops.infixToRPN(line)
+		if err != nil && strings.ContainsAny(line, "(),") {
+			return false, err
+		}
+		if err == nil {
+			tokens, infix = rpn, true
+		}
+	}
+
+	autoprint, err := run(ops, tokens)
+	if infix {
+		autoprint = true
+	}
+	if errors.Is(err, ErrQuit) {
+		return false, err
+	}
+	if err != nil {
+		ops.setState(ops.lineStart)
+		// Outside of strict mode, unknown tokens just stop processing.
+		var unknown unknownTokenError
+		if errors.As(err, &unknown) && !ops.strict {
+			fmt.Fprintf(ops.out, errorMsg("Not a number or operator: %q.\n"), string(unknown))
+			fmt.Fprintln(ops.out, errorMsg("Use \"help\" for online help."))
+			return false, nil
+		}
+		return false, err
+	}
+
+	// Keep the results printed to the user.
+	if autoprint && len(stack.list) > 0 {
+		ops.saveResult(stack.top())
+	}
+
+	// Lines that change the stack or modes can be undone, unless they
+	// already manipulate the undo history.
+	if !slices.Contains(tokens, "undo") && !slices.Contains(tokens, "redo") && !ops.lineStart.equal(ops.state()) {
+		ops.saveUndo(ops.lineStart)
+	}
+	return autoprint, nil
+}
+
+// run processes a list of tokens against the operations and stack in ops and
+// returns true if the top of the stack should be printed. Processing stops
+// at the first error. It's also used to run the body of macros.
+func run(ops *opsType, tokens []string) (bool, error) {
+	stack := ops.stack
+
+	// Macros may call other macros (or themselves).
+	if ops.nesting >= maxNesting {
+		return false, fmt.Errorf("macros nested too deep (maximum = %d)", maxNesting)
+	}
+	ops.nesting++
+	defer func() { ops.nesting-- }()
+
+	opmap := ops.opmap()
+	cmdmap := ops.cmdmap()
+
+	// In step mode, show each operation inside macros (nesting > 1).
+	step := ops.step && ops.nesting > 1
+	prev := 0
+
+	autoprint := false
+	for i := 0; i < len(tokens); i++ {
+		if step && i > 0 {
+			if err := ops.stepTrace(tokens[prev:i]); err != nil {
+				return false, err
+			}
+		}
+		prev = i
+		token := tokens[i]
+
+		// Check command map. Commands consume the tokens following them.
+		if handler, ok := cmdmap[token]; ok {
+			depth, top := len(stack.list), stack.top()
+			n, err := handler.fn(tokens[i+1:])
+			if err != nil {
+				return false, err
+			}
+			i += n
+			if err := checkDepth(ops); err != nil {
+				return false, err
+			}
+			// Print the top of the stack if the command changed it.
+			autoprint = (len(stack.list) != depth || (depth > 0 && stack.top() != top))
+			continue
+		}
+
+		// Check operator map
+		if handler, ok := opmap[token]; ok {
+			results, remove, err := operation(handler, stack)
+			if err == nil {
+				err = checkDepth(ops)
+			}
+			if err != nil {
+				return false, err
+			}
+			// If the particular handler does not ignore results from the
+			// function, set autoprint to true. This will cause the top of
+			// the stack results to be printed.
+			autoprint = (len(results) > 0 || remove > 0)
+			continue
+		}
+
+		// User defined macros.
+		if body, ok := ops.macros[token]; ok {
+			depth, top := len(stack.list), stack.top()
+			if _, err := run(ops, body); err != nil {
+				return false, fmt.Errorf("%s: %w", token, err)
+			}
+			autoprint = (len(stack.list) != depth || (depth > 0 && stack.top() != top))
+			continue
+		}
+
+		// Help
+		if slices.Contains(helpTokens, token) {
+			if err := ops.help(); err != nil {
+				fmt.Fprintln(ops.out, errorMsg(err))
+			}
+			continue
+		}
+
+		if slices.Contains(quitTokens, token) {
+			return false, ErrQuit
+		}
+
+		// Previous results.
+		if m := ansRe.FindStringSubmatch(token); m != nil {
+			n, err := ops.result(m[1])
+			if err != nil {
+				return false, err
+			}
+			stack.push(big().Copy(n))
+			continue
+		}
+
+		// At this point, it's either a number or not recognized.
+		n, err := parseNumber(token, ops.ibase)
+		if err != nil {
+			return false, unknownTokenError(token)
+		}
+		// Valid number
+		stack.push(n)
+		if err := checkDepth(ops); err != nil {
+			return false, err
+		}
+	}
+	if step && len(tokens) > 0 {
+		if err := ops.stepTrace(tokens[prev:]); err != nil {
+			return false, err
+		}
+	}
+	return autoprint, nil
+}
+
+// checkDepth returns an error if the stack holds more items than allowed.
+func checkDepth(ops *opsType) error {
+	if ops.maxDepth > 0 && len(ops.stack.list) > ops.maxDepth {
+		return fmt.Errorf("stack depth limit exceeded (%d items, use \"set maxdepth\" to change)", ops.maxDepth)
+	}
+	return nil
+}
+
+// expandHistory replaces all history references in line with the
+// corresponding lines in history. "!!" refers to the previous line and "!n"
+// refers to line n (starting at 1).
+func expandHistory(line string, history []string) (string, error) {
+	var err error
+	ret := historyRe.ReplaceAllStringFunc(line, func(ref string) string {
+		n := len(history)
+		if ref != "!!" {
+			n, _ = strconv.Atoi(ref[1:])
+		}
+		if n < 1 || n > len(history) {
+			err = fmt.Errorf("%s: event not found", ref)
+			return ref
+		}
+		return history[n-1]
+	})
+	return ret, err
+}
+
+// resultType holds the values available to output templates (see
+// printResult).
+type resultType struct {
+	Raw   string   // x with all stored digits (the default output)
+	Fmt   string   // x formatted with the current display options
+	Human string   // x with thousands separators
+	Hex   string   // x in hexadecimal
+	Oct   string   // x in octal
+	Bin   string   // x in binary
+	Stack []string // All stack items, x last
+}
+
+// printResult writes the top of the stack to w, as printed after a
+// non-interactive execution. An empty format prints the raw value, followed
+// by the humanized form if "human always" is set. Formats containing "{{"
+// are text/template templates executed with a resultType. Anything else is
+// a printf-style format (E.g. "%.2f") applied to x.
+func printResult(w io.Writer, ops *opsType, format string) error {
+	stack := ops.stack
+	x := stack.top()
+	switch {
+	case format == "" && ops.showHuman == humanAlways && ops.base == 10:
+		_, err := fmt.Fprintf(w, "%s (%s)\n", x, humanize(ops.ctx, x, ops.displayType))
+		return err
+	case format == "":
+		_, err := fmt.Fprintln(w, x)
+		return err
+	case !strings.Contains(format, "{{"):
+		_, err := fmt.Fprintf(w, format+"\n", x)
+		return err
+	}
+
+	tmpl, err := template.New("format").Parse(format)
+	if err != nil {
+		return err
+	}
+	disp := ops.displayType
+	r := resultType{
+		Raw:   x.String(),
+		Fmt:   disp.format(ops.ctx, x),
+		Human: humanize(ops.ctx, x, disp),
+	}
+	for _, b := range []struct {
+		s    *string
+		base int
+	}{{&r.Hex, 16}, {&r.Oct, 8}, {&r.Bin, 2}} {
+		d := disp
+		d.base = b.base
+		*b.s = d.format(ops.ctx, x)
+	}
+	for _, n := range stack.list {
+		r.Stack = append(r.Stack, n.String())
+	}
+	if err := tmpl.Execute(w, r); err != nil {
+		return err
+	}
+	_, err = fmt.Fprintln(w)
+	return err
+}
+
+// prompt returns the interactive prompt based on the workspace, base, and
+// degrees/radian mode, or formatted with format, if set.
+func prompt(ops *opsType, format string) string {
+	if format != "" {
+		return formatPrompt(ops, format)
+	}
+	p := "> "
+	switch {
+	case ops.infix:
+		p = "alg> "
+	case ops.degmode:
+		p = "deg> "
+	case ops.ibase != 10:
+		p = fmt.Sprintf("i%d> ", ops.ibase)
+	case ops.base == 8:
+		p = "oct> "
+	case ops.base == 16:
+		p = "hex> "
+	case ops.base == 2:
+		p = "bin> "
+	case ops.base != 10:
+		p = fmt.Sprintf("b%d> ", ops.base)
+	}
+	// Show the workspace name, unless it's the default one.
+	if ops.workspace != defaultWorkspace {
+		p = ops.workspace + " " + p
+	}
+	return p
+}
+
+// formatPrompt returns format with the following placeholders
+// replaced: %b (output base: dec, hex, oct, bin, or bN), %i (input base),
+// %a (angle mode: deg or rad), %n (stack depth), %p (decimals), %P (working
+// precision), %w (workspace), and %% (a literal %).
+func formatPrompt(ops *opsType, format string) string {
+	base := fmt.Sprintf("b%d", ops.base)
+	switch ops.base {
+	case 10:
+		base = "dec"
+	case 16:
+		base = "hex"
+	case 8:
+		base = "oct"
+	case 2:
+		base = "bin"
+	}
+	angle := "rad"
+	if ops.degmode {
+		angle = "deg"
+	}
+	return strings.NewReplacer(
+		"%b", base,
+		"%i", strconv.Itoa(ops.ibase),
+		"%a", angle,
+		"%n", strconv.Itoa(len(ops.stack.list)),
+		"%p", strconv.Itoa(ops.decimals),
+		"%P", strconv.Itoa(ops.ctx.Precision),
+		"%w", ops.workspace,
+		"%%", "%",
+	).Replace(format)
+}
+
+// Version returns the version of the program, set at build time in Build.
+func Version() string {
+	if Build == "" {
+		return "no version info"
+	}
+	return "v" + Build
+}
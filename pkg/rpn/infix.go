@@ -0,0 +1,225 @@
+// This file is part of rpn, a simple and useful CLI RPN calculator.
+// For further information, check https://github.com/marcopaganini/rpn
+//
+// (C) Sep/2024 by Marco Paganini <paganini AT paganini DOT net>
+
+package rpn
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+type (
+	// infixParser converts infix (algebraic) expressions to RPN tokens
+	// using a Pratt (top down operator precedence) parser.
+	infixParser struct {
+		ops    *opsType
+		tokens []string // Lexical tokens of the expression
+		pos    int      // Position of the next token
+		out    []string // RPN tokens
+	}
+
+	// infixOp holds the binding powers of a binary operator and its RPN
+	// equivalent. Right associative operators bind tighter on the left.
+	infixOp struct {
+		lbp, rbp int
+		rpn      string
+	}
+)
+
+// infixOps contains the binary operators accepted in infix expressions.
+var infixOps = map[string]infixOp{
+	"+": {1, 2, "+"},
+	"-": {1, 2, "-"},
+	"*": {3, 4, "*"},
+	"/": {3, 4, "/"},
+	"%": {3, 4, "mod"},
+	"^": {7, 6, "^"},
+}
+
+// unaryBP is the binding power of unary minus and plus: -2^2 = -4, but
+// -2*3 = (-2)*3.
+const unaryBP = 5
+
+// infixToRPN converts an infix expression (E.g. "(2+3)*4^2") to a list of RPN
+// tokens (E.g. "2 3 + 4 2 ^ *"). Function calls (E.g. "sqr(2)" or
+// "max(1, 2)") use operations taking as many arguments as passed. Names
+// without parenthesis must be operations without arguments (E.g. PI) or
+// macros.
+func (x *opsType) infixToRPN(expr string) ([]string, error) {
+	tokens, err := lexInfix(expr)
+	if err != nil {
+		return nil, err
+	}
+	if len(tokens) == 0 {
+		return nil, fmt.Errorf("empty expression")
+	}
+	p := &infixParser{ops: x, tokens: tokens}
+	if err := p.parse(0); err != nil {
+		return nil, err
+	}
+	if p.pos < len(p.tokens) {
+		return nil, fmt.Errorf("unexpected %q in expression", p.tokens[p.pos])
+	}
+	return p.out, nil
+}
+
+// lexInfix splits an infix expression into numbers, names, operators,
+// parenthesis, and commas.
+func lexInfix(expr string) ([]string, error) {
+	var tokens []string
+	r := []rune(expr)
+	for i := 0; i < len(r); {
+		c := r[i]
+		switch {
+		case unicode.IsSpace(c):
+			i++
+		case strings.ContainsRune("+-*/%^(),", c):
+			tokens = append(tokens, string(c))
+			i++
+		case unicode.IsDigit(c) || c == '.':
+			// Numbers, including prefixes (0x), digit separators (1_000)
+			// and exponents (1e-3).
+			j := i + 1
+			for j < len(r) {
+				if unicode.IsLetter(r[j]) || unicode.IsDigit(r[j]) || r[j] == '.' || r[j] == '_' {
+					j++
+					continue
+				}
+				if (r[j] == '-' || r[j] == '+') && (r[j-1] == 'e' || r[j-1] == 'E') && !strings.HasPrefix(string(r[i:j]), "0x") {
+					j++
+					continue
+				}
+				break
+			}
+			tokens = append(tokens, string(r[i:j]))
+			i = j
+		case unicode.IsLetter(c) || c == '_':
+			j := i + 1
+			for j < len(r) && (unicode.IsLetter(r[j]) || unicode.IsDigit(r[j]) || r[j] == '_') {
+				j++
+			}
+			tokens = append(tokens, string(r[i:j]))
+			i = j
+		default:
+			return nil, fmt.Errorf("invalid character in expression: %q", c)
+		}
+	}
+	return tokens, nil
+}
+
+// peek returns the next token, or an empty string at the end.
+func (p *infixParser) peek() string {
+	if p.pos < len(p.tokens) {
+		return p.tokens[p.pos]
+	}
+	return ""
+}
+
+// expect consumes the next token, which must be tok.
+func (p *infixParser) expect(tok string) error {
+	if p.peek() != tok {
+		if p.peek() == "" {
+			return fmt.Errorf("missing %q in expression", tok)
+		}
+		return fmt.Errorf("expected %q, got %q in expression", tok, p.peek())
+	}
+	p.pos++
+	return nil
+}
+
+// parse parses an expression with operators binding tighter than minBP.
+func (p *infixParser) parse(minBP int) error {
+	if err := p.operand(); err != nil {
+		return err
+	}
+	for {
+		op, ok := infixOps[p.peek()]
+		if !ok || op.lbp < minBP {
+			return nil
+		}
+		p.pos++
+		if err := p.parse(op.rbp); err != nil {
+			return err
+		}
+		p.out = append(p.out, op.rpn)
+	}
+}
+
+// operand parses a number, name, function call, parenthesized expression,
+// or an operand preceded by unary minus or plus.
+func (p *infixParser) operand() error {
+	tok := p.peek()
+	p.pos++
+
+	switch {
+	case tok == "":
+		return fmt.Errorf("unexpected end of expression")
+	case tok == "-" || tok == "+":
+		if err := p.parse(unaryBP); err != nil {
+			return err
+		}
+		if tok == "-" {
+			p.out = append(p.out, "chs")
+		}
+		return nil
+	case tok == "(":
+		if err := p.parse(0); err != nil {
+			return err
+		}
+		return p.expect(")")
+	case unicode.IsDigit(rune(tok[0])) || tok[0] == '.':
+		if _, err := parseNumber(tok, p.ops.ibase); err != nil {
+			return fmt.Errorf("invalid number in expression: %q", tok)
+		}
+		p.out = append(p.out, tok)
+		return nil
+	case unicode.IsLetter(rune(tok[0])) || tok[0] == '_':
+		return p.name(tok)
+	}
+	return fmt.Errorf("unexpected %q in expression", tok)
+}
+
+// name parses a function call or a name without arguments.
+func (p *infixParser) name(name string) error {
+	handler, isOp := p.ops.opmap()[name]
+	_, isMacro := p.ops.macros[name]
+
+	if p.peek() != "(" {
+		if (isOp && handler.numArgs == 0) || isMacro {
+			p.out = append(p.out, name)
+			return nil
+		}
+		return fmt.Errorf("unknown name in expression: %q", name)
+	}
+
+	// Function call.
+	p.pos++
+	nargs := 0
+	if p.peek() != ")" {
+		for {
+			if err := p.parse(0); err != nil {
+				return err
+			}
+			nargs++
+			if p.peek() != "," {
+				break
+			}
+			p.pos++
+		}
+	}
+	if err := p.expect(")"); err != nil {
+		return err
+	}
+	switch {
+	case isMacro:
+	case !isOp:
+		return fmt.Errorf("unknown function in expression: %q", name)
+	case handler.numArgs != nargs:
+		return fmt.Errorf("%s takes %d argument(s), got %d", name, handler.numArgs, nargs)
+	}
+	p.out = append(p.out, name)
+	return nil
+}
@@ -0,0 +1,243 @@
+// This file is part of rpn, a simple and useful CLI RPN calculator.
+// For further information, check https://github.com/marcopaganini/rpn
+//
+// (C) Sep/2024 by Marco Paganini <paganini AT paganini DOT net>
+
+package rpn
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/ericlagergren/decimal"
+)
+
+// maxNesting is the maximum nesting level of macros.
+const maxNesting = 100
+
+// macroArg parses a macro argument from the beginning of tokens. A macro
+// argument is either a block of tokens enclosed in brackets (E.g. [ 2 * ])
+// or a single token, usually the name of a macro. It returns the tokens in
+// the macro and the number of tokens consumed.
+func macroArg(tokens []string) ([]string, int, error) {
+	if len(tokens) == 0 {
+		return nil, 0, errors.New("missing macro")
+	}
+	if tokens[0] == "]" {
+		return nil, 1, errors.New("unexpected \"]\"")
+	}
+	if tokens[0] != "[" {
+		return tokens[:1], 1, nil
+	}
+
+	// Find the matching closing bracket.
+	level := 0
+	for ix, token := range tokens {
+		switch token {
+		case "[":
+			level++
+		case "]":
+			level--
+		}
+		if level == 0 {
+			return tokens[1:ix], ix + 1, nil
+		}
+	}
+	return nil, len(tokens), errors.New("missing \"]\" at the end of block")
+}
+
+// defineMacro creates (or replaces) the macro name. Names of operations,
+// commands, and numbers can't be used.
+func (x *opsType) defineMacro(name string, body []string) error {
+	_, isOp := x.opmap()[name]
+	_, isCmd := x.cmdmap()[name]
+	_, err := atof(name)
+	if isOp || isCmd || err == nil || name == "[" || name == "]" || ansRe.MatchString(name) ||
+		slices.Contains(helpTokens, name) || slices.Contains(quitTokens, name) {
+		return fmt.Errorf("invalid macro name: %q", name)
+	}
+	if x.macros == nil {
+		x.macros = map[string][]string{}
+	}
+	x.macros[name] = body
+	return nil
+}
+
+// apply runs a macro on a temporary stack holding only args (bottom first)
+// and returns the resulting stack. The original stack is left untouched.
+func (x *opsType) apply(body []string, args ...*decimal.Big) ([]*decimal.Big, error) {
+	list := x.stack.list
+	defer func() { x.stack.list = list }()
+
+	x.stack.list = slices.Clone(args)
+	if _, err := run(x, body); err != nil {
+		return nil, err
+	}
+	return x.stack.list, nil
+}
+
+// apply1 runs a macro using apply and makes sure it returns a single value.
+func (x *opsType) apply1(body []string, args ...*decimal.Big) (*decimal.Big, error) {
+	ret, err := x.apply(body, args...)
+	if err != nil {
+		return nil, err
+	}
+	if len(ret) != 1 {
+		return nil, fmt.Errorf("%s: must result in exactly one value, got %d", strings.Join(body, " "), len(ret))
+	}
+	return ret[0], nil
+}
+
+// solve finds a root of the one argument macro body between lo and hi
+// using bisection. The macro must have opposite signs at lo and hi.
+func (x *opsType) solve(body []string, lo, hi *decimal.Big) (*decimal.Big, error) {
+	flo, err := x.apply1(body, lo)
+	if err != nil {
+		return nil, err
+	}
+	fhi, err := x.apply1(body, hi)
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case flo.IsNaN(0) || fhi.IsNaN(0):
+		return nil, errors.New("solve: macro result is not a number at the bounds")
+	case flo.Sign() == 0:
+		return lo, nil
+	case fhi.Sign() == 0:
+		return hi, nil
+	case flo.Sign() == fhi.Sign():
+		return nil, errors.New("solve: macro must have opposite signs at the bounds")
+	}
+
+	lo, hi = big().Copy(lo), big().Copy(hi)
+	mid := big()
+	// Each step halves the interval. Stop when the midpoint can't be told
+	// apart from the bounds at the working precision.
+	for i := 0; i < 10*x.ctx.Precision+100; i++ {
+		x.ctx.Quo(mid, x.ctx.Add(mid, lo, hi), bigUint(2))
+		if mid.Cmp(lo) == 0 || mid.Cmp(hi) == 0 {
+			break
+		}
+		fmid, err := x.apply1(body, mid)
+		if err != nil {
+			return nil, err
+		}
+		if fmid.IsNaN(0) {
+			return nil, fmt.Errorf("solve: macro result is not a number at %s", mid)
+		}
+		if fmid.Sign() == 0 {
+			break
+		}
+		if fmid.Sign() == flo.Sign() {
+			lo.Copy(mid)
+		} else {
+			hi.Copy(mid)
+		}
+	}
+	return mid, nil
+}
+
+// deriv returns the derivative of the one argument macro body at v, using
+// a central difference computed with ctx. The step is chosen so that the
+// truncation and rounding errors are both well below the precision of ctx,
+// which the caller is expected to raise (also while evaluating the macro).
+func (x *opsType) deriv(ctx decimal.Context, body []string, v *decimal.Big) (*decimal.Big, error) {
+	// h = max(|v|, 1) * 10^(-precision/3)
+	h := big().Abs(v)
+	if h.Cmp(bigUint(1)) < 0 {
+		h = bigUint(1)
+	}
+	ctx.Mul(h, h, big().SetMantScale(1, ctx.Precision/3))
+
+	fp, err := x.apply1(body, ctx.Add(big(), v, h))
+	if err != nil {
+		return nil, err
+	}
+	fm, err := x.apply1(body, ctx.Sub(big(), v, h))
+	if err != nil {
+		return nil, err
+	}
+	z := ctx.Sub(big(), fp, fm)
+	return ctx.Quo(z, z, ctx.Mul(big(), h, bigUint(2))), nil
+}
+
+// saveDefs saves all macros and registers to a file, as rpn commands that
+// recreate them when loaded with loadDefs. Registers holding NaN or infinity
+// are skipped with a note.
+func (x *opsType) saveDefs(fname string) error {
+	var sb strings.Builder
+	sb.WriteString("# rpn definitions (load with \"loaddefs\")\n")
+
+	names := []string{}
+	for name := range x.macros {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(&sb, "def %s [ %s ]\n", name, strings.Join(x.macros[name], " "))
+	}
+
+	names = []string{}
+	for name := range x.registers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		v := x.registers[name]
+		// NaN and infinity can't be read back by loadDefs.
+		if !v.IsFinite() {
+			if !x.quiet {
+				fmt.Fprintf(x.out, warnMsg("Note: register %s (%s) not saved\n"), name, v)
+			}
+			continue
+		}
+		fmt.Fprintf(&sb, "%s sto %s d\n", v, name)
+	}
+	return os.WriteFile(fname, []byte(sb.String()), 0o644)
+}
+
+// loadDefs runs all commands in a file saved by saveDefs. The stack is
+// restored after the file is loaded, so only the definitions remain. Values
+// are saved in decimal, so the file is read with ibase 10.
+func (x *opsType) loadDefs(fname string) error {
+	f, err := os.Open(fname)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	list := slices.Clone(x.stack.list)
+	ibase := x.ibase
+	defer func() { x.stack.list, x.ibase = list, ibase }()
+	x.ibase = 10
+
+	scanner := bufio.NewScanner(f)
+	for lineno := 1; scanner.Scan(); lineno++ {
+		if _, err := run(x, tokenize(scanner.Text())); err != nil {
+			return fmt.Errorf("%s:%d: %w", fname, lineno, err)
+		}
+	}
+	return scanner.Err()
+}
+
+// stepTrace shows the tokens just executed and the stack when in step mode,
+// and waits for the user to press Enter (when possible). Typing "q" stops
+// the execution.
+func (x *opsType) stepTrace(tokens []string) error {
+	fmt.Fprintln(x.out, warnMsg("step: "+strings.Join(tokens, " ")))
+	x.stack.print(x.out, x.ctx, x.displayType, false)
+	if x.stepWait == nil {
+		return nil
+	}
+	line, err := x.stepWait()
+	if err != nil || slices.Contains(quitTokens, strings.TrimSpace(line)) {
+		return errors.New("stopped by user")
+	}
+	return nil
+}
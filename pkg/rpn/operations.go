@@ -0,0 +1,1755 @@
+// This file is part of rpn, a simple and useful CLI RPN calculator.
+// For further information, check https://github.com/marcopaganini/rpn
+//
+// (C) Sep/2024 by Marco Paganini <paganini AT paganini DOT net>
+package rpn
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	mathbig "math/big"
+	"os"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/ericlagergren/decimal"
+)
+
+type (
+	// ophandler contains the handler for a single operation.  numArgs
+	// indicates how many arguments the function needs in the stack.
+	ophandler struct {
+		op      string // operator or command
+		desc    string // operation description (used by help)
+		numArgs int    // Number of arguments to function
+
+		// Function receives the entire inverted stack (x=0, y=1, etc) and
+		// returns the number of elements to be popped from the stack, and a
+		// list of elements to be added pushes to the stack.
+		fn func([]*decimal.Big) ([]*decimal.Big, int, error)
+	}
+
+	// cmdhandler contains the handler for a command that reads its
+	// arguments from the input line instead of the stack.
+	cmdhandler struct {
+		cmd  string // command
+		args string // arguments (used by help)
+		desc string // command description (used by help)
+
+		// Function receives the list of tokens following the command in
+		// the input line and returns how many of them were consumed.
+		fn func([]string) (int, error)
+	}
+
+	// opsType contains the base information for a list of operations and
+	// their descriptions. The operations go in a list of interfaces so
+	// we can also use strings and print them in the help() function.
+	opsType struct {
+		displayType                                // Display options
+		ctx         decimal.Context                // Decimal context used by operations
+		debug       bool                           // Debug state
+		strict      bool                           // Unknown tokens are errors
+		keepGoing   bool                           // Scripts continue with the next line after errors
+		quiet       bool                           // Suppress notes
+		out         io.Writer                      // Output for messages and stack displays
+		degmode     bool                           // Degrees mode (default = Radians)
+		stack       *stackType                     // stack object to use
+		ops         []interface{}                  // list of ophandlers, cmdhandlers & descriptions
+		history     []string                       // Interactive input lines (for history expansion)
+		units       *unitsDB                       // Unit definitions (loaded on first use)
+		macros      map[string][]string            // User defined macros
+		step        bool                           // Step through macros
+		stepWait    func() (string, error)         // Waits for the user in step mode
+		nesting     int                            // Current macro nesting level
+		registers   map[string]*decimal.Big        // Storage registers
+		maxDepth    int                            // Maximum number of items in the stack (0 = unlimited)
+		ibase       int                            // Base for numbers entered without a prefix
+		infix       bool                           // Evaluate infix expressions (alg mode)
+		workspace   string                         // Name of the current workspace
+		workspaces  map[string]*workspaceType      // Inactive workspaces
+		results     []*decimal.Big                 // Printed results, newest last (for ans)
+		lineStart   stateType                      // State at the start of the current line
+		undoList    []stateType                    // States before each line (for undo)
+		redoList    []stateType                    // Undone states (for redo)
+		clipboard   Clipboard                      // Clipboard used by copy and paste
+		pager       func() (io.WriteCloser, error) // Opens the pager used by help
+
+		// Maps of operations and commands, built on first use by opmap and
+		// cmdmap. The list of operations doesn't change after newOpsType
+		// (macros are kept in macros, and plugins are added by newOpsType).
+		opcache  opmapType
+		cmdcache cmdmapType
+	}
+
+	// stateType holds a snapshot of the stack, modes and registers, used by
+	// undo/redo. All numbers are copies, since operations may change numbers
+	// in place.
+	stateType struct {
+		displayType
+		list      []*decimal.Big
+		labels    []string
+		degmode   bool
+		ibase     int
+		registers map[string]*decimal.Big
+	}
+
+	// opmapType is a handler to operation map, used to find the right
+	// operation function to call.
+	opmapType map[string]ophandler
+
+	// cmdmapType is a handler to command map, used to find the right
+	// command function to call.
+	cmdmapType map[string]cmdhandler
+)
+
+// maxPrecision is the largest working precision accepted by prec.
+const maxPrecision = 10000
+
+// isInteger returns true if s is a (possibly signed) decimal integer.
+func isInteger(s string) bool {
+	s = strings.TrimPrefix(s, "-")
+	if s == "" {
+		return false
+	}
+	for _, c := range s {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// roundingModes maps the names accepted by the rounding command to the
+// corresponding decimal rounding modes.
+var roundingModes = map[string]decimal.RoundingMode{
+	"half-even": decimal.ToNearestEven,
+	"half-up":   decimal.ToNearestAway,
+	"half-down": decimal.ToNearestTowardZero,
+	"down":      decimal.ToZero,
+	"up":        decimal.AwayFromZero,
+	"ceiling":   decimal.ToPositiveInf,
+	"floor":     decimal.ToNegativeInf,
+}
+
+// radOrDeg converts the value passed to radians if degmode (degrees
+// mode) is set. Otherwise, it just returns the same value (radians).
+func radOrDeg(ctx decimal.Context, n *decimal.Big, degmode bool) *decimal.Big {
+	if degmode {
+		pi := ctx.Pi(big())
+		npi := big().Mul(n, pi)
+		return ctx.Quo(big(), npi, bigUint(180))
+	}
+	return n
+}
+
+const (
+	// maxUndo is the maximum number of states kept for undo.
+	maxUndo = 100
+
+	// defaultMaxDepth is the default maximum number of items in the stack.
+	defaultMaxDepth = 1000000
+
+	// maxResults is the maximum number of results kept for ans.
+	maxResults = 100
+)
+
+// saveResult adds a copy of a printed result to the result history.
+func (x *opsType) saveResult(n *decimal.Big) {
+	x.results = append(x.results, big().Copy(n))
+	if len(x.results) > maxResults {
+		x.results = x.results[1:]
+	}
+}
+
+// result returns the nth previous result (1 or empty = the last one).
+func (x *opsType) result(nth string) (*decimal.Big, error) {
+	n := 1
+	if nth != "" {
+		n, _ = strconv.Atoi(nth)
+	}
+	if n < 1 || n > len(x.results) {
+		return nil, fmt.Errorf("no such result: ans%s (%d results available)", nth, len(x.results))
+	}
+	return x.results[len(x.results)-n], nil
+}
+
+// copyList returns a deep copy of a list of numbers.
+func copyList(list []*decimal.Big) []*decimal.Big {
+	ret := make([]*decimal.Big, len(list))
+	for i, n := range list {
+		ret[i] = big().Copy(n)
+	}
+	return ret
+}
+
+// copyRegisters returns a deep copy of a map of registers.
+func copyRegisters(registers map[string]*decimal.Big) map[string]*decimal.Big {
+	ret := make(map[string]*decimal.Big, len(registers))
+	for k, v := range registers {
+		ret[k] = big().Copy(v)
+	}
+	return ret
+}
+
+// state returns a snapshot of the current stack, modes and registers.
+func (x *opsType) state() stateType {
+	return stateType{
+		displayType: x.displayType,
+		list:        copyList(x.stack.list),
+		labels:      x.stack.labelList(),
+		degmode:     x.degmode,
+		ibase:       x.ibase,
+		registers:   copyRegisters(x.registers),
+	}
+}
+
+// setState restores the stack, modes and registers from a snapshot.
+func (x *opsType) setState(s stateType) {
+	x.displayType = s.displayType
+	x.stack.list = copyList(s.list)
+	x.stack.setLabelList(s.labels)
+	x.degmode = s.degmode
+	x.ibase = s.ibase
+	x.registers = copyRegisters(s.registers)
+}
+
+// saveUndo records a state to be restored by undo, discarding the oldest
+// state when the list is full. Any undone states are discarded.
+func (x *opsType) saveUndo(s stateType) {
+	x.undoList = append(x.undoList, s)
+	if len(x.undoList) > maxUndo {
+		x.undoList = x.undoList[1:]
+	}
+	x.redoList = nil
+}
+
+// equal returns true if both states are the same.
+func (x stateType) equal(s stateType) bool {
+	same := func(a, b *decimal.Big) bool { return a.CmpTotal(b) == 0 }
+	return x.displayType == s.displayType && x.degmode == s.degmode && x.ibase == s.ibase &&
+		slices.EqualFunc(x.list, s.list, same) && slices.Equal(x.labels, s.labels) &&
+		maps.EqualFunc(x.registers, s.registers, same)
+}
+
+func newOpsType(ctx decimal.Context, stack *stackType) *opsType {
+	ctx.MaxScale, ctx.MinScale = maxScale, -maxScale
+	ret := &opsType{
+		displayType: displayType{
+			base:     10,
+			decimals: 6,
+			wordSize: defaultWordSize,
+		},
+		ctx:       ctx,
+		out:       os.Stdout,
+		stack:     stack,
+		maxDepth:  defaultMaxDepth,
+		ibase:     10,
+		workspace: defaultWorkspace,
+	}
+	ret.ops = []interface{}{
+		// Header
+		"BOLD:Online help for " + programTitle + " (" + Version() + ").",
+		"BOLD:See http://github.com/marcopaganini/rpn for full details.",
+		"",
+		"BOLD:Data entry:",
+		"  number <ENTER> - push a number on top of the stack.",
+		"  operation <ENTER> - perform an operation on the stack (see below).",
+		"",
+		"  It's also possible to separate multiple operations with space:",
+		"    10 2 3 * - (result = 4)",
+		"",
+		"  Prefix numbers with 0x to indicate hexadecimal, 0 for octal, 0b for binary.",
+		"  Non-decimal numbers may use _ to separate digits (E.g. 0xdead_beef)",
+		"  and can be negative (E.g. -0xff).",
+		"",
+		"BOLD:Operations:",
+		"",
+		"BOLD:Basic Operations",
+		ophandler{"+", "Add x to y", 2, func(a []*decimal.Big) ([]*decimal.Big, int, error) {
+			return []*decimal.Big{ctx.Add(big(), a[0], a[1])}, 2, nil
+		}},
+		ophandler{"-", "Subtract x from y", 2, func(a []*decimal.Big) ([]*decimal.Big, int, error) {
+			return []*decimal.Big{ctx.Sub(big(), a[1], a[0])}, 2, nil
+		}},
+		ophandler{"*", "Multiply x and y", 2, func(a []*decimal.Big) ([]*decimal.Big, int, error) {
+			return []*decimal.Big{ctx.Mul(big(), a[0], a[1])}, 2, nil
+		}},
+		ophandler{"/", "Divide y by x", 2, func(a []*decimal.Big) ([]*decimal.Big, int, error) {
+			return []*decimal.Big{ctx.Quo(big(), a[1], a[0])}, 2, nil
+		}},
+		ophandler{"chs", "Change signal of x", 1, func(a []*decimal.Big) ([]*decimal.Big, int, error) {
+			return []*decimal.Big{big().Neg(a[0])}, 1, nil
+		}},
+		ophandler{"inv", "Invert x (1/x)", 1, func(a []*decimal.Big) ([]*decimal.Big, int, error) {
+			return []*decimal.Big{ctx.Quo(big(), bigUint(1), a[0])}, 1, nil
+		}},
+		ophandler{"^", "Raise y to the power of x", 2, func(a []*decimal.Big) ([]*decimal.Big, int, error) {
+			return []*decimal.Big{ctx.Pow(big(), a[1], a[0])}, 2, nil
+		}},
+		ophandler{"mod", "Calculates y modulo x", 2, func(a []*decimal.Big) ([]*decimal.Big, int, error) {
+			return []*decimal.Big{ctx.Rem(big(), a[1], a[0])}, 2, nil
+		}},
+		ophandler{"//", "Integer division of y by x (truncated)", 2, func(a []*decimal.Big) ([]*decimal.Big, int, error) {
+			return []*decimal.Big{quoInt(ctx, a[1], a[0])}, 2, nil
+		}},
+		ophandler{"idiv", "Same as //", 2, func(a []*decimal.Big) ([]*decimal.Big, int, error) {
+			return []*decimal.Big{quoInt(ctx, a[1], a[0])}, 2, nil
+		}},
+		ophandler{"sqr", "Calculate square root of x", 1, func(a []*decimal.Big) ([]*decimal.Big, int, error) {
+			return []*decimal.Big{ctx.Sqrt(big(), a[0])}, 1, nil
+		}},
+		ophandler{"cbr", "Calculate cubic root of x", 1, func(a []*decimal.Big) ([]*decimal.Big, int, error) {
+			e := ctx.Quo(big(), bigFloat("1"), bigFloat("3"))
+			return []*decimal.Big{ctx.Pow(big(), a[0], e)}, 1, nil
+		}},
+		ophandler{"root", "Calculate the x-th root of y", 2, func(a []*decimal.Big) ([]*decimal.Big, int, error) {
+			z, err := root(ctx, a[1], a[0])
+			return []*decimal.Big{z}, 2, err
+		}},
+		ophandler{"hypot", "Calculate the hypotenuse sqrt(x²+y²)", 2, func(a []*decimal.Big) ([]*decimal.Big, int, error) {
+			return []*decimal.Big{hypot(ctx, a[0], a[1])}, 2, nil
+		}},
+		ophandler{"quad", "Real roots of the quadratic equation z·t² + y·t + x = 0", 3, func(a []*decimal.Big) ([]*decimal.Big, int, error) {
+			if a[2].Sign() == 0 {
+				return nil, 3, errors.New("not a quadratic equation (z = 0)")
+			}
+			roots, re, im := quadratic(ctx, a[2], a[1], a[0])
+			if roots == nil {
+				return nil, 3, fmt.Errorf("complex roots: %s ± %si", ret.format(ctx, re), ret.format(ctx, im))
+			}
+			return roots, 3, nil
+		}},
+		cmdhandler{"poly", "<n>", "Evaluate the polynomial of degree n at x, using the n+1 coefficients above it, highest degree first (E.g. 1 2 3 5 poly 2 = 1·5² + 2·5 + 3)", func(args []string) (int, error) {
+			if len(args) < 1 {
+				return 0, errors.New("usage: poly <n>")
+			}
+			n, err := strconv.Atoi(args[0])
+			if err != nil || n < 0 {
+				return 1, fmt.Errorf("invalid polynomial degree: %q", args[0])
+			}
+			if len(stack.list) < n+2 {
+				return 1, fmt.Errorf("this operation requires at least %d items in the stack", n+2)
+			}
+			x := stack.top()
+			coeffs := stack.list[len(stack.list)-n-2 : len(stack.list)-1]
+
+			// Horner's rule: ((c0·x + c1)·x + c2)...
+			z := big().Copy(coeffs[0])
+			for _, c := range coeffs[1:] {
+				ctx.Add(z, ctx.Mul(z, z, x), c)
+			}
+			stack.list = append(stack.list[:len(stack.list)-n-2], z)
+			return 1, nil
+		}},
+		ophandler{"%", "Calculate x% of y", 2, func(a []*decimal.Big) ([]*decimal.Big, int, error) {
+			z := ctx.Mul(big(), a[0], a[1])
+			ctx.Quo(z, z, bigUint(100))
+			return []*decimal.Big{z}, 1, nil
+		}},
+		ophandler{"pct", "Percent change from y to x (also Δ%)", 2, func(a []*decimal.Big) ([]*decimal.Big, int, error) {
+			z := ctx.Sub(big(), a[0], a[1])
+			ctx.Mul(z, z, bigUint(100))
+			return []*decimal.Big{ctx.Quo(z, z, a[1])}, 2, nil
+		}},
+		ophandler{"sum", "Sum all elements in stack", 1, func(a []*decimal.Big) ([]*decimal.Big, int, error) {
+			sum := big()
+			for _, v := range a {
+				ctx.Add(sum, sum, v)
+			}
+			return []*decimal.Big{sum}, len(a), nil
+		}},
+		ophandler{"mean", "Arithmetic mean of all elements in stack", 1, func(a []*decimal.Big) ([]*decimal.Big, int, error) {
+			sum := big()
+			for _, v := range a {
+				ctx.Add(sum, sum, v)
+			}
+			return []*decimal.Big{ctx.Quo(sum, sum, bigUint(uint64(len(a))))}, len(a), nil
+		}},
+		ophandler{"prod", "Multiply all elements in stack", 1, func(a []*decimal.Big) ([]*decimal.Big, int, error) {
+			prod := bigUint(1)
+			for _, v := range a {
+				ctx.Mul(prod, prod, v)
+			}
+			return []*decimal.Big{prod}, len(a), nil
+		}},
+		ophandler{"csum", "Replace stack elements with their cumulative sums", 1, func(a []*decimal.Big) ([]*decimal.Big, int, error) {
+			sums := make([]*decimal.Big, len(a))
+			sum := big()
+			for ix := len(a) - 1; ix >= 0; ix-- {
+				sum = ctx.Add(big(), sum, a[ix])
+				sums[len(a)-1-ix] = sum
+			}
+			return sums, len(a), nil
+		}},
+		ophandler{"gamma", "Gamma function of x", 1, func(a []*decimal.Big) ([]*decimal.Big, int, error) {
+			z, err := gamma(ctx, a[0])
+			return []*decimal.Big{z}, 1, err
+		}},
+		ophandler{"isprime", "1 if x is a prime number, 0 otherwise", 1, func(a []*decimal.Big) ([]*decimal.Big, int, error) {
+			n, err := bigToInt(a[0])
+			if err != nil {
+				return nil, 1, err
+			}
+			if n.Sign() > 0 && n.ProbablyPrime(primeRounds) {
+				return []*decimal.Big{bigUint(1)}, 1, nil
+			}
+			return []*decimal.Big{bigUint(0)}, 1, nil
+		}},
+		ophandler{"nextprime", "Smallest prime number greater than x", 1, func(a []*decimal.Big) ([]*decimal.Big, int, error) {
+			n, err := bigToInt(a[0])
+			if err != nil {
+				return nil, 1, err
+			}
+			return []*decimal.Big{intToBig(nextPrime(n))}, 1, nil
+		}},
+		ophandler{"factor", "Replace x with its prime factors", 1, func(a []*decimal.Big) ([]*decimal.Big, int, error) {
+			n, err := bigToInt(a[0])
+			if err != nil {
+				return nil, 1, err
+			}
+			if n.Cmp(mathbig.NewInt(2)) < 0 {
+				return nil, 1, errors.New("factor requires an integer greater than 1")
+			}
+			ret := []*decimal.Big{}
+			for _, f := range factorize(n) {
+				ret = append(ret, intToBig(f))
+			}
+			return ret, 1, nil
+		}},
+		ophandler{"min", "Minimum of x and y", 2, func(a []*decimal.Big) ([]*decimal.Big, int, error) {
+			if a[1].Cmp(a[0]) < 0 {
+				return []*decimal.Big{a[1]}, 2, nil
+			}
+			return []*decimal.Big{a[0]}, 2, nil
+		}},
+		ophandler{"max", "Maximum of x and y", 2, func(a []*decimal.Big) ([]*decimal.Big, int, error) {
+			if a[1].Cmp(a[0]) > 0 {
+				return []*decimal.Big{a[1]}, 2, nil
+			}
+			return []*decimal.Big{a[0]}, 2, nil
+		}},
+		ophandler{"fac", "Calculate factorial of x (Γ(x+1) for non-integers)", 1, func(a []*decimal.Big) ([]*decimal.Big, int, error) {
+			if !a[0].IsInt() && !a[0].IsInf(0) && !a[0].IsNaN(0) {
+				z, err := gamma(ctx, ctx.Add(big(), a[0], bigUint(1)))
+				return []*decimal.Big{z}, 1, err
+			}
+			z := ctx.Floor(big(), a[0])
+			if z.Sign() < 0 {
+				return nil, 1, errors.New("factorial requires a positive number")
+			}
+			n, ok := z.Uint64()
+			if !ok {
+				return nil, 1, errFactorial
+			}
+			z, err := factorial(ctx, n)
+			return []*decimal.Big{z}, 1, err
+		}},
+		ophandler{"floor", "Largest integer less than or equal to x", 1, func(a []*decimal.Big) ([]*decimal.Big, int, error) {
+			return []*decimal.Big{ctx.Floor(big(), a[0])}, 1, nil
+		}},
+		ophandler{"ceil", "Smallest integer greater than or equal to x", 1, func(a []*decimal.Big) ([]*decimal.Big, int, error) {
+			return []*decimal.Big{ctx.Ceil(big(), a[0])}, 1, nil
+		}},
+		ophandler{"round", "Round x to the current number of decimals (see fmt)", 1, func(a []*decimal.Big) ([]*decimal.Big, int, error) {
+			z := big().Copy(a[0])
+			// Only quantize when there are extra decimals to remove, as
+			// quantizing large numbers may exceed the context precision.
+			if z.IsFinite() && z.Scale() > ret.decimals {
+				ctx.Quantize(z, ret.decimals)
+			}
+			return []*decimal.Big{z}, 1, nil
+		}},
+		ophandler{"trunc", "Integer part of x (round towards zero)", 1, func(a []*decimal.Big) ([]*decimal.Big, int, error) {
+			return []*decimal.Big{truncate(ctx, a[0])}, 1, nil
+		}},
+		ophandler{"frac", "Fractional part of x", 1, func(a []*decimal.Big) ([]*decimal.Big, int, error) {
+			if a[0].IsInt() {
+				return []*decimal.Big{bigUint(0)}, 1, nil
+			}
+			return []*decimal.Big{ctx.Sub(big(), a[0], truncate(ctx, a[0]))}, 1, nil
+		}},
+		ophandler{"mant", "Mantissa of x (x = mant × 10^xpon)", 1, func(a []*decimal.Big) ([]*decimal.Big, int, error) {
+			if !a[0].IsFinite() {
+				return nil, 1, errors.New("mantissa requires a finite number")
+			}
+			if a[0].Sign() == 0 {
+				return []*decimal.Big{bigUint(0)}, 1, nil
+			}
+			z := big().Copy(a[0])
+			return []*decimal.Big{z.SetScale(z.Precision() - 1)}, 1, nil
+		}},
+		ophandler{"xpon", "Decimal exponent of x (x = mant × 10^xpon)", 1, func(a []*decimal.Big) ([]*decimal.Big, int, error) {
+			if !a[0].IsFinite() {
+				return nil, 1, errors.New("exponent requires a finite number")
+			}
+			if a[0].Sign() == 0 {
+				return []*decimal.Big{bigUint(0)}, 1, nil
+			}
+			return []*decimal.Big{big().SetMantScale(int64(a[0].Precision()-a[0].Scale()-1), 0)}, 1, nil
+		}},
+		ophandler{"scale", "Number of fractional digits in x", 1, func(a []*decimal.Big) ([]*decimal.Big, int, error) {
+			if !a[0].IsFinite() {
+				return nil, 1, errors.New("scale requires a finite number")
+			}
+			return []*decimal.Big{bigUint(uint64(max(a[0].Scale(), 0)))}, 1, nil
+		}},
+		"",
+		"BOLD:Comparison Operations",
+		ophandler{"<", "1 if y < x, 0 otherwise", 2, func(a []*decimal.Big) ([]*decimal.Big, int, error) {
+			return []*decimal.Big{compare(a[1], a[0], func(c int) bool { return c < 0 })}, 2, nil
+		}},
+		ophandler{"<=", "1 if y <= x, 0 otherwise", 2, func(a []*decimal.Big) ([]*decimal.Big, int, error) {
+			return []*decimal.Big{compare(a[1], a[0], func(c int) bool { return c <= 0 })}, 2, nil
+		}},
+		ophandler{">", "1 if y > x, 0 otherwise", 2, func(a []*decimal.Big) ([]*decimal.Big, int, error) {
+			return []*decimal.Big{compare(a[1], a[0], func(c int) bool { return c > 0 })}, 2, nil
+		}},
+		ophandler{">=", "1 if y >= x, 0 otherwise", 2, func(a []*decimal.Big) ([]*decimal.Big, int, error) {
+			return []*decimal.Big{compare(a[1], a[0], func(c int) bool { return c >= 0 })}, 2, nil
+		}},
+		ophandler{"==", "1 if y == x, 0 otherwise", 2, func(a []*decimal.Big) ([]*decimal.Big, int, error) {
+			return []*decimal.Big{compare(a[1], a[0], func(c int) bool { return c == 0 })}, 2, nil
+		}},
+		ophandler{"!=", "1 if y != x, 0 otherwise", 2, func(a []*decimal.Big) ([]*decimal.Big, int, error) {
+			// NaN is different from everything, including itself.
+			if a[1].IsNaN(0) || a[0].IsNaN(0) {
+				return []*decimal.Big{bigUint(1)}, 2, nil
+			}
+			return []*decimal.Big{compare(a[1], a[0], func(c int) bool { return c != 0 })}, 2, nil
+		}},
+		ophandler{"pos", "1 if x > 0, 0 otherwise (E.g. filter pos)", 1, func(a []*decimal.Big) ([]*decimal.Big, int, error) {
+			return []*decimal.Big{compare(a[0], bigUint(0), func(c int) bool { return c > 0 })}, 1, nil
+		}},
+		ophandler{"neg", "1 if x < 0, 0 otherwise (E.g. filter neg)", 1, func(a []*decimal.Big) ([]*decimal.Big, int, error) {
+			return []*decimal.Big{compare(a[0], bigUint(0), func(c int) bool { return c < 0 })}, 1, nil
+		}},
+		ophandler{"nonzero", "1 if x != 0, 0 otherwise (E.g. filter nonzero)", 1, func(a []*decimal.Big) ([]*decimal.Big, int, error) {
+			if a[0].IsNaN(0) {
+				return []*decimal.Big{bigUint(1)}, 1, nil
+			}
+			return []*decimal.Big{compare(a[0], bigUint(0), func(c int) bool { return c != 0 })}, 1, nil
+		}},
+		cmdhandler{"ifte", "<then> <else>", "If x is not zero, run then, otherwise run else. Each is a macro, a [ block ] or a number (E.g. 5 3 > ifte [ 2 * ] 0)", func(args []string) (int, error) {
+			then, n, err := macroArg(args)
+			if err != nil {
+				return n, err
+			}
+			otherwise, m, err := macroArg(args[n:])
+			n += m
+			if err != nil {
+				return n, err
+			}
+			if len(stack.list) < 1 {
+				return n, errors.New("this operation requires at least 1 items in the stack")
+			}
+			body := otherwise
+			if stack.top().Sign() != 0 {
+				body = then
+			}
+			stack.list = stack.list[:len(stack.list)-1]
+			_, err = run(ret, body)
+			return n, err
+		}},
+
+		"",
+		"BOLD:Bitwise Operations",
+		cmdhandler{"wsize", "<bits>", "Set the word size for bitwise operations and non-decimal output (8, 16, 32, 64, 128)", func(args []string) (int, error) {
+			if len(args) < 1 {
+				return 0, errors.New("usage: wsize <bits>")
+			}
+			n, err := strconv.Atoi(args[0])
+			if err != nil || !validWordSize(n) {
+				return 1, fmt.Errorf("invalid word size: %q", args[0])
+			}
+			ret.wordSize = n
+			return 1, nil
+		}},
+		ophandler{"signed", "Bitwise operations and non-decimal output use signed (two's complement) numbers", 0, func(_ []*decimal.Big) ([]*decimal.Big, int, error) {
+			ret.signed = true
+			return nil, 0, nil
+		}},
+		ophandler{"unsigned", "Bitwise operations and non-decimal output use unsigned numbers (default)", 0, func(_ []*decimal.Big) ([]*decimal.Big, int, error) {
+			ret.signed = false
+			return nil, 0, nil
+		}},
+		ophandler{"and", "Logical AND between x and y", 2, func(a []*decimal.Big) ([]*decimal.Big, int, error) {
+			x := ret.toWord(a[0], ret.wordSize)
+			y := ret.toWord(a[1], ret.wordSize)
+			return []*decimal.Big{ret.fromWord(x.And(x, y))}, 2, nil
+		}},
+		ophandler{"or", "Logical OR between x and y", 2, func(a []*decimal.Big) ([]*decimal.Big, int, error) {
+			x := ret.toWord(a[0], ret.wordSize)
+			y := ret.toWord(a[1], ret.wordSize)
+			return []*decimal.Big{ret.fromWord(x.Or(x, y))}, 2, nil
+		}},
+		ophandler{"xor", "Logical XOR between x and y", 2, func(a []*decimal.Big) ([]*decimal.Big, int, error) {
+			x := ret.toWord(a[0], ret.wordSize)
+			y := ret.toWord(a[1], ret.wordSize)
+			return []*decimal.Big{ret.fromWord(x.Xor(y, x))}, 2, nil
+		}},
+		ophandler{"not", "Bitwise NOT (complement) of x", 1, func(a []*decimal.Big) ([]*decimal.Big, int, error) {
+			x := ret.toWord(a[0], ret.wordSize)
+			return []*decimal.Big{ret.fromWord(x.Xor(x, wordMask(ret.wordSize)))}, 1, nil
+		}},
+		ophandler{"lshift", "Shift y left x times", 2, func(a []*decimal.Big) ([]*decimal.Big, int, error) {
+			n, err := shiftCount(a[0])
+			if err != nil {
+				return nil, 2, err
+			}
+			y := ret.toWord(a[1], ret.wordSize)
+			// Shifting by the word size or more always results in zero.
+			y.Lsh(y, uint(min(n, uint64(ret.wordSize))))
+			return []*decimal.Big{ret.fromWord(y.And(y, wordMask(ret.wordSize)))}, 2, nil
+		}},
+		ophandler{"rshift", "Shift y right x times", 2, func(a []*decimal.Big) ([]*decimal.Big, int, error) {
+			n, err := shiftCount(a[0])
+			if err != nil {
+				return nil, 2, err
+			}
+			y := ret.toWord(a[1], ret.wordSize)
+			// Signed mode uses an arithmetic shift (preserving the sign).
+			if ret.signed {
+				y = signedWord(y, ret.wordSize)
+			}
+			y.Rsh(y, uint(min(n, uint64(ret.wordSize))))
+			return []*decimal.Big{ret.fromWord(y.And(y, wordMask(ret.wordSize)))}, 2, nil
+		}},
+		ophandler{"rol", "Rotate y left x bits within the word size", 2, func(a []*decimal.Big) ([]*decimal.Big, int, error) {
+			n, err := shiftCount(a[0])
+			if err != nil {
+				return nil, 2, err
+			}
+			y := ret.toWord(a[1], ret.wordSize)
+			return []*decimal.Big{ret.fromWord(rotate(y, n, ret.wordSize))}, 2, nil
+		}},
+		ophandler{"ror", "Rotate y right x bits within the word size", 2, func(a []*decimal.Big) ([]*decimal.Big, int, error) {
+			n, err := shiftCount(a[0])
+			if err != nil {
+				return nil, 2, err
+			}
+			y := ret.toWord(a[1], ret.wordSize)
+			return []*decimal.Big{ret.fromWord(rotate(y, uint64(ret.wordSize)-n%uint64(ret.wordSize), ret.wordSize))}, 2, nil
+		}},
+		ophandler{"popcnt", "Number of bits set in x", 1, func(a []*decimal.Big) ([]*decimal.Big, int, error) {
+			x := ret.toWord(a[0], ret.wordSize)
+			return []*decimal.Big{bigUint(uint64(popCount(x)))}, 1, nil
+		}},
+		ophandler{"bset", "Set bit x of y", 2, func(a []*decimal.Big) ([]*decimal.Big, int, error) {
+			n, err := bitIndex(a[0], ret.wordSize)
+			if err != nil {
+				return nil, 2, err
+			}
+			y := ret.toWord(a[1], ret.wordSize)
+			return []*decimal.Big{ret.fromWord(y.SetBit(y, n, 1))}, 2, nil
+		}},
+		ophandler{"bclr", "Clear bit x of y", 2, func(a []*decimal.Big) ([]*decimal.Big, int, error) {
+			n, err := bitIndex(a[0], ret.wordSize)
+			if err != nil {
+				return nil, 2, err
+			}
+			y := ret.toWord(a[1], ret.wordSize)
+			return []*decimal.Big{ret.fromWord(y.SetBit(y, n, 0))}, 2, nil
+		}},
+		ophandler{"btest", "1 if bit x of y is set, 0 otherwise", 2, func(a []*decimal.Big) ([]*decimal.Big, int, error) {
+			n, err := bitIndex(a[0], ret.wordSize)
+			if err != nil {
+				return nil, 2, err
+			}
+			y := ret.toWord(a[1], ret.wordSize)
+			return []*decimal.Big{bigUint(uint64(y.Bit(n)))}, 2, nil
+		}},
+		ophandler{"chr", "Display the character with code point x and its UTF-8 bytes ('a' pushes the code point of a)", 1, func(a []*decimal.Big) ([]*decimal.Big, int, error) {
+			n, ok := a[0].Int64()
+			if !ok || !a[0].IsInt() || !utf8.ValidRune(rune(n)) || int64(rune(n)) != n {
+				return nil, 0, errors.New("not a valid Unicode code point")
+			}
+			r := rune(n)
+			fmt.Fprintf(ret.out, "%s U+%04X UTF-8: % x\n", strconv.QuoteRune(r), r, []byte(string(r)))
+			return nil, 0, nil
+		}},
+		ophandler{"mask", "Number with the lowest x bits set", 1, func(a []*decimal.Big) ([]*decimal.Big, int, error) {
+			n, ok := a[0].Uint64()
+			if !ok || !a[0].IsInt() || n > uint64(ret.wordSize) {
+				return nil, 1, fmt.Errorf("number of bits must be an integer between 0 and %d", ret.wordSize)
+			}
+			return []*decimal.Big{ret.fromWord(wordMask(int(n)))}, 1, nil
+		}},
+		ophandler{"maskr", "Number with bits y to x set", 2, func(a []*decimal.Big) ([]*decimal.Big, int, error) {
+			lo, err := bitIndex(a[1], ret.wordSize)
+			if err != nil {
+				return nil, 2, err
+			}
+			hi, err := bitIndex(a[0], ret.wordSize)
+			if err != nil {
+				return nil, 2, err
+			}
+			if lo > hi {
+				lo, hi = hi, lo
+			}
+			m := wordMask(hi - lo + 1)
+			return []*decimal.Big{ret.fromWord(m.Lsh(m, uint(lo)))}, 2, nil
+		}},
+		ophandler{"bswap16", "Reverse the byte order of x (16 bits)", 1, func(a []*decimal.Big) ([]*decimal.Big, int, error) {
+			x := byteSwap(ret.toWord(a[0], 16), 16)
+			if ret.signed {
+				x = signedWord(x, 16)
+			}
+			return []*decimal.Big{intToBig(x)}, 1, nil
+		}},
+		ophandler{"bswap32", "Reverse the byte order of x (32 bits)", 1, func(a []*decimal.Big) ([]*decimal.Big, int, error) {
+			x := byteSwap(ret.toWord(a[0], 32), 32)
+			if ret.signed {
+				x = signedWord(x, 32)
+			}
+			return []*decimal.Big{intToBig(x)}, 1, nil
+		}},
+		ophandler{"bswap64", "Reverse the byte order of x (64 bits)", 1, func(a []*decimal.Big) ([]*decimal.Big, int, error) {
+			x := byteSwap(ret.toWord(a[0], 64), 64)
+			if ret.signed {
+				x = signedWord(x, 64)
+			}
+			return []*decimal.Big{intToBig(x)}, 1, nil
+		}},
+		ophandler{"f32bits", "Display the IEEE-754 layout of x as a 32-bit float and replace x with its bits", 1, func(a []*decimal.Big) ([]*decimal.Big, int, error) {
+			b := floatBits(a[0], float32Layout)
+			printFloatBits(ret.out, b, float32Layout)
+			return []*decimal.Big{bigUint(b)}, 1, nil
+		}},
+		ophandler{"bits2f32", "Convert the IEEE-754 32-bit float bits in x to a number", 1, func(a []*decimal.Big) ([]*decimal.Big, int, error) {
+			b := ret.toWord(a[0], 32).Uint64()
+			return []*decimal.Big{bitsFloat(b, float32Layout)}, 1, nil
+		}},
+		ophandler{"f64bits", "Display the IEEE-754 layout of x as a 64-bit float and replace x with its bits", 1, func(a []*decimal.Big) ([]*decimal.Big, int, error) {
+			b := floatBits(a[0], float64Layout)
+			printFloatBits(ret.out, b, float64Layout)
+			return []*decimal.Big{bigUint(b)}, 1, nil
+		}},
+		ophandler{"bits2f64", "Convert the IEEE-754 64-bit float bits in x to a number", 1, func(a []*decimal.Big) ([]*decimal.Big, int, error) {
+			b := ret.toWord(a[0], 64).Uint64()
+			return []*decimal.Big{bitsFloat(b, float64Layout)}, 1, nil
+		}},
+		"",
+		"BOLD:Trigonometric and Log Operations",
+		ophandler{"sin", "Sine of x", 1, func(a []*decimal.Big) ([]*decimal.Big, int, error) {
+			z := ctx.Sin(big(), radOrDeg(ctx, a[0], ret.degmode))
+			return []*decimal.Big{z}, 1, nil
+		}},
+		ophandler{"cos", "Cosine of x", 1, func(a []*decimal.Big) ([]*decimal.Big, int, error) {
+			z := ctx.Cos(big(), radOrDeg(ctx, a[0], ret.degmode))
+			return []*decimal.Big{z}, 1, nil
+		}},
+		ophandler{"tan", "Tangent of x", 1, func(a []*decimal.Big) ([]*decimal.Big, int, error) {
+			z := ctx.Tan(big(), radOrDeg(ctx, a[0], ret.degmode))
+			return []*decimal.Big{z}, 1, nil
+		}},
+		ophandler{"asin", "Arcsine of x", 1, func(a []*decimal.Big) ([]*decimal.Big, int, error) {
+			z := ctx.Asin(big(), radOrDeg(ctx, a[0], ret.degmode))
+			return []*decimal.Big{z}, 1, nil
+		}},
+		ophandler{"acos", "Arccosine of x", 1, func(a []*decimal.Big) ([]*decimal.Big, int, error) {
+			z := ctx.Acos(big(), radOrDeg(ctx, a[0], ret.degmode))
+			return []*decimal.Big{z}, 1, nil
+		}},
+		ophandler{"atan", "Arctangent of x", 1, func(a []*decimal.Big) ([]*decimal.Big, int, error) {
+			z := ctx.Atan(big(), radOrDeg(ctx, a[0], ret.degmode))
+			return []*decimal.Big{z}, 1, nil
+		}},
+		ophandler{"exp", "Calculate e ^ x", 1, func(a []*decimal.Big) ([]*decimal.Big, int, error) {
+			z := ctx.Exp(big(), a[0])
+			return []*decimal.Big{z}, 1, nil
+		}},
+		ophandler{"ln", "Natural logarithm of x", 1, func(a []*decimal.Big) ([]*decimal.Big, int, error) {
+			z := ctx.Log(big(), a[0])
+			return []*decimal.Big{z}, 1, nil
+		}},
+		ophandler{"log", "Common logarithm of x", 1, func(a []*decimal.Big) ([]*decimal.Big, int, error) {
+			z := ctx.Log10(big(), a[0])
+			return []*decimal.Big{z}, 1, nil
+		}},
+
+		"",
+		"BOLD:Miscellaneous Operations",
+		cmdhandler{"env", "<name>", "Push the numeric value of environment variable name", func(args []string) (int, error) {
+			if len(args) < 1 {
+				return 0, errors.New("usage: env <name>")
+			}
+			v, ok := os.LookupEnv(args[0])
+			if !ok {
+				return 1, fmt.Errorf("environment variable %q is not set", args[0])
+			}
+			n, err := atof(strings.TrimSpace(v))
+			if err != nil {
+				return 1, fmt.Errorf("environment variable %q is not a number: %q", args[0], v)
+			}
+			stack.push(n)
+			return 1, nil
+		}},
+		cmdhandler{"conv", "<from> <to>", "Convert x between units (E.g. 10 conv mile km)", func(args []string) (int, error) {
+			if len(args) < 2 {
+				return 0, errors.New("usage: conv <from> <to>")
+			}
+			if len(stack.list) < 1 {
+				return 2, errors.New("this operation requires at least 1 items in the stack")
+			}
+			if ret.units == nil {
+				db, err := loadUnits(ctx)
+				if err != nil {
+					return 2, err
+				}
+				ret.units = db
+			}
+			z, err := ret.units.convert(stack.top(), args[0], args[1])
+			if err != nil {
+				return 2, err
+			}
+			stack.list[len(stack.list)-1] = z
+			return 2, nil
+		}},
+		ophandler{"f2c", "Convert x in Fahrenheit to Celsius", 1, func(a []*decimal.Big) ([]*decimal.Big, int, error) {
+			z := big()
+			ctx.Sub(z, a[0], bigUint(32))
+			ctx.Mul(z, z, bigUint(5))
+			ctx.Quo(z, z, bigUint(9))
+			return []*decimal.Big{z}, 1, nil
+		}},
+		ophandler{"c2f", "Convert x in Celsius to Fahrenheit", 1, func(a []*decimal.Big) ([]*decimal.Big, int, error) {
+			z := big()
+			ctx.Mul(z, a[0], bigUint(9))
+			ctx.Quo(z, z, bigUint(5))
+			ctx.Add(z, z, bigUint(32))
+			return []*decimal.Big{z}, 1, nil
+		}},
+
+		"",
+		"BOLD:Stack Operations",
+		cmdhandler{"p", "[all]", "Display stack (all entries with \"all\")", func(args []string) (int, error) {
+			if len(args) > 0 && args[0] == "all" {
+				stack.print(ret.out, ctx, ret.displayType, true)
+				return 1, nil
+			}
+			stack.print(ret.out, ctx, ret.displayType, false)
+			return 0, nil
+		}},
+		ophandler{"pwin", "Limit stack display to the top x entries (0 = all)", 1, func(a []*decimal.Big) ([]*decimal.Big, int, error) {
+			x, ok := a[0].Uint64()
+			if !ok || !a[0].IsInt() {
+				return nil, 1, errors.New("number of entries must be a positive integer")
+			}
+			ret.window = int(x)
+			return nil, 1, nil
+		}},
+		ophandler{"pbase", "Display stack with a second column in base x (0 = none)", 1, func(a []*decimal.Big) ([]*decimal.Big, int, error) {
+			x, ok := a[0].Uint64()
+			if !ok || !a[0].IsInt() || (x != 0 && !validBase(int(x))) {
+				return nil, 1, fmt.Errorf("base must be 0 or between %d and %d", minBase, maxBase)
+			}
+			ret.altBase = int(x)
+			return nil, 1, nil
+		}},
+		ophandler{"bases", "Display x in decimal, hexadecimal, octal and binary", 1, func(a []*decimal.Big) ([]*decimal.Big, int, error) {
+			printBases(ret.out, ctx, ret.displayType, a[0])
+			return nil, 0, nil
+		}},
+		ophandler{"pbases", "Toggle displaying results in all bases (see bases)", 0, func(_ []*decimal.Big) ([]*decimal.Big, int, error) {
+			ret.showBases = !ret.showBases
+			return nil, 0, nil
+		}},
+		ophandler{"pgroup", "Group digits of non-decimal numbers in groups of x (0 = none)", 1, func(a []*decimal.Big) ([]*decimal.Big, int, error) {
+			x, ok := a[0].Uint64()
+			if !ok || !a[0].IsInt() {
+				return nil, 1, errors.New("group size must be a positive integer")
+			}
+			ret.group = int(x)
+			return nil, 1, nil
+		}},
+		ophandler{"pfrac", "Display results as fractions with denominators up to x (0 = none)", 1, func(a []*decimal.Big) ([]*decimal.Big, int, error) {
+			x, ok := a[0].Uint64()
+			if !ok || !a[0].IsInt() || x > 1<<31 {
+				return nil, 1, errors.New("maximum denominator must be a positive integer")
+			}
+			ret.fracDen = int(x)
+			return nil, 1, nil
+		}},
+		ophandler{"pflip", "Toggle stack display order (x on top or bottom)", 0, func(_ []*decimal.Big) ([]*decimal.Big, int, error) {
+			ret.bottomUp = !ret.bottomUp
+			return nil, 0, nil
+		}},
+		cmdhandler{"label", "<text>", "Attach a label to x, shown by p (\"-\" removes it)", func(args []string) (int, error) {
+			if len(args) < 1 {
+				return 0, errors.New("usage: label <text>")
+			}
+			if len(stack.list) < 1 {
+				return 1, errors.New("this operation requires at least 1 items in the stack")
+			}
+			label := args[0]
+			if label == "-" {
+				label = ""
+			}
+			stack.setLabel(label)
+			return 1, nil
+		}},
+		ophandler{"c", "Clear stack", 0, func(_ []*decimal.Big) ([]*decimal.Big, int, error) {
+			stack.clear()
+			return nil, 0, nil
+		}},
+		cmdhandler{"ws", "<create|switch|delete> <name>", "Create, switch to, or delete a named stack workspace", func(args []string) (int, error) {
+			if len(args) < 2 {
+				return len(args), errors.New("usage: ws <create|switch|delete> <name>")
+			}
+			switch args[0] {
+			case "create":
+				return 2, ret.switchWorkspace(args[1], true)
+			case "switch":
+				return 2, ret.switchWorkspace(args[1], false)
+			case "delete":
+				return 2, ret.deleteWorkspace(args[1])
+			}
+			return 1, fmt.Errorf("invalid workspace action: %q", args[0])
+		}},
+		cmdhandler{"wslist", "", "List stack workspaces (* = current)", func(_ []string) (int, error) {
+			for _, name := range ret.workspaceNames() {
+				mark := " "
+				if name == ret.workspace {
+					mark = "*"
+				}
+				fmt.Fprintf(ret.out, "%s %s\n", mark, name)
+			}
+			return 0, nil
+		}},
+		cmdhandler{"undo", "", "Undo the changes made by the previous line", func(_ []string) (int, error) {
+			if len(ret.undoList) == 0 {
+				return 0, errors.New("nothing to undo")
+			}
+			ret.redoList = append(ret.redoList, ret.state())
+			ret.setState(ret.undoList[len(ret.undoList)-1])
+			ret.undoList = ret.undoList[:len(ret.undoList)-1]
+			return 0, nil
+		}},
+		cmdhandler{"redo", "", "Redo the changes reverted by undo", func(_ []string) (int, error) {
+			if len(ret.redoList) == 0 {
+				return 0, errors.New("nothing to redo")
+			}
+			ret.undoList = append(ret.undoList, ret.state())
+			ret.setState(ret.redoList[len(ret.redoList)-1])
+			ret.redoList = ret.redoList[:len(ret.redoList)-1]
+			return 0, nil
+		}},
+		ophandler{"=", "Print top of stack (x)", 0, func(_ []*decimal.Big) ([]*decimal.Big, int, error) {
+			stack.printTop(ret.out, ctx, ret.displayType)
+			return nil, 0, nil
+		}},
+		ophandler{"full", "Print top of stack (x) with all stored digits", 0, func(_ []*decimal.Big) ([]*decimal.Big, int, error) {
+			stack.printFull(ret.out, ret.displayType)
+			return nil, 0, nil
+		}},
+		ophandler{"=!", "Same as full", 0, func(_ []*decimal.Big) ([]*decimal.Big, int, error) {
+			stack.printFull(ret.out, ret.displayType)
+			return nil, 0, nil
+		}},
+		ophandler{"copy", "Copy x to the system clipboard", 1, func(a []*decimal.Big) ([]*decimal.Big, int, error) {
+			d := ret.displayType
+			d.plain, d.pretty = true, false
+			if ret.clipboard == nil {
+				return nil, 0, errNoClipboard
+			}
+			return nil, 0, ret.clipboard.Copy(d.format(ctx, a[0]))
+		}},
+		ophandler{"paste", "Push all numbers in the system clipboard", 0, func(_ []*decimal.Big) ([]*decimal.Big, int, error) {
+			if ret.clipboard == nil {
+				return nil, 0, errNoClipboard
+			}
+			s, err := ret.clipboard.Paste()
+			if err != nil {
+				return nil, 0, err
+			}
+			nums := extractNumbers(s, ret.ibase)
+			if len(nums) == 0 {
+				return nil, 0, errors.New("no numbers in the clipboard")
+			}
+			return nums, 0, nil
+		}},
+		ophandler{"d", "Drop top of stack (x)", 1, func(_ []*decimal.Big) ([]*decimal.Big, int, error) {
+			return nil, 1, nil
+		}},
+		ophandler{"dropn", "Drop the top x items", 1, func(a []*decimal.Big) ([]*decimal.Big, int, error) {
+			n, err := stackCount(a)
+			if err != nil {
+				return nil, 1, err
+			}
+			return nil, n + 1, nil
+		}},
+		ophandler{"dup", "Duplicate top of stack", 1, func(a []*decimal.Big) ([]*decimal.Big, int, error) {
+			stack.push(big().Copy(a[0]))
+			return nil, 0, nil
+		}},
+		ophandler{"dup2", "Duplicate x and y", 2, func(a []*decimal.Big) ([]*decimal.Big, int, error) {
+			return []*decimal.Big{a[1], a[0]}, 0, nil
+		}},
+		ophandler{"dupn", "Duplicate the top x items", 1, func(a []*decimal.Big) ([]*decimal.Big, int, error) {
+			n, err := stackCount(a)
+			if err != nil {
+				return nil, 1, err
+			}
+			ret := []*decimal.Big{}
+			for ix := n; ix >= 1; ix-- {
+				ret = append(ret, a[ix])
+			}
+			return ret, 1, nil
+		}},
+		ophandler{"x", "Exchange x and y", 2, func(a []*decimal.Big) ([]*decimal.Big, int, error) {
+			return []*decimal.Big{a[0], a[1]}, 2, nil
+		}},
+		ophandler{"xchgn", "Exchange the top of the stack with item x (2 = same as x)", 1, func(a []*decimal.Big) ([]*decimal.Big, int, error) {
+			n, err := stackCount(a)
+			if err != nil {
+				return nil, 1, err
+			}
+			ret := []*decimal.Big{}
+			for ix := n; ix >= 1; ix-- {
+				ret = append(ret, a[ix])
+			}
+			ret[0], ret[n-1] = ret[n-1], ret[0]
+			return ret, n + 1, nil
+		}},
+		ophandler{"over", "Copy y to the top of the stack", 2, func(a []*decimal.Big) ([]*decimal.Big, int, error) {
+			return []*decimal.Big{a[1]}, 0, nil
+		}},
+		ophandler{"pick", "Copy item x (1 = the item below x) to the top of the stack", 1, func(a []*decimal.Big) ([]*decimal.Big, int, error) {
+			n, err := stackCount(a)
+			if err != nil {
+				return nil, 1, err
+			}
+			return []*decimal.Big{a[n]}, 1, nil
+		}},
+		ophandler{"rev", "Reverse the order of all items in the stack", 0, func(a []*decimal.Big) ([]*decimal.Big, int, error) {
+			return a, len(a), nil
+		}},
+		ophandler{"sort", "Sort the stack in ascending order (largest on top)", 0, func(a []*decimal.Big) ([]*decimal.Big, int, error) {
+			ret := slices.Clone(a)
+			slices.SortStableFunc(ret, func(x, y *decimal.Big) int { return x.CmpTotal(y) })
+			return ret, len(a), nil
+		}},
+		ophandler{"rsort", "Sort the stack in descending order (smallest on top)", 0, func(a []*decimal.Big) ([]*decimal.Big, int, error) {
+			ret := slices.Clone(a)
+			slices.SortStableFunc(ret, func(x, y *decimal.Big) int { return y.CmpTotal(x) })
+			return ret, len(a), nil
+		}},
+		ophandler{"depth", "Push the number of items in the stack", 0, func(a []*decimal.Big) ([]*decimal.Big, int, error) {
+			return []*decimal.Big{bigUint(uint64(len(a)))}, 0, nil
+		}},
+		ophandler{"roll", "Roll the top x items up (item x moves to the top)", 1, func(a []*decimal.Big) ([]*decimal.Big, int, error) {
+			n, err := stackCount(a)
+			if err != nil {
+				return nil, 1, err
+			}
+			ret := []*decimal.Big{}
+			for ix := n - 1; ix >= 1; ix-- {
+				ret = append(ret, a[ix])
+			}
+			return append(ret, a[n]), n + 1, nil
+		}},
+		ophandler{"rolld", "Roll the top x items down (the top moves to item x)", 1, func(a []*decimal.Big) ([]*decimal.Big, int, error) {
+			n, err := stackCount(a)
+			if err != nil {
+				return nil, 1, err
+			}
+			ret := []*decimal.Big{a[1]}
+			for ix := n; ix >= 2; ix-- {
+				ret = append(ret, a[ix])
+			}
+			return ret, n + 1, nil
+		}},
+
+		"",
+		"BOLD:Registers",
+		cmdhandler{"sto", "<reg>", "Store x in register reg (any name)", func(args []string) (int, error) {
+			return ret.store(args, nil)
+		}},
+		cmdhandler{"sto+", "<reg>", "Add x to register reg", func(args []string) (int, error) {
+			return ret.store(args, func(z, r, x *decimal.Big) *decimal.Big { return ctx.Add(z, r, x) })
+		}},
+		cmdhandler{"sto-", "<reg>", "Subtract x from register reg", func(args []string) (int, error) {
+			return ret.store(args, func(z, r, x *decimal.Big) *decimal.Big { return ctx.Sub(z, r, x) })
+		}},
+		cmdhandler{"sto*", "<reg>", "Multiply register reg by x", func(args []string) (int, error) {
+			return ret.store(args, func(z, r, x *decimal.Big) *decimal.Big { return ctx.Mul(z, r, x) })
+		}},
+		cmdhandler{"sto/", "<reg>", "Divide register reg by x", func(args []string) (int, error) {
+			return ret.store(args, func(z, r, x *decimal.Big) *decimal.Big { return ctx.Quo(z, r, x) })
+		}},
+		ophandler{"vars", "Display all registers and their values", 0, func(_ []*decimal.Big) ([]*decimal.Big, int, error) {
+			names := []string{}
+			for name := range ret.registers {
+				names = append(names, name)
+			}
+			sort.Strings(names)
+			for _, name := range names {
+				fmt.Fprintf(ret.out, "%s: %s\n", bold(name), ret.format(ctx, ret.registers[name]))
+			}
+			return nil, 0, nil
+		}},
+		ophandler{"save", "Save all registers to disk (restored on startup)", 0, func(_ []*decimal.Big) ([]*decimal.Big, int, error) {
+			return nil, 0, saveVars(ret.registers)
+		}},
+		ophandler{"purge", "Remove the registers saved on disk", 0, func(_ []*decimal.Big) ([]*decimal.Big, int, error) {
+			return nil, 0, purgeVars()
+		}},
+		cmdhandler{"rcl", "<reg>", "Push the contents of register reg", func(args []string) (int, error) {
+			if len(args) < 1 {
+				return 0, errors.New("usage: rcl <reg>")
+			}
+			v, ok := ret.registers[args[0]]
+			if !ok {
+				return 1, fmt.Errorf("register %q is empty", args[0])
+			}
+			stack.push(big().Copy(v))
+			return 1, nil
+		}},
+
+		"",
+		"BOLD:Macros",
+		cmdhandler{"def", "<name> <macro>", "Define a macro (E.g. def grow [ 1.05 * ])", func(args []string) (int, error) {
+			if len(args) < 1 {
+				return 0, errors.New("usage: def <name> <macro>")
+			}
+			body, n, err := macroArg(args[1:])
+			if err != nil {
+				return n + 1, err
+			}
+			return n + 1, ret.defineMacro(args[0], body)
+		}},
+		cmdhandler{"map", "<macro>", "Replace every item in the stack with the result of macro (E.g. map sqr)", func(args []string) (int, error) {
+			body, n, err := macroArg(args)
+			if err != nil {
+				return n, err
+			}
+			list := []*decimal.Big{}
+			for _, v := range stack.list {
+				z, err := ret.apply1(body, v)
+				if err != nil {
+					return n, err
+				}
+				list = append(list, z)
+			}
+			stack.list = list
+			return n, nil
+		}},
+		cmdhandler{"fold", "<macro>", "Reduce the stack to one item using a two argument macro (E.g. fold *)", func(args []string) (int, error) {
+			body, n, err := macroArg(args)
+			if err != nil {
+				return n, err
+			}
+			if len(stack.list) < 1 {
+				return n, errors.New("this operation requires at least 1 items in the stack")
+			}
+			// Fold from the bottom: ((s0 op s1) op s2) op ...
+			z := stack.list[0]
+			for _, v := range stack.list[1:] {
+				if z, err = ret.apply1(body, z, v); err != nil {
+					return n, err
+				}
+			}
+			stack.list = []*decimal.Big{z}
+			return n, nil
+		}},
+		cmdhandler{"filter", "<macro>", "Keep only the items for which macro is not zero (E.g. filter pos, filter nonzero, filter [ 0 > ]). With a comparison (E.g. 5 filter >), compare against x", func(args []string) (int, error) {
+			body, n, err := macroArg(args)
+			if err != nil {
+				return n, err
+			}
+			// Comparisons and other two argument operations use x
+			// as the second argument.
+			extra := []*decimal.Big{}
+			if handler, ok := ret.opmap()[strings.Join(body, " ")]; ok && handler.numArgs == 2 {
+				if len(stack.list) < 1 {
+					return n, errors.New("this operation requires at least 1 items in the stack")
+				}
+				extra = append(extra, stack.top())
+				stack.list = stack.list[:len(stack.list)-1]
+			}
+			list := []*decimal.Big{}
+			for _, v := range stack.list {
+				keep, err := ret.apply1(body, append([]*decimal.Big{v}, extra...)...)
+				if err != nil {
+					return n, err
+				}
+				if keep.Sign() != 0 {
+					list = append(list, v)
+				}
+			}
+			stack.list = list
+			return n, nil
+		}},
+		cmdhandler{"savedefs", "<file>", "Save all macros and registers to file", func(args []string) (int, error) {
+			if len(args) < 1 {
+				return 0, errors.New("usage: savedefs <file>")
+			}
+			return 1, ret.saveDefs(args[0])
+		}},
+		cmdhandler{"loaddefs", "<file>", "Load macros and registers from file", func(args []string) (int, error) {
+			if len(args) < 1 {
+				return 0, errors.New("usage: loaddefs <file>")
+			}
+			return 1, ret.loadDefs(args[0])
+		}},
+		cmdhandler{"solve", "<macro>", "Find a root of a one argument macro between y and x (E.g. 0 5 solve [ dup * 2 - ])", func(args []string) (int, error) {
+			body, n, err := macroArg(args)
+			if err != nil {
+				return n, err
+			}
+			if len(stack.list) < 2 {
+				return n, errors.New("this operation requires at least 2 items in the stack")
+			}
+			lo, hi := stack.list[len(stack.list)-2], stack.list[len(stack.list)-1]
+			z, err := ret.solve(body, lo, hi)
+			if err != nil {
+				return n, err
+			}
+			stack.list = append(stack.list[:len(stack.list)-2], z)
+			return n, nil
+		}},
+		cmdhandler{"deriv", "<macro>", "Numeric derivative of a one argument macro at x (E.g. 2 deriv [ dup * ])", func(args []string) (int, error) {
+			body, n, err := macroArg(args)
+			if err != nil {
+				return n, err
+			}
+			if len(stack.list) < 1 {
+				return n, errors.New("this operation requires at least 1 items in the stack")
+			}
+			// Evaluate the macro with twice the precision, so the
+			// difference keeps all significant digits. Operations use
+			// ctx directly, so it's restored even if the macro fails.
+			saved := ctx
+			c := ctx
+			c.Precision *= 2
+			ctx, ret.ctx = c, c
+			defer func() { ctx, ret.ctx = saved, saved }()
+			z, err := ret.deriv(c, body, stack.top())
+			if err != nil {
+				return n, err
+			}
+			stack.list[len(stack.list)-1] = saved.Round(z)
+			return n, nil
+		}},
+		cmdhandler{"sigma", "<macro>", "Sum a one argument macro for k from y to x (E.g. 1 10 sigma [ dup * ])", func(args []string) (int, error) {
+			body, n, err := macroArg(args)
+			if err != nil {
+				return n, err
+			}
+			if len(stack.list) < 2 {
+				return n, errors.New("this operation requires at least 2 items in the stack")
+			}
+			from, to := stack.list[len(stack.list)-2], stack.list[len(stack.list)-1]
+			if !from.IsInt() || !to.IsInt() {
+				return n, errors.New("summation bounds must be integers")
+			}
+			sum := big()
+			for k := big().Copy(from); k.Cmp(to) <= 0; k = ctx.Add(big(), k, bigUint(1)) {
+				z, err := ret.apply1(body, k)
+				if err != nil {
+					return n, err
+				}
+				ctx.Add(sum, sum, z)
+			}
+			stack.list = append(stack.list[:len(stack.list)-2], sum)
+			return n, nil
+		}},
+		cmdhandler{"times", "<macro>", "Run macro (a name or a [ block ]) x times", func(args []string) (int, error) {
+			body, n, err := macroArg(args)
+			if err != nil {
+				return n, err
+			}
+			if len(stack.list) < 1 {
+				return n, errors.New("this operation requires at least 1 items in the stack")
+			}
+			x := stack.top()
+			count, ok := x.Uint64()
+			if !ok || !x.IsInt() {
+				return n, errors.New("number of repetitions must be a positive integer")
+			}
+			stack.list = stack.list[:len(stack.list)-1]
+			for i := uint64(0); i < count; i++ {
+				if _, err := run(ret, body); err != nil {
+					return n, err
+				}
+			}
+			return n, nil
+		}},
+
+		"",
+		"BOLD:Math and Physical constants",
+		ophandler{"PI", "The famous transcedental number", 0, func(_ []*decimal.Big) ([]*decimal.Big, int, error) {
+			return []*decimal.Big{ctx.Pi(big())}, 0, nil
+		}},
+		ophandler{"E", "Another famous transcedental number", 0, func(_ []*decimal.Big) ([]*decimal.Big, int, error) {
+			return []*decimal.Big{ctx.E(big())}, 0, nil
+		}},
+		ophandler{"C", "Speed of light in vacuum, in m/s", 0, func(_ []*decimal.Big) ([]*decimal.Big, int, error) {
+			return []*decimal.Big{bigFloat("299792458")}, 0, nil
+		}},
+		ophandler{"MOL", "Avogadro's number", 0, func(_ []*decimal.Big) ([]*decimal.Big, int, error) {
+			return []*decimal.Big{bigFloat("6.02214154e23")}, 0, nil
+		}},
+
+		"",
+		"BOLD:Computer constants",
+		ophandler{"KB", "Kilobyte", 0, func(_ []*decimal.Big) ([]*decimal.Big, int, error) {
+			return []*decimal.Big{ctx.Pow(big(), bigUint(10), bigUint(3))}, 0, nil
+		}},
+		ophandler{"MB", "Megabyte", 0, func(_ []*decimal.Big) ([]*decimal.Big, int, error) {
+			return []*decimal.Big{ctx.Pow(big(), bigUint(10), bigUint(6))}, 0, nil
+		}},
+		ophandler{"GB", "Gigabyte", 0, func(_ []*decimal.Big) ([]*decimal.Big, int, error) {
+			return []*decimal.Big{ctx.Pow(big(), bigUint(10), bigUint(9))}, 0, nil
+		}},
+		ophandler{"MB", "Terabyte", 0, func(_ []*decimal.Big) ([]*decimal.Big, int, error) {
+			return []*decimal.Big{ctx.Pow(big(), bigUint(10), bigUint(12))}, 0, nil
+		}},
+		ophandler{"KIB", "Kibibyte", 0, func(_ []*decimal.Big) ([]*decimal.Big, int, error) {
+			return []*decimal.Big{ctx.Pow(big(), bigUint(2), bigUint(10))}, 0, nil
+		}},
+		ophandler{"MIB", "Mebibyte", 0, func(_ []*decimal.Big) ([]*decimal.Big, int, error) {
+			return []*decimal.Big{ctx.Pow(big(), bigUint(2), bigUint(20))}, 0, nil
+		}},
+		ophandler{"GIB", "Gibibyte", 0, func(_ []*decimal.Big) ([]*decimal.Big, int, error) {
+			return []*decimal.Big{ctx.Pow(big(), bigUint(2), bigUint(30))}, 0, nil
+		}},
+		ophandler{"TIB", "Tebibyte", 0, func(_ []*decimal.Big) ([]*decimal.Big, int, error) {
+			return []*decimal.Big{ctx.Pow(big(), bigUint(2), bigUint(40))}, 0, nil
+		}},
+
+		"",
+		"BOLD:Program Control",
+		cmdhandler{"set", "<option> <value>", "Set an option (maxdepth: maximum stack items, 0 = unlimited; onerror: stop or continue scripts on errors)", func(args []string) (int, error) {
+			if len(args) < 2 {
+				return len(args), errors.New("usage: set <option> <value>")
+			}
+			return 2, ret.setOption(args[0], args[1])
+		}},
+		cmdhandler{"rounding", "<mode>", "Set the rounding mode (half-even, half-up, half-down, down, up, ceiling, floor)", func(args []string) (int, error) {
+			if len(args) < 1 {
+				return 0, errors.New("usage: rounding <mode>")
+			}
+			mode, ok := roundingModes[args[0]]
+			if !ok {
+				return 1, fmt.Errorf("invalid rounding mode: %q", args[0])
+			}
+			// Operations use ctx directly, so update both copies.
+			ctx.RoundingMode = mode
+			ret.ctx = ctx
+			return 1, nil
+		}},
+		cmdhandler{"prec", "[digits]", "Set the working precision in significant digits (show the current value without digits)", func(args []string) (int, error) {
+			if len(args) < 1 || !isInteger(args[0]) {
+				fmt.Fprintf(ret.out, "Precision: %d digits\n", ctx.Precision)
+				return 0, nil
+			}
+			n, err := strconv.Atoi(args[0])
+			if err != nil || n < 1 || n > maxPrecision {
+				return 1, fmt.Errorf("precision must be between 1 and %d digits", maxPrecision)
+			}
+			// Operations use ctx directly, so update both copies.
+			ctx.Precision = n
+			ret.ctx = ctx
+			return 1, nil
+		}},
+		cmdhandler{"obase", "<base>", "Output in any base from 2 to 36", func(args []string) (int, error) {
+			if len(args) < 1 {
+				return 0, errors.New("usage: obase <base>")
+			}
+			base, err := parseBase(args[0])
+			if err != nil {
+				return 1, err
+			}
+			ret.base = base
+			ret.degmode = false
+			return 1, nil
+		}},
+		cmdhandler{"ibase", "<base>", "Read numbers without a prefix in any base from 2 to 36", func(args []string) (int, error) {
+			if len(args) < 1 {
+				return 0, errors.New("usage: ibase <base>")
+			}
+			base, err := parseBase(args[0])
+			if err != nil {
+				return 1, err
+			}
+			ret.ibase = base
+			return 1, nil
+		}},
+		ophandler{"dec", "Output in decimal", 0, func(_ []*decimal.Big) ([]*decimal.Big, int, error) {
+			ret.base = 10
+			ret.degmode = false
+			return nil, 0, nil
+		}},
+		ophandler{"bin", "Output in binary", 0, func(_ []*decimal.Big) ([]*decimal.Big, int, error) {
+			ret.base = 2
+			ret.degmode = false
+			return nil, 0, nil
+		}},
+		ophandler{"oct", "Output in octal", 0, func(_ []*decimal.Big) ([]*decimal.Big, int, error) {
+			ret.base = 8
+			ret.degmode = false
+			return nil, 0, nil
+		}},
+		ophandler{"hex", "Output in hexadecimal", 0, func(_ []*decimal.Big) ([]*decimal.Big, int, error) {
+			ret.base = 16
+			ret.degmode = false
+			return nil, 0, nil
+		}},
+		ophandler{"deg", "All angles in degrees", 0, func(_ []*decimal.Big) ([]*decimal.Big, int, error) {
+			ret.base = 10
+			ret.degmode = true
+			return nil, 0, nil
+		}},
+		ophandler{"rad", "All angles in radians", 0, func(_ []*decimal.Big) ([]*decimal.Big, int, error) {
+			ret.base = 10
+			ret.degmode = false
+			return nil, 0, nil
+		}},
+		ophandler{"fmt", "Change output to X decimals", 1, func(a []*decimal.Big) ([]*decimal.Big, int, error) {
+			x, ok := a[0].Uint64()
+			if !ok || !a[0].IsInt() {
+				return nil, 1, errors.New("precision must be a positive integer")
+			}
+			ret.decimals = int(x)
+			return nil, 1, nil
+		}},
+		ophandler{"si", "Toggle SI prefixes (E.g. 1.5M) in the humanized form of decimal numbers", 0, func(_ []*decimal.Big) ([]*decimal.Big, int, error) {
+			if ret.human == humanSI {
+				ret.human = humanComma
+			} else {
+				ret.human = humanSI
+			}
+			return nil, 0, nil
+		}},
+		ophandler{"bytes", "Toggle byte sizes (E.g. 1.44 GiB) in the humanized form of decimal numbers", 0, func(_ []*decimal.Big) ([]*decimal.Big, int, error) {
+			if ret.human == humanBytes {
+				ret.human = humanComma
+			} else {
+				ret.human = humanBytes
+			}
+			return nil, 0, nil
+		}},
+		cmdhandler{"color", "<key> <sgr>", "Set the color of key (x, y, idx, val, chg, res, err, warn, bold) to a dot separated list of ANSI SGR codes (E.g. color res 1.34), or off", func(args []string) (int, error) {
+			if len(args) < 2 {
+				return 0, errors.New("usage: color <key> <sgr>")
+			}
+			sgr := strings.ReplaceAll(args[1], ".", ";")
+			if sgr == "off" {
+				sgr = ""
+			}
+			colors, err := parseStackColors(stackColors, args[0]+"="+sgr)
+			if err != nil {
+				return 2, err
+			}
+			stackColors = colors
+			return 2, nil
+		}},
+		cmdhandler{"currency", "<symbol>", "Display decimal numbers as money with a symbol: dollar ($1,234.50), euro, pound, yen, none (no symbol), or off", func(args []string) (int, error) {
+			if len(args) < 1 {
+				return 0, errors.New("usage: currency <symbol>")
+			}
+			if args[0] == "off" {
+				ret.currency = false
+				return 1, nil
+			}
+			sym, ok := currencySymbols[args[0]]
+			if !ok {
+				return 1, fmt.Errorf("invalid currency symbol: %q", args[0])
+			}
+			ret.currency = true
+			ret.symbol = sym
+			return 1, nil
+		}},
+		cmdhandler{"human", "<mode>", "Show the humanized form of decimal numbers: always, never, or auto (only when different from the number)", func(args []string) (int, error) {
+			if len(args) < 1 {
+				return 0, errors.New("usage: human <mode>")
+			}
+			mode, ok := humanModes[args[0]]
+			if !ok {
+				return 1, fmt.Errorf("invalid humanized display mode: %q", args[0])
+			}
+			ret.showHuman = mode
+			return 1, nil
+		}},
+		cmdhandler{"sep", "<style>", "Separators in the humanized form of decimal numbers: comma (1,234.5), period (1.234,5), space (1 234.5), underscore (1_234.5), or indian (1,23,456.5)", func(args []string) (int, error) {
+			if len(args) < 1 {
+				return 0, errors.New("usage: sep <style>")
+			}
+			if _, ok := separators[args[0]]; !ok || args[0] == "" {
+				return 1, fmt.Errorf("invalid separator style: %q", args[0])
+			}
+			ret.sep = args[0]
+			return 1, nil
+		}},
+		ophandler{"pretty", "Toggle pretty (Unicode) output of decimal numbers", 0, func(_ []*decimal.Big) ([]*decimal.Big, int, error) {
+			ret.pretty = !ret.pretty
+			return nil, 0, nil
+		}},
+		ophandler{"history", "Display input history (use !n to repeat line n, !! for the last)", 0, func(_ []*decimal.Big) ([]*decimal.Big, int, error) {
+			for ix, line := range ret.history {
+				fmt.Fprintf(ret.out, "%4d  %s\n", ix+1, line)
+			}
+			return nil, 0, nil
+		}},
+		ophandler{"alg", "Toggle infix (algebraic) mode: evaluate expressions like (2+3)*4^2", 0, func(_ []*decimal.Big) ([]*decimal.Big, int, error) {
+			ret.infix = !ret.infix
+			fmt.Fprintf(ret.out, warnMsg("Infix mode: %v\n"), ret.infix)
+			return nil, 0, nil
+		}},
+		ophandler{"version", "Print the version of rpn", 0, func(_ []*decimal.Big) ([]*decimal.Big, int, error) {
+			fmt.Fprintln(ret.out, "rpn version:", Version())
+			return nil, 0, nil
+		}},
+		ophandler{"debug", "Toggle debugging", 0, func(_ []*decimal.Big) ([]*decimal.Big, int, error) {
+			ret.debug = !ret.debug
+			fmt.Fprintf(ret.out, warnMsg("Debugging state: %v\n"), ret.debug)
+			return nil, 0, nil
+		}},
+		ophandler{"step", "Toggle step mode (show the stack after each operation in macros)", 0, func(_ []*decimal.Big) ([]*decimal.Big, int, error) {
+			ret.step = !ret.step
+			fmt.Fprintf(ret.out, warnMsg("Step mode: %v\n"), ret.step)
+			return nil, 0, nil
+		}},
+		"",
+		"BOLD:Please Note:",
+		"  - x means the number at the top of the stack",
+		"  - y means the second number from the top of the stack",
+		"  - ans pushes the last printed result, ans2 the one before it, and so on",
+	}
+
+	// Operations registered by plugins go right before the final notes.
+	if user := userOpHandlers(); user != nil {
+		n := len(ret.ops)
+		for i, v := range ret.ops {
+			if v == "BOLD:Please Note:" {
+				n = i - 1
+			}
+		}
+		ret.ops = append(ret.ops[:n:n], append(user, ret.ops[n:]...)...)
+	}
+	return ret
+}
+
+// operation performs an operation on the stack and returns a slice of elements
+// added to the stack and the number of elements removed from the stack.
+func operation(handler ophandler, stack *stackType) ([]*decimal.Big, int, error) {
+	// Make sure we have enough arguments in the list.
+	length := len(stack.list)
+	if length < handler.numArgs {
+		return nil, 0, fmt.Errorf("this operation requires at least %d items in the stack", handler.numArgs)
+	}
+
+	// args contains a copy of all elements in the stack reversed.  This makes
+	// it easier for functions to use x as a[0], y as a[1], etc.
+	args := []*decimal.Big{}
+	for ix := length - 1; ix >= 0; ix-- {
+		args = append(args, stack.list[ix])
+	}
+
+	ret, remove, err := handler.fn(args)
+	if err != nil {
+		return nil, 0, err
+	}
+	// Remove the number of arguments this operation consumes if needed.
+	if remove > 0 && len(stack.list) < remove {
+		return nil, 0, fmt.Errorf("(internal) operation %q wants to pop %d items, but we only have %d", handler.op, remove, len(stack.list))
+	}
+
+	stack.list = stack.list[0 : len(stack.list)-remove]
+
+	// Add the return values from the function to the stack if we have any.
+	// Each entry in the stack must be a distinct number, so results still in
+	// the stack (E.g. over, pick) or returned more than once are copied.
+	if len(ret) > 0 {
+		ret = slices.Clone(ret)
+		seen := make(map[*decimal.Big]bool, len(stack.list)+len(ret))
+		for _, v := range stack.list {
+			seen[v] = true
+		}
+		for i, v := range ret {
+			if seen[v] {
+				ret[i] = big().Copy(v)
+			}
+			seen[ret[i]] = true
+		}
+		stack.push(ret...)
+	}
+	return ret, remove, nil
+}
+
+// compare compares y and x and returns 1 if cond(comparison result) is true,
+// or 0 otherwise. Comparisons involving NaN are always false.
+func compare(y, x *decimal.Big, cond func(int) bool) *decimal.Big {
+	if y.IsNaN(0) || x.IsNaN(0) || !cond(y.Cmp(x)) {
+		return bigUint(0)
+	}
+	return bigUint(1)
+}
+
+// stackCount returns x (a[0]) as a number of stack items to be used by an
+// operation, making sure the stack holds at least that many items besides x.
+func stackCount(a []*decimal.Big) (int, error) {
+	n, ok := a[0].Uint64()
+	if !ok || !a[0].IsInt() || n == 0 {
+		return 0, errors.New("number of items must be a positive integer")
+	}
+	if n > uint64(len(a)-1) {
+		return 0, fmt.Errorf("this operation requires at least %d items in the stack", n+1)
+	}
+	return int(n), nil
+}
+
+// store implements the sto family of commands. It stores x in the register
+// named in args[0] without changing the stack. If op is not nil, the register
+// is set to the result of op(register, x) instead. Empty registers are zero.
+func (x *opsType) store(args []string, op func(z, r, x *decimal.Big) *decimal.Big) (int, error) {
+	if len(args) < 1 {
+		return 0, errors.New("usage: sto <reg>")
+	}
+	if len(x.stack.list) < 1 {
+		return 1, errors.New("this operation requires at least 1 items in the stack")
+	}
+	v := big().Copy(x.stack.top())
+	if op != nil {
+		r, ok := x.registers[args[0]]
+		if !ok {
+			r = big()
+		}
+		v = op(big(), r, v)
+	}
+	if x.registers == nil {
+		x.registers = map[string]*decimal.Big{}
+	}
+	x.registers[args[0]] = v
+	return 1, nil
+}
+
+// Valid input and output bases.
+const (
+	minBase = 2
+	maxBase = 36
+)
+
+// validBase returns true if base is a valid input or output base.
+func validBase(base int) bool {
+	return base >= minBase && base <= maxBase
+}
+
+// parseBase parses the argument of the obase and ibase commands. The base
+// is always read in decimal.
+func parseBase(s string) (int, error) {
+	base, err := strconv.Atoi(s)
+	if err != nil || !validBase(base) {
+		return 0, fmt.Errorf("base must be between %d and %d: %q", minBase, maxBase, s)
+	}
+	return base, nil
+}
+
+// setOption sets an option by name (used by the "set" command).
+func (x *opsType) setOption(name, value string) error {
+	switch name {
+	case "maxdepth":
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 0 {
+			return fmt.Errorf("invalid value for maxdepth: %q", value)
+		}
+		x.maxDepth = n
+		return nil
+	case "onerror":
+		switch value {
+		case "stop":
+			x.keepGoing = false
+		case "continue":
+			x.keepGoing = true
+		default:
+			return fmt.Errorf("invalid value for onerror (use stop or continue): %q", value)
+		}
+		return nil
+	}
+	return fmt.Errorf("unknown option: %q", name)
+}
+
+// opmap returns a map of op (command) -> ophandler that can be easily used
+// later to find the function to be executed. It takes a slice of interfaces
+// and returns a map[string][ophandler]. The map is built once and cached.
+func (x *opsType) opmap() opmapType {
+	if x.opcache != nil {
+		return x.opcache
+	}
+	ret := map[string]ophandler{}
+
+	for _, v := range x.ops {
+		if h, ok := v.(ophandler); ok {
+			ret[h.op] = h
+		}
+	}
+	x.opcache = ret
+	return ret
+}
+
+// cmdmap returns a map of command -> cmdhandler that can be easily used later
+// to find the function to be executed. The map is built once and cached.
+func (x *opsType) cmdmap() cmdmapType {
+	if x.cmdcache != nil {
+		return x.cmdcache
+	}
+	ret := map[string]cmdhandler{}
+
+	for _, v := range x.ops {
+		if h, ok := v.(cmdhandler); ok {
+			ret[h.cmd] = h
+		}
+	}
+	x.cmdcache = ret
+	return ret
+}
+
+// help displays the help message based on the contents of opmap. The output
+// goes through the pager, if set.
+func (x opsType) help() error {
+	if x.pager == nil {
+		x.printHelp(x.out)
+		return nil
+	}
+
+	w, err := x.pager()
+	if err != nil {
+		return err
+	}
+	x.printHelp(w)
+	return w.Close()
+}
+
+// printHelp writes the help message to w.
+func (x opsType) printHelp(w io.Writer) {
+	for _, v := range x.ops {
+		// ophandler lines.
+		if handler, ok := v.(ophandler); ok {
+			fmt.Fprintf(w, "  - %s: %s\n", bold(handler.op), handler.desc)
+			continue
+		}
+		// cmdhandler lines.
+		if handler, ok := v.(cmdhandler); ok {
+			if handler.args == "" {
+				fmt.Fprintf(w, "  - %s: %s\n", bold(handler.cmd), handler.desc)
+				continue
+			}
+			fmt.Fprintf(w, "  - %s %s: %s\n", bold(handler.cmd), handler.args, handler.desc)
+			continue
+		}
+		// Regular strings.
+		// Anything starting with "BOLD:" is printed in bold.
+		if s, ok := v.(string); ok {
+			if strings.HasPrefix(s, "BOLD:") {
+				s = bold(s[5:])
+			}
+			fmt.Fprintln(w, s)
+		}
+	}
+}
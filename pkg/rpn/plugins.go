@@ -0,0 +1,73 @@
+// This file is part of rpn, a simple and useful CLI RPN calculator.
+// For further information, check https://github.com/marcopaganini/rpn
+//
+// (C) Sep/2024 by Marco Paganini <paganini AT paganini DOT net>
+
+package rpn
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/ericlagergren/decimal"
+)
+
+// Op describes an operation added to the calculator by RegisterOp or by a
+// plugin. Fn receives NumArgs numbers from the stack, x first, and returns
+// the numbers to push back on the stack (the last one becomes x).
+type Op struct {
+	Name    string
+	Desc    string
+	NumArgs int
+	Fn      func(args []*decimal.Big) ([]*decimal.Big, error)
+}
+
+// userOps holds the operations registered with RegisterOp.
+var userOps []Op
+
+// RegisterOp adds op to the operations of all calculators created after the
+// call. It returns an error if the name is invalid or already in use. Names
+// that would hide numbers (E.g. 1e3), blocks, results (ans) or the help and
+// quit commands are invalid. RegisterOp is not safe for concurrent use.
+func RegisterOp(op Op) error {
+	_, err := parseNumber(op.Name, 10)
+	switch {
+	case op.Name == "" || strings.ContainsAny(op.Name, " \t\n[]") || cleanRe.MatchString(op.Name) ||
+		err == nil || ansRe.MatchString(op.Name) ||
+		slices.Contains(helpTokens, op.Name) || slices.Contains(quitTokens, op.Name):
+		return fmt.Errorf("invalid operation name: %q", op.Name)
+	case op.Fn == nil:
+		return fmt.Errorf("operation %q: missing function", op.Name)
+	case op.NumArgs < 0:
+		return fmt.Errorf("operation %q: invalid number of arguments: %d", op.Name, op.NumArgs)
+	}
+	ops := newOpsType(decimal.Context128, &stackType{})
+	if _, ok := ops.opmap()[op.Name]; ok {
+		return fmt.Errorf("operation %q already exists", op.Name)
+	}
+	if _, ok := ops.cmdmap()[op.Name]; ok {
+		return fmt.Errorf("operation %q already exists", op.Name)
+	}
+	userOps = append(userOps, op)
+	return nil
+}
+
+// userOpHandlers returns the help header and handlers for all registered
+// operations, or nil if there are none.
+func userOpHandlers() []interface{} {
+	if len(userOps) == 0 {
+		return nil
+	}
+	ret := []interface{}{"", "BOLD:Plugins"}
+	for _, op := range userOps {
+		ret = append(ret, ophandler{op.Name, op.Desc, op.NumArgs, func(a []*decimal.Big) ([]*decimal.Big, int, error) {
+			res, err := op.Fn(a[:op.NumArgs])
+			if err != nil {
+				return nil, 0, err
+			}
+			return res, op.NumArgs, nil
+		}})
+	}
+	return ret
+}
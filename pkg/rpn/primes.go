@@ -0,0 +1,105 @@
+// This file is part of rpn, a simple and useful CLI RPN calculator.
+// For further information, check https://github.com/marcopaganini/rpn
+//
+// (C) Sep/2024 by Marco Paganini <paganini AT paganini DOT net>
+
+package rpn
+
+import (
+	"errors"
+	mathbig "math/big"
+	"sort"
+
+	"github.com/ericlagergren/decimal"
+)
+
+// primeRounds is the number of Miller-Rabin rounds used by ProbablyPrime.
+// ProbablyPrime is 100% accurate for numbers below 2^64.
+const primeRounds = 20
+
+// bigToInt converts an integer decimal to a big.Int.
+func bigToInt(x *decimal.Big) (*mathbig.Int, error) {
+	if x.IsNaN(0) || x.IsInf(0) || !x.IsInt() {
+		return nil, errors.New("this operation requires an integer")
+	}
+	return x.Int(nil), nil
+}
+
+// intToBig converts a big.Int to a decimal.
+func intToBig(n *mathbig.Int) *decimal.Big {
+	return big().SetBigMantScale(n, 0)
+}
+
+// nextPrime returns the smallest prime greater than n.
+func nextPrime(n *mathbig.Int) *mathbig.Int {
+	two := mathbig.NewInt(2)
+	if n.Cmp(two) < 0 {
+		return two
+	}
+	// Start at the next odd number.
+	p := new(mathbig.Int).Add(n, mathbig.NewInt(1))
+	if p.Bit(0) == 0 {
+		p.Add(p, mathbig.NewInt(1))
+	}
+	for !p.ProbablyPrime(primeRounds) {
+		p.Add(p, two)
+	}
+	return p
+}
+
+// pollardRho returns a non-trivial factor of the composite number n.
+func pollardRho(n *mathbig.Int) *mathbig.Int {
+	one := mathbig.NewInt(1)
+	for c := int64(1); ; c++ {
+		x, y, d := mathbig.NewInt(2), mathbig.NewInt(2), mathbig.NewInt(1)
+		f := func(v *mathbig.Int) {
+			v.Mul(v, v)
+			v.Add(v, mathbig.NewInt(c))
+			v.Mod(v, n)
+		}
+		diff := new(mathbig.Int)
+		for d.Cmp(one) == 0 {
+			f(x)
+			f(y)
+			f(y)
+			diff.Sub(x, y)
+			d.GCD(nil, nil, diff.Abs(diff), n)
+		}
+		if d.Cmp(n) != 0 {
+			return d
+		}
+	}
+}
+
+// factorize returns the prime factors of n (n > 1) in ascending order.
+func factorize(n *mathbig.Int) []*mathbig.Int {
+	factors := []*mathbig.Int{}
+	n = new(mathbig.Int).Set(n)
+
+	// Small factors first.
+	for p := int64(2); p < 1000; p++ {
+		bp := mathbig.NewInt(p)
+		for new(mathbig.Int).Mod(n, bp).Sign() == 0 {
+			factors = append(factors, bp)
+			n.Quo(n, bp)
+		}
+	}
+
+	var split func(n *mathbig.Int)
+	split = func(n *mathbig.Int) {
+		if n.Cmp(mathbig.NewInt(1)) == 0 {
+			return
+		}
+		if n.ProbablyPrime(primeRounds) {
+			factors = append(factors, n)
+			return
+		}
+		d := pollardRho(n)
+		split(d)
+		split(new(mathbig.Int).Quo(n, d))
+	}
+	split(n)
+
+	sort.Slice(factors, func(i, j int) bool { return factors[i].Cmp(factors[j]) < 0 })
+	return factors
+}
@@ -0,0 +1,329 @@
+// This file is part of rpn, a simple and useful CLI RPN calculator.
+// For further information, check https://github.com/marcopaganini/rpn
+//
+// (C) Sep/2024 by Marco Paganini <paganini AT paganini DOT net>
+
+// Package rpn implements the engine of rpn, an arbitrary precision RPN
+// calculator.
+//
+// Programs embedding the calculator use a Calculator to evaluate
+// expressions in the same language used interactively:
+//
+//	c := rpn.New()
+//	if err := c.Eval("2 3 + 4 *"); err != nil {
+//		return err
+//	}
+//	x, _ := c.Top() // 20
+package rpn
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+
+	"github.com/ericlagergren/decimal"
+)
+
+// Calculator is an RPN calculator with its own stack, modes, registers and
+// macros. A Calculator is not safe for concurrent use.
+type Calculator struct {
+	ops *opsType
+}
+
+// New returns a new Calculator with an empty stack. Messages and stack
+// displays produced by operations (E.g. "p") are discarded, unless an output
+// is set with SetOutput.
+func New() *Calculator {
+	ops := newOpsType(decimal.Context128, &stackType{})
+	ops.out = io.Discard
+	ops.strict = true
+	return &Calculator{ops: ops}
+}
+
+// Eval evaluates expr using a new Calculator and returns the top of the stack.
+func Eval(expr string) (*decimal.Big, error) {
+	c := New()
+	if err := c.Eval(expr); err != nil {
+		return nil, err
+	}
+	return c.Top()
+}
+
+// SetOutput sets the writer used for messages and stack displays.
+func (c *Calculator) SetOutput(w io.Writer) {
+	c.ops.out = w
+}
+
+// SetStrict sets whether unknown tokens are errors (the default). Otherwise,
+// a message is written to the output and the rest of the line is ignored.
+func (c *Calculator) SetStrict(strict bool) {
+	c.ops.strict = strict
+}
+
+// SetKeepGoing sets whether scripts continue with the next line after
+// errors, as with "set onerror continue".
+func (c *Calculator) SetKeepGoing(keepGoing bool) {
+	c.ops.keepGoing = keepGoing
+}
+
+// KeepGoing returns true if scripts continue with the next line after
+// errors (see SetKeepGoing).
+func (c *Calculator) KeepGoing() bool {
+	return c.ops.keepGoing
+}
+
+// SetQuiet suppresses notes written by operations (E.g. about numbers
+// truncated by bitwise operations).
+func (c *Calculator) SetQuiet(quiet bool) {
+	c.ops.quiet = quiet
+}
+
+// SetPlain sets whether results are printed without the "= " prefix and the
+// humanized form (see PrintTop).
+func (c *Calculator) SetPlain(plain bool) {
+	c.ops.plain = plain
+}
+
+// SetInfix sets infix (algebraic) mode, as with "alg".
+func (c *Calculator) SetInfix(infix bool) {
+	c.ops.infix = infix
+}
+
+// SetDegrees sets whether angles are in degrees or radians (the default).
+func (c *Calculator) SetDegrees(degrees bool) {
+	c.ops.degmode = degrees
+}
+
+// SetDecimals sets the number of decimals in results, as with "fmt".
+func (c *Calculator) SetDecimals(n int) error {
+	if n < 0 {
+		return errors.New("number of decimals must not be negative")
+	}
+	c.ops.decimals = n
+	return nil
+}
+
+// SetBase sets the output base, from 2 to 36.
+func (c *Calculator) SetBase(base int) error {
+	if !validBase(base) {
+		return fmt.Errorf("base must be between %d and %d", minBase, maxBase)
+	}
+	c.ops.base = base
+	return nil
+}
+
+// SetInputBase sets the base of numbers entered without a prefix, from 2 to
+// 36, as with "ibase".
+func (c *Calculator) SetInputBase(base int) error {
+	if !validBase(base) {
+		return fmt.Errorf("base must be between %d and %d", minBase, maxBase)
+	}
+	c.ops.ibase = base
+	return nil
+}
+
+// SetPrecision sets the working precision in significant digits, as with
+// "prec".
+func (c *Calculator) SetPrecision(digits int) error {
+	_, err := c.ops.cmdmap()["prec"].fn([]string{strconv.Itoa(digits)})
+	return err
+}
+
+// Define defines a macro that runs expr, as with "def".
+func (c *Calculator) Define(name, expr string) error {
+	return c.ops.defineMacro(name, tokenize(expr))
+}
+
+// SetStepWait sets the function used to wait for the user after each
+// operation in step mode. Returning "q" or an error stops the execution.
+func (c *Calculator) SetStepWait(fn func() (string, error)) {
+	c.ops.stepWait = fn
+}
+
+// SetPager sets the function used by help to open a pager. The help text is
+// written to the pager, which is closed afterwards. Without a pager, help is
+// written to the output.
+func (c *Calculator) SetPager(fn func() (io.WriteCloser, error)) {
+	c.ops.pager = fn
+}
+
+// SetClipboard sets the clipboard used by copy and paste. Without a
+// clipboard, both return an error.
+func (c *Calculator) SetClipboard(cb Clipboard) {
+	c.ops.clipboard = cb
+}
+
+// Eval evaluates expr (E.g. "2 3 +") using the calculator stack. In case of
+// errors, the stack, modes and registers are restored to their state before
+// the evaluation.
+func (c *Calculator) Eval(expr string) error {
+	_, err := c.Exec(expr)
+	if errors.Is(err, ErrQuit) {
+		return nil
+	}
+	return err
+}
+
+// Exec evaluates a line of input like Eval and returns true if the top of
+// the stack should be printed afterwards, as done in interactive mode. A
+// quit command returns ErrQuit. A panic in an operation is returned as an
+// error and the calculator is restored, so it never crashes the caller.
+func (c *Calculator) Exec(line string) (autoprint bool, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			c.ops.setState(c.ops.lineStart)
+			autoprint, err = false, fmt.Errorf("internal error: %v", r)
+		}
+	}()
+	return execute(c.ops, line)
+}
+
+// Debug returns true if debugging was turned on with "debug".
+func (c *Calculator) Debug() bool {
+	return c.ops.debug
+}
+
+// Prompt returns the interactive prompt, showing the workspace and the
+// output base, input base, angle or infix mode. A non-empty format replaces
+// the following placeholders: %b (output base: dec, hex, oct, bin, or bN),
+// %i (input base), %a (angle mode: deg or rad), %n (stack depth), %p
+// (decimals), %P (working precision), %w (workspace), and %% (a literal %).
+func (c *Calculator) Prompt(format string) string {
+	return prompt(c.ops, format)
+}
+
+// AddHistory adds line to the input history, shown by "history".
+func (c *Calculator) AddHistory(line string) {
+	c.ops.history = append(c.ops.history, line)
+}
+
+// ExpandHistory replaces all history references in line with the
+// corresponding lines in the input history. "!!" refers to the previous line
+// and "!n" refers to line n (starting at 1).
+func (c *Calculator) ExpandHistory(line string) (string, error) {
+	return expandHistory(line, c.ops.history)
+}
+
+// Top returns the top of the stack (x).
+func (c *Calculator) Top() (*decimal.Big, error) {
+	if len(c.ops.stack.list) == 0 {
+		return nil, errors.New("stack is empty")
+	}
+	return big().Copy(c.ops.stack.top()), nil
+}
+
+// Stack returns a copy of all items in the stack, x last.
+func (c *Calculator) Stack() []*decimal.Big {
+	ret := make([]*decimal.Big, len(c.ops.stack.list))
+	for i, n := range c.ops.stack.list {
+		ret[i] = big().Copy(n)
+	}
+	return ret
+}
+
+// Len returns the number of items in the stack.
+func (c *Calculator) Len() int {
+	return len(c.ops.stack.list)
+}
+
+// Clear removes all items from the stack.
+func (c *Calculator) Clear() {
+	c.ops.stack.clear()
+}
+
+// Push pushes numbers on the stack. The last number becomes x.
+func (c *Calculator) Push(n ...*decimal.Big) {
+	for _, v := range n {
+		c.ops.stack.push(big().Copy(v))
+	}
+}
+
+// Format formats n using the current display options of the calculator (E.g.
+// the number of decimals and the output base), without decorations.
+func (c *Calculator) Format(n *decimal.Big) string {
+	d := c.ops.displayType
+	d.plain = true
+	return d.format(c.ops.ctx, n)
+}
+
+// PrintTop writes the top of the stack to w, as printed after each line in
+// interactive mode (E.g. "= 5").
+func (c *Calculator) PrintTop(w io.Writer) {
+	c.ops.stack.printTop(w, c.ops.ctx, c.ops.displayType)
+}
+
+// PrintStack writes the stack to w, as printed by "p".
+func (c *Calculator) PrintStack(w io.Writer) {
+	c.ops.stack.print(w, c.ops.ctx, c.ops.displayType, false)
+}
+
+// PrintResult writes the top of the stack to w, followed by a newline. An
+// empty format prints x with all stored digits (and the humanized form, with
+// "human always"). Formats containing "{{" are text/template templates
+// with the fields Raw (the default), Fmt (formatted with the current display
+// options), Human, Hex, Oct, Bin and Stack (all items, x last). Anything else
+// is a printf-style format (E.g. "%.2f").
+func (c *Calculator) PrintResult(w io.Writer, format string) error {
+	if len(c.ops.stack.list) == 0 {
+		return errors.New("stack is empty")
+	}
+	return printResult(w, c.ops, format)
+}
+
+// ParseNumbers returns the numbers in line, read as if typed in the
+// calculator (E.g. in the input base). It returns an error if line holds
+// anything else.
+func (c *Calculator) ParseNumbers(line string) ([]*decimal.Big, error) {
+	ret := []*decimal.Big{}
+	for _, token := range tokenize(line) {
+		n, err := parseNumber(token, c.ops.ibase)
+		if err != nil {
+			return nil, fmt.Errorf("not a number: %q", token)
+		}
+		ret = append(ret, n)
+	}
+	return ret, nil
+}
+
+// NumArgs returns the number of arguments taken by the operation name, and
+// false if there's no such operation.
+func (c *Calculator) NumArgs(name string) (int, bool) {
+	handler, ok := c.ops.opmap()[name]
+	return handler.numArgs, ok
+}
+
+// Check validates a script read from r without executing it, using the
+// macros defined so far and the input base. It returns a list of problems
+// found (unknown operators, malformed numbers, missing arguments, unbalanced
+// blocks), each prefixed by name and the line number.
+func (c *Calculator) Check(r io.Reader, name string) ([]string, error) {
+	return checkScript(c.ops, r, name)
+}
+
+// LoadRegisters restores the registers saved with "save".
+func (c *Calculator) LoadRegisters() error {
+	registers, err := loadVars()
+	if err != nil {
+		return err
+	}
+	c.ops.registers = registers
+	return nil
+}
+
+// SaveSession writes the stack and modes to w, in JSON.
+func (c *Calculator) SaveSession(w io.Writer) error {
+	return saveSession(c.ops, w)
+}
+
+// LoadSession restores the stack and modes from a session saved by
+// SaveSession.
+func (c *Calculator) LoadSession(r io.Reader) error {
+	return loadSession(c.ops, r)
+}
+
+// ParseNumber converts a string to a number. Strings starting in 0x, 0b and
+// 0 (or o) are read as hexadecimal, binary and octal numbers.
+func ParseNumber(s string) (*decimal.Big, error) {
+	return atof(s)
+}
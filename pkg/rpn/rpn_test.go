@@ -0,0 +1,1979 @@
+// This file is part of rpn, a simple and useful CLI RPN calculator.
+// For further information, check https://github.com/marcopaganini/rpn
+//
+// (C) Sep/2024 by Marco Paganini <paganini AT paganini DOT net>
+
+package rpn
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/ericlagergren/decimal"
+	"github.com/fatih/color"
+)
+
+// This precision is used to compare results in tests.  Use two digits less
+// than the max (34) to simplify rounding issues.
+const defaultTestPrecision = 32
+
+// TestMain keeps the tests from writing to the user's cache and state
+// directories.
+func TestMain(m *testing.M) {
+	dir, err := os.MkdirTemp("", "rpn-test")
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	os.Setenv("XDG_CACHE_HOME", filepath.Join(dir, "cache"))
+	os.Setenv("XDG_STATE_HOME", filepath.Join(dir, "state"))
+	code := m.Run()
+	os.RemoveAll(dir)
+	os.Exit(code)
+}
+
+func TestRPN(t *testing.T) {
+	ctx := decimal.Context128
+
+	casetests := []struct {
+		input     string
+		want      *decimal.Big
+		wantError bool
+		precision int
+		strict    bool
+	}{
+		// Note: We use a "continuous" stack across operations.
+		// Basic operations.
+		{input: "1 2 +", want: bigUint(3)},
+		{input: "4 5 8 + + +", want: bigUint(20)},
+		{input: "5 -", want: bigUint(15)},
+		{input: "3 /", want: bigUint(5)},
+		{input: "3.5 *", want: bigFloat("17.5")},
+		{input: "2 *", want: bigFloat("35.0")},
+		{input: "chs", want: bigFloat("-35.0")},
+		{input: "inv", want: bigFloat("-0.02857142857142857142857142857142857")},
+		{input: "inv", want: bigFloat("-35.00000000000000000000000000000000")},
+		{input: "chs", want: bigUint(35)},
+		{input: "3 ^", want: bigUint(42875)},
+		{input: "10 mod", want: bigUint(5)},
+		{input: "fac", want: bigUint(120)},
+		{input: "c 0 fac", want: bigUint(1)},
+		{input: "c 30 fac", want: bigFloat("265252859812191058636308480000000")},
+		{input: "c 40 fac", want: bigFloat("815915283247897734345611269596115894272000000000")},
+		{input: "c 1000 fac", want: bigFloat("4.023872600770937735437024339230040E+2567")},
+		{input: "c 100000 fac", want: bigFloat("2.824229407960347874293421578024536E+456573")},
+		{input: "c 300000 fac", wantError: true},
+		{input: "c 1e100 fac", wantError: true},
+		{input: "c -1 fac", wantError: true},
+		{input: "c 0.5 fac", want: bigFloat("0.8862269254527580136490837416705726")},
+		{input: "c -1.5 fac", want: bigFloat("-3.544907701811032054596334966682290")},
+		{input: "c 0.5 gamma", want: bigFloat("1.772453850905516027298167483341145")},
+		{input: "c 10.5 gamma", want: bigFloat("1133278.388948785567334574165588892")},
+		{input: "c 0.001 gamma", want: bigFloat("999.4237724845954661149822012996440")},
+		{input: "c 100 gamma", want: bigFloat("9.332621544394415268169923885626670E+155")},
+		{input: "c 6 gamma", want: bigUint(120)},
+		{input: "c 3000.5 gamma", want: bigFloat("7.575343867443084879461560685404434E+9128")},
+		{input: "c 0 gamma", wantError: true},
+		{input: "c -2 gamma", wantError: true},
+		{input: "c 97 isprime", want: bigUint(1)},
+		{input: "c 91 isprime", want: bigUint(0)},
+		{input: "c 1 isprime", want: bigUint(0)},
+		{input: "c 1.5 isprime", wantError: true},
+		{input: "c 100 nextprime", want: bigUint(101)},
+		{input: "c -5 nextprime", want: bigUint(2)},
+		{input: "c 360 factor", want: bigUint(5)},
+		{input: "c 360 factor + + + + +", want: bigUint(17)},
+		{input: "c 1000000016000000063 factor", want: bigUint(1000000009)},
+		{input: "c 1000000016000000063 factor x", want: bigUint(1000000007)},
+		{input: "c 1 factor", wantError: true},
+		{input: "c 2.5 floor", want: bigUint(2)},
+		{input: "c -2.5 floor", want: bigFloat("-3")},
+		{input: "c 2.1 ceil", want: bigUint(3)},
+		{input: "c -2.5 ceil", want: bigFloat("-2")},
+		{input: "c 3.14159265 round", want: bigFloat("3.141593")},
+		{input: "c 2 fmt 2.345 round", want: bigFloat("2.34")},
+		{input: "c 6 fmt 1e40 round", want: bigFloat("1e40")},
+		{input: "c -2.75 trunc", want: bigFloat("-2")},
+		{input: "c -2.75 frac", want: bigFloat("-0.75")},
+		{input: "c 12 frac", want: bigUint(0)},
+		{input: "c 7 2 //", want: bigUint(3)},
+		{input: "c -7 2 //", want: bigFloat("-3")},
+		{input: "c 7.5 2 idiv", want: bigUint(3)},
+		{input: "c 1e50 4 //", want: bigFloat("2.5e49")},
+		{input: "c 27 3 root", want: bigUint(3)},
+		{input: "c 8 chs 3 root", want: bigFloat("-2")},
+		{input: "c 16 -2 root", want: bigFloat("0.25")},
+		{input: "c 2 2 root", want: bigFloat("1.414213562373095048801688724209698")},
+		{input: "c 8 chs 2 root", wantError: true},
+		{input: "c 2 0 root", wantError: true},
+		{input: "c 3 4 hypot", want: bigUint(5)},
+		{input: "c -5 12 hypot", want: bigUint(13)},
+		{input: "c 1 1 hypot", want: bigFloat("1.414213562373095048801688724209698")},
+		{input: "c 1e5000 1e5000 hypot", want: bigFloat("1.414213562373095048801688724209698e5000")},
+		{input: "c 1 -3 2 quad", want: bigUint(2)},
+		{input: "c 1 -3 2 quad x", want: bigUint(1)},
+		{input: "c 2 0 -8 quad", want: bigUint(2)},
+		{input: "c 1 2 1 quad depth", want: bigUint(2)},
+		{input: "c 1 1e20 1 quad", want: bigFloat("-1e-20")},
+		{input: "c 1 2 5 quad", wantError: true},
+		{input: "c 0 1 1 quad", wantError: true},
+		{input: "c 1 2 3 5 poly 2", want: bigUint(38)},
+		{input: "c 9 1 2 3 5 poly 2 depth", want: bigUint(2)},
+		{input: "c 1 -6 11 -6 3 poly 3", want: bigUint(0)},
+		{input: "c 7 5 poly 0", want: bigUint(7)},
+		{input: "c 0.5 0.1 2 poly 1", want: bigFloat("1.1")},
+		{input: "c 1 2 5 poly 2", wantError: true},
+		{input: "c 1 2 poly -1", wantError: true},
+		{input: "c 1 2 poly", wantError: true},
+		{input: "c 80 100 pct", want: bigUint(25)},
+		{input: "c 100 80 pct", want: bigFloat("-20")},
+		{input: "c 100 80 Δ%", want: bigFloat("-20")},
+		{input: "c 80 100 Δ%", want: bigUint(25)},
+		{input: "c 1 2 3 4 csum", want: bigUint(10)},
+		{input: "c 1 2 3 4 csum x", want: bigUint(6)},
+		{input: "c 1 2 3 4 csum depth", want: bigUint(4)},
+		{input: "c 5 -2 csum", want: bigUint(3)},
+		{input: "c 1 2 3 4 prod", want: bigUint(24)},
+		{input: "c 1 2 3 4 mean", want: bigFloat("2.5")},
+		{input: "c 7 mean", want: bigUint(7)},
+		{input: "c 1.5 -2 prod", want: bigFloat("-3")},
+		{input: "c 1 2 3 4 prod depth", want: bigUint(1)},
+		{input: "c 1234.5 mant", want: bigFloat("1.2345")},
+		{input: "c 1234.5 xpon", want: bigUint(3)},
+		{input: "c 0.00123 mant", want: bigFloat("1.23")},
+		{input: "c 0.00123 xpon", want: bigFloat("-3")},
+		{input: "c 1.50 scale", want: bigUint(2)},
+		{input: "c 2e3 scale", want: bigUint(0)},
+		{input: "c inf mant", wantError: true},
+		{input: "c 2 fmt 2.345 round", want: bigFloat("2.34")},
+		{input: "c rounding half-up 2 fmt 2.345 round", want: bigFloat("2.35")},
+		{input: "c rounding floor 2 3 /", want: bigFloat("0.6666666666666666666666666666666666")},
+		{input: "c rounding ceiling 2 3 /", want: bigFloat("0.6666666666666666666666666666666667")},
+		{input: "c rounding foo", wantError: true},
+		{input: "c prec 10 1 3 /", want: bigFloat("0.3333333333"), precision: 40},
+		{input: "c prec 40 2 3 / 1 +", want: bigFloat("1.666666666666666666666666666666666666667"), precision: 50},
+		{input: "c prec 2 prec 1.25 1 +", want: bigFloat("2.2")},
+		{input: "c prec 0", wantError: true},
+		{input: "c prec 10001", wantError: true},
+		{input: "c 5 fac", want: bigUint(120)},
+		{input: "10 %", want: bigUint(12)},
+		{input: "2 ^", want: bigUint(144)},
+		{input: "sqr", want: bigUint(12)},
+		{input: "3 ^", want: bigUint(1728)},
+		{input: "cbr", want: bigUint(12)},
+		{input: "c 1 2 3 4 sum", want: bigUint(10)},
+		{input: "c 2 -3 min", want: bigFloat("-3")},
+		{input: "c 2 -3 max", want: bigUint(2)},
+		{input: "c 1 2 x", want: bigUint(1)},
+		{input: "x", want: bigUint(2)},
+		{input: "c", want: bigUint(0)},
+		{input: "0.25 4 *", want: bigUint(1)},
+		{input: ".2 5 *", want: bigUint(1)},
+		{input: "$2,500.00 €3,500.00 +", want: bigUint(6000)},
+		{input: "2 12345 ^", want: bigFloat(
+			"164171010688258216356020741663906501410127235530735881272116" +
+				"103087925094171390144280159034536439457734870419127140401667" +
+				"195510331085657185332721089236401193044493457116299768844344" +
+				"303479235489462436380672117015123283299131391904179287678259" +
+				"173308536738761981139958654880852234908448338817289014166774" +
+				"169869251339379828599748492918775437864739032217778051333882" +
+				"990074116246281269364933724892342134504702491040016637557429" +
+				"810893780765197418589477584716543480995722533317862352141459" +
+				"217781316266211186486157019262080414077670264642736018426998" +
+				"113523445732680856144329876972273300703392584997729207197971" +
+				"083945700345494092400147186997307012069454068489589035676979" +
+				"448169848060836924945824197706493306108258511936030341393221" +
+				"586423523264452449403781993352421885094664052270795527632721" +
+				"896121424813173522474674395886155092203404036730748474781710" +
+				"715745446135468098139831824083259647919175273503681561172684" +
+				"624283384438504776503000432241604550454374116320822227191911" +
+				"322123484085063926350606342197146407841178028071147192533942" +
+				"517270553513988142925976090769695456221159699052583533011331" +
+				"652079347093098173086975483539274464023357456484465482927479" +
+				"569437320368592222760278170306076733438801098370797675711274" +
+				"671054970711442158930561684343135774118741594506702833147396" +
+				"758825015850042983343690345185995956235143825771620543546030" +
+				"664562647854656431302644574119873820215595718618624485232422" +
+				"006575550007068883734241454686368856734496265385908809403972" +
+				"494685137741122866896719678053937285818409751670320140501843" +
+				"039224040735870096889596273419106389103662095318937990625980" +
+				"136711988237421962315266686856089505981438440850638067589321" +
+				"141759499017023839596858455548192000140085142294166987063499" +
+				"024792681334843159790936321351919859758669569200541507612099" +
+				"780909705198902176026219872201715422096090343686272984351441" +
+				"594569506778041062663266799342793856313801540959815845788584" +
+				"759033248828248561586450271172777240971795656082001848115815" +
+				"260930521663167480173886064019118572778281516735157779555888" +
+				"167787064432558595410843987446497881666288423233170060413025" +
+				"924629950477303342180149398926073618582715358742250388958231" +
+				"281694757980523791263699450732952325727664209947786063982561" +
+				"775327638504516918570101319391698412388607603742484414748268" +
+				"389669129118026878969735782286841116842656410574647607524418" +
+				"900720328045377993386279808768990376289424757351052369393977" +
+				"137871998119168898493037938756635621557623138404459266598837" +
+				"784229325799838782026060481496865561757031839002257091802876" +
+				"949248392744175669112242088439883248336310597001257385980776" +
+				"961529351198877747193531054956881808332177946751404038228718" +
+				"567911769630971553915410012677600002457982207465176670752102" +
+				"117002773980548089696530972476439694599881281812973217265853" +
+				"884727906535479745854085338851105144585481994156206497436745" +
+				"899944877732531412541279014300324594890623941145509856940982" +
+				"863769834430048120562966797907114102689879364945689860493474" +
+				"954538422367719507882513166051007352994068319251450666676648" +
+				"368200564329382998758875760414259654004977261309988267319806" +
+				"354856051784553990936610634733375984159028722378614984450255" +
+				"386315585631994503350002142910493190254825610707400589976364" +
+				"985748467955131077971641882672895854571236368282811336220769" +
+				"174784720113331269084746524204124263475054112841630933586166" +
+				"195036115696469686075600480420563557567616835633252622327172" +
+				"811002146392754445051182169805284630259703542633955126179520" +
+				"113059629914229833688535925729676778028406897316106101038469" +
+				"119090984567152591962365415039646394591503830797626339246986" +
+				"057077758611413664914168745375266786298141171496573941614387" +
+				"744125843685677063619782918759823106021054037757857761587472" +
+				"240835040580447360544029064930412569943169729238102162312218" +
+				"687930203068055400275795180972382856696655279408212344832"), precision: 34},
+		{input: "10 6144 ^", want: bigFloat("1" + strings.Repeat("0", 6144)), precision: 34},
+		{input: "10 6145 ^", want: bigFloat("1E+6145")},
+		{input: "10 1000000 ^", want: bigFloat("1E+1000000")},
+		{input: "10 1000001 ^", want: bigFloat("+Infinity")},
+		{input: "10 34 ^ 1 -", want: bigFloat("9999999999999999999999999999999999")},
+		{input: "c", want: bigUint(0)},
+		// Invalid operator should not cause changes to stack.
+		{input: "foobar", want: bigUint(0)},
+		{input: "1 2 foobar +", want: bigUint(0)},
+		{input: "1 2 foobar +", strict: true, wantError: true},
+		{input: "c", want: bigUint(0)},
+
+		// Trigonometric functions.
+		{input: "deg 90 sin", want: bigFloat("1")},
+		{input: "rad 90 PI * 180 / sin", want: bigUint(1)},
+		{input: "deg 0 cos", want: bigUint(1)},
+		{input: "rad 60 PI * 180 / cos", want: bigFloat("0.5")},
+		{input: "deg 30 tan", want: func() *decimal.Big { // Sqrt(3) / 3)
+			square := big().Quo(bigUint(1), bigUint(2))
+			z := ctx.Pow(big(), bigUint(3), square)
+			return z.Quo(z, bigUint(3))
+		}()},
+		{input: "rad 60 PI * 180 / tan", want: ctx.Pow(big(), bigUint(3), big().Quo(bigUint(1), bigUint(2)))}, // Sqrt(3)
+		{input: "deg -1 180 * PI / asin", want: big().Quo(ctx.Pi(big()), bigUint(2)).SetSignbit(true)},        // -Pi / 2
+		{input: "rad -1 asin", want: big().Quo(ctx.Pi(big()), bigUint(2)).SetSignbit(true)},                   // -Pi / 2
+		{input: "rad 0 acos", want: big().Quo(ctx.Pi(big()), bigUint(2))},                                     // Pi / 2
+		{input: "rad 1 acos", want: bigUint(0)},
+		{input: "deg 0.5 180 * PI / acos", want: big().Quo(ctx.Pi(big()), bigUint(3))}, // Pi / 3
+		{input: "rad 3 sqr atan", want: big().Quo(ctx.Pi(big()), bigUint(3))},          // Pi / 3
+		{input: "deg 1 180 * PI / atan", want: big().Quo(ctx.Pi(big()), bigUint(4))},   // Pi / 4
+
+		// Log functions
+		{input: "c E ln", want: bigUint(1)},
+		{input: "c 1000 log", want: bigUint(3)},
+
+		// Bitwise operations and base input modes.
+		{input: "0x00ff 0xff00 or", want: bigUint(0xffff)},
+		{input: "0x0ff0 and", want: bigUint(0x0ff0)},
+		{input: "0x1ee1 xor", want: bigUint(0x1111)},
+		{input: "1 lshift", want: bigUint(0x2222)},
+		{input: "1 lshift", want: bigUint(0x4444)},
+		{input: "2 rshift", want: bigUint(0x1111)},
+		{input: "0b00100010 0B01000100 015 o20 0x1000 0x2000 + + + + +", want: bigUint(12419)},
+		{input: "c", want: bigUint(0)},
+
+		// Bitwise operations and base input modes.
+		{input: "0x00ff 0xff00 or", want: bigUint(0xffff)},
+		{input: "0x0ff0 and", want: bigUint(0x0ff0)},
+		{input: "0x1ee1 xor", want: bigUint(0x1111)},
+		{input: "1 lshift", want: bigUint(0x2222)},
+		{input: "1 lshift", want: bigUint(0x4444)},
+		{input: "2 rshift", want: bigUint(0x1111)},
+		{input: "0b00100010 0B01000100 015 o20 0x1000 0x2000 + + + + +", want: bigUint(12419)},
+		{input: "d d", want: bigUint(0)},
+		{input: "-0xff -0b1010 -017 + +", want: bigFloat("-280")},
+		{input: "d", want: bigUint(0)},
+		{input: "c 0 not", want: bigUint(0xffffffffffffffff)},
+		{input: "not", want: bigUint(0)},
+		{input: "0xff00ff00ff00ff00 not", want: bigUint(0x00ff00ff00ff00ff)},
+		{input: "c wsize 8 0 not", want: bigUint(0xff)},
+		{input: "c wsize 8 0xf0 2 lshift", want: bigUint(0xc0)},
+		{input: "c wsize 16 -2 0xff and", want: bigUint(0xfe)},
+		{input: "c wsize 128 0 not", want: bigFloat("340282366920938463463374607431768211455")},
+		{input: "c wsize 128 1 100 lshift 99 rshift", want: bigUint(2)},
+		{input: "c wsize 8 0x81 1 rol", want: bigUint(0x03)},
+		{input: "c wsize 8 0x81 1 ror", want: bigUint(0xc0)},
+		{input: "c wsize 8 0x81 9 rol", want: bigUint(0x03)},
+		{input: "c wsize 16 0x1234 4 rol", want: bigUint(0x2341)},
+		{input: "c wsize 16 0x1234 4 ror", want: bigUint(0x4123)},
+		{input: "c 1 1 ror", want: bigUint(0x8000000000000000)},
+		{input: "c 1 -1 rol", wantError: true},
+		{input: "c 0xff00ff popcnt", want: bigUint(16)},
+		{input: "c wsize 8 -1 popcnt", want: bigUint(8)},
+		{input: "c 0 popcnt", want: bigUint(0)},
+		{input: "c 0x10 0 bset", want: bigUint(0x11)},
+		{input: "c 0 63 bset", want: bigUint(0x8000000000000000)},
+		{input: "c 0xff 7 bclr", want: bigUint(0x7f)},
+		{input: "c 0x10 4 btest", want: bigUint(1)},
+		{input: "c 0x10 3 btest", want: bigUint(0)},
+		{input: "c 0 64 bset", wantError: true},
+		{input: "c wsize 8 0 8 btest", wantError: true},
+		{input: "c 0 1.5 bclr", wantError: true},
+		{input: "c wsize 8 signed 0 not", want: bigFloat("-1")},
+		{input: "c wsize 8 signed 0x7f 1 lshift", want: bigFloat("-2")},
+		{input: "c wsize 8 signed -4 1 rshift", want: bigFloat("-2")},
+		{input: "c wsize 8 -4 1 rshift", want: bigUint(0x7e)},
+		{input: "c wsize 8 signed unsigned 0 not", want: bigUint(0xff)},
+		{input: "c 0x1234 bswap16", want: bigUint(0x3412)},
+		{input: "c 0x12345678 bswap32", want: bigUint(0x78563412)},
+		{input: "c 0x0102030405060708 bswap64", want: bigUint(0x0807060504030201)},
+		{input: "c 0xff bswap32", want: bigUint(0xff000000)},
+		{input: "c signed 0xff bswap16", want: bigFloat("-256")},
+		{input: "c 3 f64bits", want: bigUint(0x4008000000000000)},
+		{input: "c 0.1 f32bits", want: bigUint(0x3dcccccd)},
+		{input: "c 0x3fb999999999999a bits2f64", want: bigFloat("0.1")},
+		{input: "c 0.1 f32bits bits2f32", want: bigFloat("0.1")},
+		{input: "c 0x7ff0000000000000 bits2f64", want: big().SetInf(false)},
+		{input: "c 8 mask", want: bigUint(0xff)},
+		{input: "c 0 mask", want: bigUint(0)},
+		{input: "c 64 mask", want: bigUint(0xffffffffffffffff)},
+		{input: "c wsize 8 signed 8 mask", want: bigFloat("-1")},
+		{input: "c 65 mask", wantError: true},
+		{input: "c 4 7 maskr", want: bigUint(0xf0)},
+		{input: "c 7 4 maskr", want: bigUint(0xf0)},
+		{input: "c 3 3 maskr", want: bigUint(0x08)},
+		{input: "c 0 64 maskr", wantError: true},
+		{input: "c ibase 16 ff", want: bigUint(255)},
+		{input: "c ibase 16 dead_beef", want: bigUint(0xdeadbeef)},
+		{input: "c ibase 16 -10 0x10 +", want: bigUint(0)},
+		{input: "c ibase 2 1010 3 +", want: bigUint(13)},
+		{input: "c ibase 36 zz", want: bigUint(1295)},
+		{input: "c ibase 16 1.5", want: bigFloat("1.5")},
+		{input: "c ibase 37", wantError: true},
+		{input: "c ibase", wantError: true},
+		{input: "c obase 36 35", want: bigUint(35)},
+		{input: "c obase 1", wantError: true},
+		{input: "c 'a'", want: bigUint(97)},
+		{input: "c 'é' ' ' +", want: bigUint(265)},
+		{input: "c 'a' 'b'", want: bigUint(98)},
+		{input: "c 97 chr", want: bigUint(97)},
+		{input: "c 0x110000 chr", wantError: true},
+		{input: "c 1.5 chr", wantError: true},
+		{input: "c wsize 12", wantError: true},
+		{input: "c wsize", wantError: true},
+		{input: "c", want: bigUint(0)},
+
+		// Miscellaneous operations
+		{input: "env RPN_TEST_HEX env RPN_TEST_DEC +", want: bigFloat("18.5")},
+		{input: "env RPN_TEST_UNSET", wantError: true},
+		{input: "env RPN_TEST_NAN", wantError: true},
+		{input: "env", wantError: true},
+		{input: "c", want: bigUint(0)},
+		{input: "10 conv mile km", want: bigFloat("16.09344")},
+		{input: "conv mile", wantError: true},
+		{input: "conv mile kg", wantError: true},
+		{input: "d", want: bigUint(0)},
+		{input: "212 f2c", want: bigUint(100)},
+		{input: "c2f", want: bigUint(212)},
+		{input: "-40 f2c", want: bigFloat("-40")},
+		{input: "-10 c2f", want: bigUint(14)},
+		{input: "0 c2f", want: bigUint(32)},
+		{input: "c", want: bigUint(0)},
+		{input: "1 dup dup sum", want: bigUint(3)},
+		{input: "c", want: bigUint(0)},
+
+		// Stack operations.
+		{input: "1 2 3 4 3 roll", want: bigUint(2)},
+		{input: "d d", want: bigUint(3)},
+		{input: "d", want: bigUint(1)},
+		{input: "c 1 2 3 4 3 rolld", want: bigUint(3)},
+		{input: "d d", want: bigUint(4)},
+		{input: "d", want: bigUint(1)},
+		{input: "c 1 2 1 roll", want: bigUint(2)},
+		{input: "c 1 2 over", want: bigUint(1)},
+		{input: "d d", want: bigUint(1)},
+		{input: "c 1 over", wantError: true},
+		{input: "c 1 2 3 3 pick", want: bigUint(1)},
+		{input: "d", want: bigUint(3)},
+		{input: "1 pick", want: bigUint(3)},
+		{input: "c 1 2 3 pick", wantError: true},
+		{input: "c 1 2 -1 pick", wantError: true},
+		{input: "c 1 2 dup2", want: bigUint(2)},
+		{input: "d", want: bigUint(1)},
+		{input: "d", want: bigUint(2)},
+		{input: "depth", want: bigUint(2)},
+		{input: "c 1 dup2", wantError: true},
+		{input: "c 1 2 3 2 dupn", want: bigUint(3)},
+		{input: "d", want: bigUint(2)},
+		{input: "d", want: bigUint(3)},
+		{input: "depth", want: bigUint(3)},
+		{input: "c 1 2 3 dupn", wantError: true},
+		{input: "c 1 2 3 4 5 3 dropn", want: bigUint(2)},
+		{input: "depth", want: bigUint(2)},
+		{input: "c 1 2 2 dropn depth", want: bigUint(0)},
+		{input: "c 1 2 3 dropn", wantError: true},
+		{input: "c 1 2 0 dropn", wantError: true},
+		{input: "c 1 2 3 rev", want: bigUint(1)},
+		{input: "d", want: bigUint(2)},
+		{input: "d", want: bigUint(3)},
+		{input: "c rev depth", want: bigUint(0)},
+		{input: "c 3 -1 10 2.5 sort", want: bigUint(10)},
+		{input: "d", want: bigUint(3)},
+		{input: "d d", want: bigFloat("-1")},
+		{input: "c 3 -1 10 2.5 rsort", want: bigFloat("-1")},
+		{input: "d", want: bigFloat("2.5")},
+		{input: "d d", want: bigUint(10)},
+		{input: "c sort depth", want: bigUint(0)},
+		{input: "c 1 2 3 4 3 xchgn", want: bigUint(2)},
+		{input: "d", want: bigUint(3)},
+		{input: "d", want: bigUint(4)},
+		{input: "c 1 2 2 xchgn", want: bigUint(1)},
+		{input: "c 1 2 1 xchgn", want: bigUint(2)},
+		{input: "c 1 2 3 xchgn", wantError: true},
+		{input: "c depth", want: bigUint(0)},
+		{input: "depth", want: bigUint(1)},
+		{input: "c 2 4 6 depth", want: bigUint(3)},
+		{input: "x", want: bigUint(6)},
+		{input: "c 2 4 6 sum depth", want: bigUint(1)},
+		{input: "c 1 2 5 roll", wantError: true},
+		{input: "c 1 2 0 rolld", wantError: true},
+		{input: "c", want: bigUint(0)},
+
+		// Comparisons.
+		{input: "c 1 2 <", want: bigUint(1)},
+		{input: "c 2 2 <", want: bigUint(0)},
+		{input: "c 2 2 <=", want: bigUint(1)},
+		{input: "c 3 2 >", want: bigUint(1)},
+		{input: "c 2 3 >=", want: bigUint(0)},
+		{input: "c 2.0 2 ==", want: bigUint(1)},
+		{input: "c 2 -2 !=", want: bigUint(1)},
+		{input: "c 0 0 / 0 0 / ==", want: bigUint(0)},
+		{input: "c 0 0 / 0 0 / !=", want: bigUint(1)},
+		{input: "c 1 <", wantError: true},
+		{input: "c 2 pos", want: bigUint(1)},
+		{input: "c 0 pos", want: bigUint(0)},
+		{input: "c -2 neg", want: bigUint(1)},
+		{input: "c 0 neg", want: bigUint(0)},
+		{input: "c 0.1 nonzero", want: bigUint(1)},
+		{input: "c 0 nonzero", want: bigUint(0)},
+		{input: "c 0 0 / nonzero", want: bigUint(1)},
+		{input: "c 5 3 > ifte 10 20", want: bigUint(10)},
+		{input: "c 5 3 < ifte 10 20", want: bigUint(20)},
+		{input: "depth", want: bigUint(1)},
+		{input: "c ifte 10 20", wantError: true},
+		{input: "c 1 ifte 10", wantError: true},
+		{input: "c", want: bigUint(0)},
+
+		// Registers.
+		{input: "c 10 sto a 5 sto+ a depth", want: bigUint(2)},
+		{input: "c 10 sto a 5 sto+ a c rcl a", want: bigUint(15)},
+		{input: "c 2 sto t 3 sto* t 1 sto- t c rcl t", want: bigUint(5)},
+		{input: "c 8 sto a 2 sto/ a c rcl a", want: bigUint(4)},
+		{input: "c 4 sto+ n 1 rcl n", want: bigUint(4)},
+		{input: "c 5 sto a chs rcl a", want: bigUint(5)},
+		{input: "c 5 sto a d rcl a chs rcl a", want: bigUint(5)},
+		{input: "c rcl foo", wantError: true},
+		{input: "c sto a", wantError: true},
+		{input: "c 1 sto", wantError: true},
+		{input: "c", want: bigUint(0)},
+
+		// Stack depth limit.
+		{input: "c set maxdepth 3 1 2 3", want: bigUint(3)},
+		{input: "c set maxdepth 3 1 2 3 4", wantError: true},
+		{input: "c set maxdepth 2 1 2 dup2", wantError: true},
+		{input: "c set maxdepth 0 1 2 dup2 depth", want: bigUint(4)},
+		{input: "c set maxdepth -1", wantError: true},
+		{input: "c set maxdepth", wantError: true},
+		{input: "c set foo 1", wantError: true},
+		{input: "c", want: bigUint(0)},
+
+		// Stack display.
+		{input: "1 2 3 2 pwin p", want: bigUint(3)},
+		{input: "pflip p all", want: bigUint(3)},
+		{input: "-1 pwin", wantError: true},
+		{input: "16 pbase p 0 pbase", want: bigUint(3)},
+		{input: "37 pbase", wantError: true},
+		{input: "1 pbase", wantError: true},
+		{input: "pretty p pretty", want: bigUint(3)},
+		{input: "c", want: bigUint(0)},
+	}
+
+	t.Setenv("RPN_TEST_HEX", "0x10")
+	t.Setenv("RPN_TEST_DEC", " 2.5\n")
+	t.Setenv("RPN_TEST_NAN", "foo")
+
+	stack := &stackType{}
+
+	for _, tt := range casetests {
+		ops := newOpsType(decimal.Context128, stack)
+		ops.strict = tt.strict
+		_, err := execute(ops, tt.input)
+		if !tt.wantError {
+			if err != nil {
+				t.Fatalf("Got error %q, want no error", err)
+			}
+			precision := defaultTestPrecision
+			if tt.precision != 0 {
+				precision = tt.precision
+			}
+			got := decimal.WithPrecision(precision).Set(stack.top())
+			want := decimal.WithPrecision(precision).Set(tt.want)
+			if got.CmpTotal(want) != 0 {
+				t.Fatalf("diff: input: %s, want: %s, got: %s", tt.input, want, got)
+			}
+			continue
+		}
+
+		// Here, we want to see an error.
+		if err == nil {
+			t.Errorf("Got no error, want error")
+		}
+	}
+}
+
+func TestPercentChange(t *testing.T) {
+	// "Δ%" is the same as pct, not "%".
+	for _, input := range []string{"80 100", "100 80", "3 -1.5", "0.1 0.3"} {
+		want, err := Eval(input + " pct")
+		if err != nil {
+			t.Fatalf("input: %q, got error: %v", input, err)
+		}
+		got, err := Eval(input + " Δ%")
+		if err != nil {
+			t.Fatalf("input: %q, got error: %v", input, err)
+		}
+		if got.Cmp(want) != 0 {
+			t.Fatalf("diff: input: %q, want: %s, got: %s", input, want, got)
+		}
+	}
+}
+
+func TestAtof(t *testing.T) {
+	casetests := []struct {
+		input     string
+		want      *decimal.Big
+		wantError bool
+	}{
+		{input: "123.5", want: bigFloat("123.5")},
+		{input: "0xff", want: bigUint(0xff)},
+		{input: "0XFFFF_FFFF", want: bigUint(0xffffffff)},
+		{input: "0b1010_1010", want: bigUint(0b10101010)},
+		{input: "0b1111_1111_0000_0000", want: bigUint(0xff00)},
+		{input: "017_777", want: bigUint(017777)},
+		{input: "o17_777", want: bigUint(017777)},
+		{input: "-0xff", want: bigFloat("-255")},
+		{input: "-0b1010", want: bigFloat("-10")},
+		{input: "-017", want: bigFloat("-15")},
+		{input: "-0.5", want: bigFloat("-0.5")},
+		{input: "-12", want: bigFloat("-12")},
+		{input: "-", wantError: true},
+		{input: "--12", wantError: true},
+		{input: "-0x", wantError: true},
+		{input: "0x_ff", wantError: true},
+		{input: "0xff_", wantError: true},
+		{input: "0xf__f", wantError: true},
+		{input: "1_000", wantError: true},
+		{input: "0xfoo", wantError: true},
+		{input: "foo", wantError: true},
+	}
+	for _, tt := range casetests {
+		got, err := atof(tt.input)
+		if tt.wantError {
+			if err == nil {
+				t.Fatalf("diff: input: %q, got no error, want error", tt.input)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("diff: input: %q, got error %q, want no error", tt.input, err)
+		}
+		if got.Cmp(tt.want) != 0 {
+			t.Fatalf("diff: input: %q, want: %s, got: %s", tt.input, tt.want, got)
+		}
+	}
+}
+
+func TestFormatNumber(t *testing.T) {
+	ctx := decimal.Context128
+
+	casetests := []struct {
+		base  int
+		input *decimal.Big
+		want  string
+	}{
+		// Decimal
+		{10, bigUint(0), "0"},
+		{10, bigUint(1), "1"},
+		{10, bigUint(999), "999"},
+		{10, bigUint(1000), "1000 (1,000)"},
+		{10, bigUint(1000000), "1000000 (1,000,000)"},
+		{10, bigUint(1000000000000000), "1000000000000000 (1,000,000,000,000,000)"},
+		{10, bigFloat("10000.333333"), "10000.333333 (10,000.333333)"},
+		{10, bigFloat("-10000.333333"), "-10000.333333 (-10,000.333333)"},
+		{10, ctx.Quo(big(), bigUint(567), bigUint(999)), "0.567568"},
+		{10, ctx.Pow(big(), bigUint(2), bigUint(64)), "18446744073709551616 (18,446,744,073,709,551,616)"},
+		{10, ctx.Pow(big(), bigUint(2), bigUint(1234567890)), "Infinity"},
+		{10, ctx.Quo(big(), bigUint(0), bigUint(0)), "NaN"},
+		{10, ctx.Quo(big(), bigUint(1), bigUint(0)), "Infinity"},
+		{10, ctx.Quo(big(), bigFloat("-1"), bigUint(0)), "-Infinity"},
+		{10, bigFloat("1E+6144"), "1" + strings.Repeat("0", 6144) + " (1" + strings.Repeat(",000", 2048) + ")"},
+		{10, bigFloat("2.8242294079603478742934E+456573"), "2.824229E+456573"},
+		{10, bigFloat("-1.5E-7000"), "-1.500000E-7000"},
+
+		// Binary
+		{2, bigUint(0b11111111), "0b11111111"},
+		{2, big().Add(bigUint(0b11111111), bigFloat("0.5")), "0b11111111 (truncated from 255.5)"},
+		{2, big().Add(bigUint(0b11111111), bigFloat("0.5")).SetSignbit(true), "0b1111111111111111111111111111111111111111111111111111111100000001 (truncated from -255.5)"},
+
+		// Octal
+		{8, bigUint(0377), "0377"},
+		{8, big().Add(bigUint(0377), bigFloat("0.5")), "0377 (truncated from 255.5)"},
+		{8, big().Add(bigUint(0377), bigFloat("0.5")).SetSignbit(true), "01777777777777777777401 (truncated from -255.5)"},
+
+		// Other bases
+		{3, bigUint(5), "3#12"},
+		{36, bigUint(1295), "36#zz"},
+		{36, bigFloat("-1"), "36#3w5e11264sgsf"},
+
+		// Hex
+		{16, bigUint(0xff), "0xff"},
+		{16, big().Add(bigUint(0xff), bigFloat("0.5")).SetSignbit(true), "0xffffffffffffff01 (truncated from -255.5)"},
+		{16, bigFloat("-1"), "0xffffffffffffffff"},
+		{16, bigFloat("18446744073709551615"), "0xffffffffffffffff"},
+		{16, bigFloat("18446744073709551616"), "Invalid number: non decimal base only supports 64-bit numbers (see wsize)."},
+		{16, bigFloat("-9223372036854775809"), "Invalid number: non decimal base only supports 64-bit numbers (see wsize)."},
+		{16, bigFloat("1E+7000"), "Invalid number: non decimal base only supports 64-bit numbers (see wsize)."},
+	}
+	for _, tt := range casetests {
+		got := formatNumber(ctx, tt.input, displayType{base: tt.base, decimals: 6})
+		if got != tt.want {
+			t.Fatalf("diff: base: %d, input: %v, want: %q, got: %q", tt.base, tt.input, tt.want, got)
+		}
+		continue
+	}
+}
+
+func TestSession(t *testing.T) {
+	ops := newOpsType(decimal.Context128, &stackType{})
+	if _, err := execute(ops, "1 2.5 -0.1 label neg deg 3 fmt"); err != nil {
+		t.Fatalf("Got error %q, want no error", err)
+	}
+	var buf bytes.Buffer
+	if err := saveSession(ops, &buf); err != nil {
+		t.Fatalf("Got error %q, want no error", err)
+	}
+
+	stack := &stackType{}
+	ops = newOpsType(decimal.Context128, stack)
+	if err := loadSession(ops, &buf); err != nil {
+		t.Fatalf("Got error %q, want no error", err)
+	}
+	got := []string{}
+	for _, n := range stack.list {
+		got = append(got, n.String())
+	}
+	if strings.Join(got, " ") != "1 2.5 -0.1" || !ops.degmode || ops.decimals != 3 || ops.base != 10 {
+		t.Fatalf("diff: want stack: 1 2.5 -0.1, degmode: true, decimals: 3, base: 10, got: %s, %v, %d, %d", strings.Join(got, " "), ops.degmode, ops.decimals, ops.base)
+	}
+	if labels := strings.Join(stack.labelList(), ","); labels != ",,neg" {
+		t.Fatalf("diff: want labels: %q, got: %q", ",,neg", labels)
+	}
+
+	// Invalid sessions.
+	for _, data := range []string{"foo", `{"base": 37}`, `{"base": 10, "stack": ["foo"]}`} {
+		if err := loadSession(ops, strings.NewReader(data)); err == nil {
+			t.Fatalf("diff: session: %s, got no error, want error", data)
+		}
+	}
+}
+
+func TestUndo(t *testing.T) {
+	stack := &stackType{}
+	ops := newOpsType(decimal.Context128, stack)
+	ops.strict = true
+
+	casetests := []struct {
+		input     string
+		want      string
+		wantError bool
+	}{
+		{input: "undo", wantError: true},
+		{input: "1 2", want: "1 2"},
+		{input: "+", want: "3"},
+		{input: "4 c", want: ""},
+		{input: "undo", want: "3"},
+		{input: "undo", want: "1 2"},
+		{input: "redo", want: "3"},
+		{input: "redo", want: ""},
+		{input: "redo", wantError: true},
+		{input: "undo undo", want: "1 2"},
+		{input: "5", want: "1 2 5"},
+		{input: "redo", wantError: true},
+		{input: "foo", wantError: true},
+		{input: "p", want: "1 2 5"},
+		{input: "undo", want: "1 2"},
+		{input: "deg 3 fmt", want: "1 2"},
+		{input: "undo", want: "1 2"},
+		// Operations changing numbers in place.
+		{input: "5", want: "1 2 5"},
+		{input: "chs", want: "1 2 -5"},
+		{input: "undo", want: "1 2 5"},
+		// Registers and input base.
+		{input: "sto a", want: "1 2 5"},
+		{input: "undo", want: "1 2 5"},
+		{input: "rcl a", wantError: true},
+		{input: "ibase 16", want: "1 2 5"},
+		{input: "undo", want: "1 2 5"},
+		{input: "ff", wantError: true},
+		// Failing lines leave registers and modes untouched.
+		{input: "5 sto b foo", wantError: true},
+		{input: "rcl b", wantError: true},
+		{input: "ibase 16 foo", wantError: true},
+		{input: "ff", wantError: true},
+		{input: "deg 3 fmt foo", wantError: true},
+		{input: "p", want: "1 2 5"},
+	}
+	for _, tt := range casetests {
+		_, err := execute(ops, tt.input)
+		if tt.wantError {
+			if err == nil {
+				t.Fatalf("diff: input: %s, got no error, want error", tt.input)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("diff: input: %s, got error %q, want no error", tt.input, err)
+		}
+		got := []string{}
+		for _, n := range stack.list {
+			got = append(got, n.String())
+		}
+		if strings.Join(got, " ") != tt.want {
+			t.Fatalf("diff: input: %s, want stack: %q, got: %q", tt.input, tt.want, strings.Join(got, " "))
+		}
+	}
+	if ops.degmode || ops.decimals != 6 {
+		t.Fatalf("diff: want modes restored by undo, got degmode: %v, decimals: %d", ops.degmode, ops.decimals)
+	}
+}
+
+func TestMacros(t *testing.T) {
+	stack := &stackType{}
+	ops := newOpsType(decimal.Context128, stack)
+	ops.strict = true
+
+	casetests := []struct {
+		input     string
+		want      *decimal.Big
+		wantError bool
+	}{
+		{input: "1000 10 times [ 1.05 * ]", want: bigFloat("1628.894626777441406250")},
+		{input: "c 2 3 times dup depth", want: bigUint(4)},
+		{input: "c 1 0 times [ 2 * ]", want: bigUint(1)},
+		{input: "def sq [ dup * ]", want: bigUint(1)},
+		{input: "c 3 sq", want: bigUint(9)},
+		{input: "def sq4 [ sq sq ] 2 sq4", want: bigUint(16)},
+		{input: "c 2 2 times [ 2 times sq ]", want: bigUint(65536)},
+		{input: "def sq [ 2 ^ ] 5 sq", want: bigUint(25)},
+		{input: "c 4 9 16 map sqr", want: bigUint(4)},
+		{input: "d", want: bigUint(3)},
+		{input: "c 1 2 3 map [ 10 * 1 + ] sum", want: bigUint(63)},
+		{input: "c 4 9 map sq sum", want: bigUint(97)},
+		{input: "c map sqr depth", want: bigUint(0)},
+		{input: "c 1 2 map dup", wantError: true},
+		{input: "c 1 2 map d", wantError: true},
+		{input: "c 1 2 map foo", wantError: true},
+		{input: "c 1 2 3 4 fold *", want: bigUint(24)},
+		{input: "depth", want: bigUint(1)},
+		{input: "c 1 2 3 fold -", want: bigFloat("-4")},
+		{input: "c 3 10 -2 7 fold max", want: bigUint(10)},
+		{input: "c 3 10 -2 7 fold min", want: bigFloat("-2")},
+		{input: "c 1 2 3 fold [ dup * + ]", want: bigUint(14)},
+		{input: "c 5 fold *", want: bigUint(5)},
+		{input: "c fold *", wantError: true},
+		{input: "c 1 2 fold [ 1 ]", wantError: true},
+		{input: "c 3 -1 0 7 -5 filter [ 0 > ] depth", want: bigUint(2)},
+		{input: "d", want: bigUint(7)},
+		{input: "c 3 -1 0 7 -5 0 filter != sum", want: bigUint(4)},
+		{input: "c 3 -1 0 7 -5 filter pos sum", want: bigUint(10)},
+		{input: "c 3 -1 0 7 -5 filter neg sum", want: bigFloat("-6")},
+		{input: "c 3 -1 0 7 -5 filter nonzero depth", want: bigUint(4)},
+		{input: "c 3 -1 0 7 -5 3 filter >= sum", want: bigUint(10)},
+		{input: "c 1 2 3 filter [ d 0 ] depth", want: bigUint(0)},
+		{input: "c 1 0 2 filter [ ] depth", want: bigUint(2)},
+		{input: "c filter >", wantError: true},
+		{input: "c 1 2 filter [ dup ]", wantError: true},
+		{input: "c 0 5 solve [ dup * 2 - ]", want: bigFloat("1.414213562373095048801688724209698")},
+		{input: "c 5 0 solve [ dup * 2 - ] depth", want: bigUint(1)},
+		{input: "c 3 4 solve sin", want: bigFloat("3.141592653589793238462643383279502")},
+		{input: "c 0 2 solve [ 1 - ]", want: bigUint(1)},
+		{input: "c 0 1 solve [ 1 + ]", wantError: true},
+		{input: "c 0 1 solve [ dup ]", wantError: true},
+		{input: "c 1 solve sin", wantError: true},
+		{input: "c 3 deriv [ dup * ]", want: bigUint(6)},
+		{input: "c 1 deriv exp", want: bigFloat("2.718281828459045235360287471352662")},
+		{input: "c 2 deriv ln", want: bigFloat("0.5")},
+		{input: "c 0 deriv [ d 5 ]", want: bigUint(0)},
+		{input: "c deriv sin", wantError: true},
+		{input: "c 1 deriv [ dup ]", wantError: true},
+		{input: "c 7 1 ifte [ 2 * ] [ 3 * ]", want: bigUint(14)},
+		{input: "c 7 0 ifte [ 2 * ] [ 3 * ]", want: bigUint(21)},
+		{input: "c 7 -1 ifte sqr [ ]", want: bigFloat("2.645751311064590590501615753639260")},
+		{input: "c def absdiff [ - dup 0 < ifte chs [ ] ] 3 8 absdiff", want: bigUint(5)},
+		{input: "c 7 1 ifte [ 2 * ]", wantError: true},
+		{input: "c 7 1 ifte [ foo ] [ 3 * ]", wantError: true},
+		{input: "depth", want: bigUint(1)},
+		{input: "c 1 10 sigma [ dup * ]", want: bigUint(385)},
+		{input: "c 7 1 100 sigma [ ]", want: bigUint(5050)},
+		{input: "depth", want: bigUint(2)},
+		{input: "c 0 20 sigma [ fac inv ]", want: bigFloat("2.718281828459045235339784490666416")},
+		{input: "c 5 1 sigma [ ]", want: bigUint(0)},
+		{input: "c 1.5 3 sigma [ ]", wantError: true},
+		{input: "c 1 sigma [ ]", wantError: true},
+		{input: "def loop [ loop ] loop", wantError: true},
+		{input: "c 1 2 times [ foo ]", wantError: true},
+		{input: "c 1 2 times [ dup", wantError: true},
+		{input: "c 1 -1 times dup", wantError: true},
+		{input: "c times dup", wantError: true},
+		{input: "def dup [ 1 ]", wantError: true},
+		{input: "def 10 [ 1 ]", wantError: true},
+		{input: "def times", wantError: true},
+		{input: "c 1", want: bigUint(1)},
+	}
+	for _, tt := range casetests {
+		_, err := execute(ops, tt.input)
+		if tt.wantError {
+			if err == nil {
+				t.Fatalf("diff: input: %s, got no error, want error", tt.input)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("diff: input: %s, got error %q, want no error", tt.input, err)
+		}
+		if stack.top().Cmp(tt.want) != 0 {
+			t.Fatalf("diff: input: %s, want: %s, got: %s", tt.input, tt.want, stack.top())
+		}
+	}
+	if ops.nesting != 0 {
+		t.Fatalf("diff: want nesting level 0 after errors, got %d", ops.nesting)
+	}
+}
+
+func TestDerivPrecision(t *testing.T) {
+	t.Cleanup(func() { userOps = nil })
+
+	crash := func(_ []*decimal.Big) ([]*decimal.Big, error) {
+		panic("boom")
+	}
+	if err := RegisterOp(Op{Name: "crash", Desc: "Panic", NumArgs: 1, Fn: crash}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Failing macros don't leave the raised precision behind.
+	c := New()
+	for _, input := range []string{"1 deriv [ dup ]", "1 deriv [ foo ]", "1 deriv crash"} {
+		if err := c.Eval(input); err == nil {
+			t.Fatalf("input: %q, got no error, want error", input)
+		}
+		if got := c.Prompt("%P"); got != "34" {
+			t.Fatalf("diff: input: %q, want precision: 34, got: %s", input, got)
+		}
+	}
+	if err := c.Eval("1 3 /"); err != nil {
+		t.Fatal(err)
+	}
+	if x, _ := c.Top(); x.Cmp(bigFloat("0.3333333333333333333333333333333333")) != 0 {
+		t.Fatalf("diff: want 34 digits, got: %s", x)
+	}
+}
+
+func TestDefs(t *testing.T) {
+	fname := filepath.Join(t.TempDir(), "defs.rpn")
+
+	ops := newOpsType(decimal.Context128, &stackType{})
+	ops.strict = true
+	if _, err := execute(ops, "def sq [ dup * ] def sq4 [ sq sq ] 85.5 sto rate -2 sto fx c"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := execute(ops, "savedefs "+fname); err != nil {
+		t.Fatalf("Got error %q, want no error", err)
+	}
+	data, err := os.ReadFile(fname)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "# rpn definitions (load with \"loaddefs\")\ndef sq [ dup * ]\ndef sq4 [ sq sq ]\n-2 sto fx d\n85.5 sto rate d\n"
+	if string(data) != want {
+		t.Fatalf("diff: want: %q, got: %q", want, data)
+	}
+
+	stack := &stackType{}
+	ops = newOpsType(decimal.Context128, stack)
+	ops.strict = true
+	if _, err := execute(ops, "7 loaddefs "+fname); err != nil {
+		t.Fatalf("Got error %q, want no error", err)
+	}
+	if len(stack.list) != 1 || stack.top().Cmp(bigUint(7)) != 0 {
+		t.Fatalf("diff: want stack with 7 only, got: %v", stack.list)
+	}
+	if _, err := execute(ops, "c 3 sq4 rcl rate rcl fx * *"); err != nil {
+		t.Fatalf("Got error %q, want no error", err)
+	}
+	if stack.top().Cmp(bigFloat("-13851")) != 0 {
+		t.Fatalf("diff: want: -13851, got: %s", stack.top())
+	}
+
+	// Registers that can't be read back are skipped.
+	var buf bytes.Buffer
+	ops.out = &buf
+	if _, err := execute(ops, "1 0 / sto inf -1 ln sto nan savedefs "+fname); err != nil {
+		t.Fatalf("Got error %q, want no error", err)
+	}
+	if want := "Note: register inf (Infinity) not saved\nNote: register nan (NaN) not saved\n"; buf.String() != want {
+		t.Fatalf("diff: want: %q, got: %q", want, buf.String())
+	}
+	ops = newOpsType(decimal.Context128, &stackType{})
+	ops.strict = true
+	if _, err := execute(ops, "loaddefs "+fname+" rcl rate"); err != nil {
+		t.Fatalf("Got error %q, want no error", err)
+	}
+	if _, err := execute(ops, "rcl nan"); err == nil {
+		t.Fatalf("Got no error for a skipped register, want error")
+	}
+
+	if _, err := execute(ops, "loaddefs "+fname+".missing"); err == nil {
+		t.Fatalf("Got no error for missing file, want error")
+	}
+
+	// Definitions are always read in decimal.
+	for _, ibase := range []int{2, 16} {
+		stack := &stackType{}
+		ops := newOpsType(decimal.Context128, stack)
+		ops.strict = true
+		if _, err := execute(ops, fmt.Sprintf("ibase %d loaddefs %s", ibase, fname)); err != nil {
+			t.Fatalf("ibase %d: got error %q, want no error", ibase, err)
+		}
+		if ops.ibase != ibase {
+			t.Fatalf("diff: ibase %d not restored, got: %d", ibase, ops.ibase)
+		}
+		if _, err := execute(ops, "rcl rate rcl fx *"); err != nil {
+			t.Fatalf("ibase %d: got error %q, want no error", ibase, err)
+		}
+		if stack.top().Cmp(bigFloat("-171")) != 0 {
+			t.Fatalf("diff: ibase %d: want: -171, got: %s", ibase, stack.top())
+		}
+	}
+}
+
+func TestAns(t *testing.T) {
+	stack := &stackType{}
+	ops := newOpsType(decimal.Context128, stack)
+	ops.strict = true
+
+	casetests := []struct {
+		input     string
+		want      *decimal.Big
+		wantError bool
+	}{
+		{input: "ans", wantError: true},
+		{input: "1 2 +", want: bigUint(3)},
+		{input: "10 *", want: bigUint(30)},
+		{input: "ans ans2 +", want: bigUint(33)},
+		{input: "c ans3", want: bigUint(3)},
+		{input: "ans1 d ans", want: bigUint(33)},
+		{input: "ans5", wantError: true},
+		{input: "ans0", wantError: true},
+	}
+	for _, tt := range casetests {
+		_, err := execute(ops, tt.input)
+		if tt.wantError {
+			if err == nil {
+				t.Fatalf("diff: input: %s, got no error, want error", tt.input)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("diff: input: %s, got error %q, want no error", tt.input, err)
+		}
+		if stack.top().Cmp(tt.want) != 0 {
+			t.Fatalf("diff: input: %s, want: %s, got: %s", tt.input, tt.want, stack.top())
+		}
+	}
+}
+
+func TestWorkspaces(t *testing.T) {
+	stack := &stackType{}
+	ops := newOpsType(decimal.Context128, stack)
+	ops.strict = true
+
+	casetests := []struct {
+		input     string
+		want      string
+		wantWS    string
+		wantError bool
+	}{
+		{input: "1 2", want: "1 2", wantWS: "default"},
+		{input: "ws create budget 10", want: "10", wantWS: "budget"},
+		{input: "ws create budget", wantError: true},
+		{input: "ws switch default +", want: "3", wantWS: "default"},
+		{input: "ws switch taxes", wantError: true},
+		{input: "ws switch budget undo", want: "", wantWS: "budget"},
+		{input: "redo", want: "10", wantWS: "budget"},
+		{input: "ws switch budget 20 +", want: "30", wantWS: "budget"},
+		{input: "undo", want: "10", wantWS: "budget"},
+		{input: "ws switch default foo", wantError: true},
+		{input: "p", want: "3", wantWS: "default"},
+		{input: "undo", want: "1 2", wantWS: "default"},
+		{input: "ws delete default", wantError: true},
+		{input: "ws delete budget", want: "1 2", wantWS: "default"},
+		{input: "ws switch budget", wantError: true},
+		{input: "ws rename budget", wantError: true},
+		{input: "ws create", wantError: true},
+	}
+	for _, tt := range casetests {
+		_, err := execute(ops, tt.input)
+		if tt.wantError {
+			if err == nil {
+				t.Fatalf("diff: input: %s, got no error, want error", tt.input)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("diff: input: %s, got error %q, want no error", tt.input, err)
+		}
+		got := []string{}
+		for _, n := range stack.list {
+			got = append(got, n.String())
+		}
+		if strings.Join(got, " ") != tt.want || ops.workspace != tt.wantWS {
+			t.Fatalf("diff: input: %s, want stack: %q, workspace: %q, got: %q, %q", tt.input, tt.want, tt.wantWS, strings.Join(got, " "), ops.workspace)
+		}
+	}
+}
+
+func TestExpandHistory(t *testing.T) {
+	history := []string{"1 2 +", "10 *", "p"}
+
+	casetests := []struct {
+		input     string
+		want      string
+		wantError bool
+	}{
+		{input: "1 2 3", want: "1 2 3"},
+		{input: "!!", want: "p"},
+		{input: "!1", want: "1 2 +"},
+		{input: "!1 !2 +", want: "1 2 + 10 * +"},
+		{input: "!3!!", want: "pp"},
+		{input: "!0", wantError: true},
+		{input: "!4", wantError: true},
+		{input: "!", want: "!"},
+	}
+	for _, tt := range casetests {
+		got, err := expandHistory(tt.input, history)
+		if tt.wantError {
+			if err == nil {
+				t.Fatalf("diff: input: %q, got no error, want error", tt.input)
+			}
+			continue
+		}
+		if err != nil || got != tt.want {
+			t.Fatalf("diff: input: %q, want: %q, got: %q (error: %v)", tt.input, tt.want, got, err)
+		}
+	}
+
+	if _, err := expandHistory("!!", nil); err == nil {
+		t.Fatalf("Got no error for empty history, want error")
+	}
+}
+
+func TestCheckScript(t *testing.T) {
+	fname := "script.rpn"
+	script := "# Comment: foo bar\n1 2 +\np all p\n3 foo 0xfoo\n0x10 1.2.3 sum q\ndef sq [ dup * ] 3 sq\n2 times [ sq bar ]\n3 times [ dup\n" +
+		"1 2 ]\n3 [ 4\nibase 16 ff 1a +\nibase 10 ff\n"
+
+	ops := newOpsType(decimal.Context128, &stackType{})
+	problems, err := checkScript(ops, strings.NewReader(script), fname)
+	if err != nil {
+		t.Fatalf("Got error %q, want no error", err)
+	}
+	want := []string{
+		fname + `:4: not a number or operator: "foo"`,
+		fname + `:4: not a number or operator: "0xfoo"`,
+		fname + `:5: not a number or operator: "1.2.3"`,
+		fname + `:7: not a number or operator: "bar"`,
+		fname + `:8: times: missing "]" at the end of block`,
+		fname + `:9: unexpected "]"`,
+		fname + `:10: missing "]" at the end of block`,
+		fname + `:12: not a number or operator: "ff"`,
+	}
+	if strings.Join(problems, "\n") != strings.Join(want, "\n") {
+		t.Fatalf("diff: want: %q, got: %q", want, problems)
+	}
+	if len(ops.stack.list) != 0 {
+		t.Fatalf("diff: checkScript modified the stack")
+	}
+}
+
+func TestExtractNumbers(t *testing.T) {
+	casetests := []struct {
+		input string
+		ibase int
+		want  []string
+	}{
+		{"1.5\t2\t3\n4\n", 10, []string{"1.5", "2", "3", "4"}},
+		{"Total\t$1,234.50\r\n", 10, []string{"1234.50"}},
+		{"# comment\n-7 0xff\n", 10, []string{"-7", "255"}},
+		{"ff 10", 16, []string{"255", "16"}},
+		{"no numbers here", 10, []string{}},
+	}
+	for _, tt := range casetests {
+		got := []string{}
+		for _, n := range extractNumbers(tt.input, tt.ibase) {
+			got = append(got, n.String())
+		}
+		if strings.Join(got, " ") != strings.Join(tt.want, " ") {
+			t.Fatalf("diff: input: %q, want: %q, got: %q", tt.input, tt.want, got)
+		}
+	}
+}
+
+func TestInfixToRPN(t *testing.T) {
+	casetests := []struct {
+		input     string
+		want      string
+		wantError bool
+	}{
+		{input: "(2+3)*4^2", want: "2 3 + 4 2 ^ *"},
+		{input: "2^3^2", want: "2 3 2 ^ ^"},
+		{input: "10-4-3", want: "10 4 - 3 -"},
+		{input: "-2^2", want: "2 2 ^ chs"},
+		{input: "-2*3", want: "2 chs 3 *"},
+		{input: "7 % 3 + +1", want: "7 3 mod 1 +"},
+		{input: "sqr(2) * max(3, 0x10)", want: "2 sqr 3 0x10 max *"},
+		{input: "2*PI", want: "2 PI *"},
+		{input: "1e-3+1E+2", want: "1e-3 1E+2 +"},
+		{input: "0xe-1", want: "0xe 1 -"},
+		{input: "(2+3", wantError: true},
+		{input: "2+3)", wantError: true},
+		{input: "2 3 +", wantError: true},
+		{input: "max(1)", wantError: true},
+		{input: "foo(1)", wantError: true},
+		{input: "2 + x", wantError: true},
+		{input: "2 $ 3", wantError: true},
+		{input: "", wantError: true},
+	}
+	ops := newOpsType(decimal.Context128, &stackType{})
+	for _, tt := range casetests {
+		got, err := ops.infixToRPN(tt.input)
+		if tt.wantError {
+			if err == nil {
+				t.Fatalf("input: %q, got no error, want error", tt.input)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("input: %q, got error: %v", tt.input, err)
+		}
+		if strings.Join(got, " ") != tt.want {
+			t.Fatalf("diff: input: %q, want: %q, got: %q", tt.input, tt.want, strings.Join(got, " "))
+		}
+	}
+}
+
+func TestVersion(t *testing.T) {
+	defer func(b string) { Build = b }(Build)
+
+	Build = ""
+	if got := Version(); got != "no version info" {
+		t.Fatalf("diff: want: %q, got: %q", "no version info", got)
+	}
+	Build = "1.2.3"
+	if got := Version(); got != "v1.2.3" {
+		t.Fatalf("diff: want: %q, got: %q", "v1.2.3", got)
+	}
+}
+
+func TestFormatPrompt(t *testing.T) {
+	casetests := []struct {
+		input string
+		want  string
+	}{
+		{"[%b,%a,%n]> ", "[hex,deg,2]> "},
+		{"%w %p/%P %i%%> ", "default 6/34 10%> "},
+		{"%x> ", "%x> "},
+	}
+	stack := &stackType{}
+	ops := newOpsType(decimal.Context128, stack)
+	stack.push(bigUint(1))
+	stack.push(bigUint(2))
+	ops.base = 16
+	ops.degmode = true
+
+	for _, tt := range casetests {
+		if got := prompt(ops, tt.input); got != tt.want {
+			t.Fatalf("diff: input: %q, want: %q, got: %q", tt.input, tt.want, got)
+		}
+	}
+}
+
+func TestPrintResult(t *testing.T) {
+	casetests := []struct {
+		format string
+		want   string
+	}{
+		{"", "1234.5\n"},
+		{"%.2f", "1234.50\n"},
+		{"{{.Raw}} {{.Human}}", "1234.5 1,234.5\n"},
+		{"{{.Hex}} {{.Oct}} {{.Bin}}", "0x4d2 (truncated from 1234.5) 02322 (truncated from 1234.5) 0b10011010010 (truncated from 1234.5)\n"},
+		{"{{len .Stack}}: {{index .Stack 0}}", "2: 10\n"},
+	}
+	for _, tt := range casetests {
+		stack := &stackType{}
+		ops := newOpsType(decimal.Context128, stack)
+		stack.push(bigUint(10))
+		stack.push(bigFloat("1234.5"))
+
+		buf := &bytes.Buffer{}
+		if err := printResult(buf, ops, tt.format); err != nil {
+			t.Fatalf("format: %q, got error: %v", tt.format, err)
+		}
+		if buf.String() != tt.want {
+			t.Fatalf("diff: format: %q, want: %q, got: %q", tt.format, tt.want, buf.String())
+		}
+	}
+}
+
+func TestPrettyNumber(t *testing.T) {
+	ctx := decimal.Context128
+
+	casetests := []struct {
+		input *decimal.Big
+		want  string
+	}{
+		{bigUint(0), "0"},
+		{bigUint(999), "999"},
+		{bigUint(1000), "1\u2009000"},
+		{bigFloat("-1234567.25"), "−1\u2009234\u2009567.25"},
+		{bigFloat("0.0001"), "0.0001"},
+		{bigFloat("0.00001"), "1×10⁻⁵"},
+		{bigFloat("-0.0000125"), "−1.25×10⁻⁵"},
+		{bigFloat("1.5e23"), "1.5×10²³"},
+		{bigFloat("6.02214154e23"), "6.022142×10²³"},
+		{bigUint(1000000000000), "1×10¹²"},
+		{ctx.Quo(big(), bigUint(0), bigUint(0)), "NaN"},
+		{ctx.Quo(big(), bigUint(1), bigUint(0)), "∞"},
+		{ctx.Quo(big(), bigFloat("-1"), bigUint(0)), "−∞"},
+	}
+	for _, tt := range casetests {
+		got := prettyNumber(tt.input, 6)
+		if got != tt.want {
+			t.Fatalf("diff: input: %v, want: %q, got: %q", tt.input, tt.want, got)
+		}
+	}
+}
+
+func TestSINumber(t *testing.T) {
+	casetests := []struct {
+		input *decimal.Big
+		want  string
+	}{
+		{bigUint(0), "0"},
+		{bigUint(999), "999"},
+		{bigUint(1500000), "1.5M"},
+		{bigFloat("3.2e9"), "3.2G"},
+		{bigFloat("1234.5678"), "1.234568k"},
+		{bigFloat("0.00045"), "450µ"},
+		{bigFloat("-0.0015"), "-1.5m"},
+		{bigFloat("1e30"), "1Q"},
+		{bigFloat("1e40"), "10,000,000,000,000,000,000,000,000,000,000,000,000,000"},
+	}
+	for _, tt := range casetests {
+		got := siNumber(tt.input, 6)
+		if got != tt.want {
+			t.Fatalf("diff: input: %v, want: %q, got: %q", tt.input, tt.want, got)
+		}
+	}
+}
+
+func TestNearestFraction(t *testing.T) {
+	casetests := []struct {
+		input     *decimal.Big
+		maxDen    int64
+		want      string
+		wantExact bool
+	}{
+		{bigFloat("2.25"), 64, "9/4", true},
+		{bigFloat("-0.75"), 64, "-3/4", true},
+		{bigFloat("0.3333333333"), 1000, "1/3", false},
+		{bigFloat("3.14159265358979"), 1000, "355/113", false},
+		{bigFloat("3.14159265358979"), 64, "201/64", false},
+		{bigFloat("0.41"), 64, "25/61", false},
+		{bigFloat("0.001"), 16, "0/1", false},
+	}
+	for _, tt := range casetests {
+		num, den, exact := nearestFraction(tt.input, tt.maxDen)
+		got := num.String() + "/" + den.String()
+		if got != tt.want || exact != tt.wantExact {
+			t.Fatalf("diff: input: %v, want: %q (exact=%v), got: %q (exact=%v)", tt.input, tt.want, tt.wantExact, got, exact)
+		}
+	}
+}
+
+func TestSeparators(t *testing.T) {
+	casetests := []struct {
+		input string
+		style string
+		want  string
+	}{
+		{"1,234,567.25", "comma", "1,234,567.25"},
+		{"1,234,567.25", "period", "1.234.567,25"},
+		{"-1,234,567", "space", "-1 234 567"},
+		{"10,000,000", "underscore", "10_000_000"},
+		{"123,456,789.5", "indian", "12,34,56,789.5"},
+		{"-100,000", "indian", "-1,00,000"},
+		{"999", "indian", "999"},
+	}
+	for _, tt := range casetests {
+		got := separators[tt.style].apply(tt.input)
+		if got != tt.want {
+			t.Fatalf("diff: input: %q, style: %s, want: %q, got: %q", tt.input, tt.style, tt.want, got)
+		}
+	}
+}
+
+func TestBytesNumber(t *testing.T) {
+	ctx := decimal.Context128
+
+	casetests := []struct {
+		input *decimal.Big
+		want  string
+	}{
+		{bigUint(0), "0 B"},
+		{bigUint(512), "512 B"},
+		{bigUint(1536), "1.5 KiB"},
+		{bigUint(1509949440), "1.41 GiB"},
+		{bigFloat("-2048"), "-2 KiB"},
+		{bigFloat("1208925819614629174706176"), "1 YiB"},
+		{bigFloat("1e30"), "827180.61 YiB"},
+	}
+	for _, tt := range casetests {
+		got := bytesNumber(ctx, tt.input)
+		if got != tt.want {
+			t.Fatalf("diff: input: %v, want: %q, got: %q", tt.input, tt.want, got)
+		}
+	}
+}
+
+func TestGroupDigits(t *testing.T) {
+	casetests := []struct {
+		input string
+		size  int
+		want  string
+	}{
+		{"0xdeadbeef", 4, "0xdead_beef"},
+		{"0b1111111100000000", 4, "0b1111_1111_0000_0000"},
+		{"-0x12345", 4, "-0x1_2345"},
+		{"01234567", 3, "01_234_567"},
+		{"0x1234", 4, "0x1234"},
+		{"0xfff (truncated from 4095.5)", 2, "0xf_ff (truncated from 4095.5)"},
+		{"0", 3, "0"},
+		{"5#12422311", 4, "5#1242_2311"},
+		{"Invalid number: non decimal base only supports 64-bit numbers (see wsize).", 4, "Invalid number: non decimal base only supports 64-bit numbers (see wsize)."},
+	}
+	for _, tt := range casetests {
+		got := groupDigits(tt.input, tt.size)
+		if got != tt.want {
+			t.Fatalf("diff: input: %q, want: %q, got: %q", tt.input, tt.want, got)
+		}
+	}
+}
+
+func TestUnits(t *testing.T) {
+	db := newUnitsDB(decimal.Context128)
+
+	// Definitions in GNU units format, including unsupported features.
+	data := `
+!locale en_US
+furlong      220 yard   # Comment
+fortnight    14 day
+furlongsperfortnight \
+             furlong/fortnight
+tempF(x) units=[1;K] (x+(-32)) degF + stdtemp ; (tempF+(-stdtemp))/degF + 32
+!endlocale
+smoot        67 in
+`
+	if err := db.load(strings.NewReader(data)); err != nil {
+		t.Fatal(err)
+	}
+
+	casetests := []struct {
+		input     *decimal.Big
+		from      string
+		to        string
+		want      *decimal.Big
+		wantError bool
+	}{
+		{input: bigUint(10), from: "mile", to: "km", want: bigFloat("16.09344")},
+		{input: bigUint(1), from: "hour", to: "ms", want: bigUint(3600000)},
+		{input: bigUint(2), from: "m3", to: "liters", want: bigUint(2000)},
+		{input: bigUint(60), from: "mph", to: "m/s", want: bigFloat("26.8224")},
+		{input: bigUint(1), from: "GiB", to: "MB", want: bigFloat("1073.741824")},
+		{input: bigUint(1), from: "kWh", to: "J", want: bigUint(3600000)},
+		{input: bigUint(1), from: "N", to: "kg*m/s^2", want: bigUint(1)},
+		{input: bigUint(1), from: "furlong", to: "m", want: bigFloat("201.168")},
+		{input: bigUint(1), from: "furlongsperfortnight", to: "m/s", want: bigFloat("0.0001663095238095238095238095238095238")},
+		{input: bigUint(10), from: "smoots", to: "ft", want: bigFloat("55.83333333333333333333333333333333")},
+		{input: bigUint(1), from: "m", to: "kg", wantError: true},
+		{input: bigUint(1), from: "foobar", to: "m", wantError: true},
+		{input: bigUint(1), from: "tempF", to: "K", wantError: true},
+	}
+	for _, tt := range casetests {
+		got, err := db.convert(tt.input, tt.from, tt.to)
+		if tt.wantError {
+			if err == nil {
+				t.Fatalf("diff: %s -> %s: got no error, want error", tt.from, tt.to)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("diff: %s -> %s: got error %q, want no error", tt.from, tt.to, err)
+		}
+		got = decimal.WithPrecision(defaultTestPrecision).Set(got)
+		want := decimal.WithPrecision(defaultTestPrecision).Set(tt.want)
+		if got.Cmp(want) != 0 {
+			t.Fatalf("diff: %s -> %s: want: %s, got: %s", tt.from, tt.to, want, got)
+		}
+	}
+}
+
+func TestParseStackColors(t *testing.T) {
+	casetests := []struct {
+		spec      string
+		wantError bool
+	}{
+		{spec: ""},
+		{spec: "x=1;36"},
+		{spec: "x=1;36:y=36:idx=33:val=0:chg=1;31"},
+		{spec: "chg="},
+		{spec: "x=1;36::y=36"},
+		{spec: "res=34:err=1;31:warn=:bold=4"},
+		{spec: "foo=1", wantError: true},
+		{spec: "x", wantError: true},
+		{spec: "x=red", wantError: true},
+		{spec: "x=1;;36", wantError: true},
+	}
+	for _, tt := range casetests {
+		_, err := parseStackColors(stackColorsType{}, tt.spec)
+		if tt.wantError != (err != nil) {
+			t.Fatalf("diff: spec: %q, want error: %v, got: %v", tt.spec, tt.wantError, err)
+		}
+	}
+
+	// Unset keys must keep their previous values and empty values disable colors.
+	colors, _ := parseStackColors(stackColors, "x=36:chg=")
+	if colors.x == nil || colors.changed != nil || colors.y != stackColors.y {
+		t.Fatalf("diff: unexpected colors after parsing: %+v", colors)
+	}
+}
+
+func Example_stackPrint() {
+	stack := &stackType{}
+	stack.push(bigUint(1), bigUint(2), bigUint(3), bigUint(4))
+
+	stack.print(os.Stdout, decimal.Context128, displayType{base: 10, decimals: 6, window: 2}, false)
+	stack.print(os.Stdout, decimal.Context128, displayType{base: 10, decimals: 6, window: 3, bottomUp: true}, false)
+	stack.print(os.Stdout, decimal.Context128, displayType{base: 10, decimals: 6, window: 3}, true)
+	// Output:
+	// ===== Stack =====
+	//  x: 4
+	//  y: 3
+	//     (2 more, use "p all" to see all)
+	// ===== Stack =====
+	//     (1 more, use "p all" to see all)
+	//  1: 2
+	//  y: 3
+	//  x: 4
+	// ===== Stack =====
+	//  x: 4
+	//  y: 3
+	//  1: 2
+	//  0: 1
+}
+
+func Example_stackPrintLabels() {
+	stack := &stackType{}
+	ops := newOpsType(decimal.Context128, stack)
+	ops.strict = true
+
+	for _, line := range []string{"100 label rent 20 label food 3", "x", "p", "+ 1 label -", "p", "foo", "p"} {
+		execute(ops, line)
+	}
+	// Output:
+	// ===== Stack =====
+	//  x: 20 (food)
+	//  y: 3
+	//  0: 100 (rent)
+	// ===== Stack =====
+	//  x: 1
+	//  y: 23
+	//  0: 100 (rent)
+	// ===== Stack =====
+	//  x: 1
+	//  y: 23
+	//  0: 100 (rent)
+}
+
+func TestLabels(t *testing.T) {
+	stack := &stackType{}
+	ops := newOpsType(decimal.Context128, stack)
+	ops.strict = true
+
+	casetests := []struct {
+		input string
+		want  string // Labels of all stack entries, x last
+	}{
+		{input: "5 label rent dup", want: "rent,"},
+		{input: "over", want: "rent,,"},
+		{input: "c 7 label seven sto a rcl a", want: "seven,"},
+		{input: "x", want: ",seven"},
+		{input: "c", want: ""},
+		{input: "undo", want: ",seven"},
+		{input: "ws create other 1 label one", want: "one"},
+		{input: "ws switch default", want: ",seven"},
+		{input: "ws switch other", want: "one"},
+	}
+	for _, tt := range casetests {
+		if _, err := execute(ops, tt.input); err != nil {
+			t.Fatalf("input: %s, got error %q, want no error", tt.input, err)
+		}
+		if got := strings.Join(stack.labelList(), ","); got != tt.want {
+			t.Fatalf("diff: input: %s, want labels: %q, got: %q", tt.input, tt.want, got)
+		}
+	}
+}
+
+func Example_vars() {
+	ops := newOpsType(decimal.Context128, &stackType{})
+	ops.strict = true
+
+	execute(ops, "1234.5 sto total 2 sto+ total 255 sto b vars")
+	execute(ops, "hex vars")
+	// Output:
+	// b: 255
+	// total: 1236.5 (1,236.5)
+	// b: 0xff
+	// total: 0x4d4 (truncated from 1236.5)
+}
+
+func Example_step() {
+	ops := newOpsType(decimal.Context128, &stackType{})
+	ops.strict = true
+
+	execute(ops, "def sq [ dup * ] step")
+	execute(ops, "3 sq")
+	// Output:
+	// Step mode: true
+	// step: dup
+	// ===== Stack =====
+	//  x: 3
+	//  y: 3
+	// step: *
+	// ===== Stack =====
+	//  x: 9
+}
+
+func TestStepStop(t *testing.T) {
+	stack := &stackType{}
+	ops := newOpsType(decimal.Context128, stack)
+	ops.out = io.Discard
+	ops.strict = true
+
+	steps := 0
+	ops.stepWait = func() (string, error) {
+		steps++
+		if steps == 2 {
+			return "q", nil
+		}
+		return "", nil
+	}
+	if _, err := execute(ops, "def sq [ dup * ] step 2 sq sq"); err == nil {
+		t.Fatalf("Got no error, want error")
+	}
+	if steps != 2 || len(stack.list) != 0 {
+		t.Fatalf("diff: want 2 steps and the stack restored, got %d steps and stack %v", steps, stack.list)
+	}
+}
+
+func Example_bases() {
+	stack := &stackType{}
+	ops := newOpsType(decimal.Context128, stack)
+	ops.strict = true
+
+	for _, line := range []string{"4096 bases", "wsize 8 -2 bases"} {
+		execute(ops, line)
+	}
+	// Output:
+	//   dec: 4096 (4,096)
+	//   hex: 0x1000
+	//   oct: 010000
+	//   bin: 0000 0000 0000 0000 0000 0000 0000 0000  (63-32)
+	//        0000 0000 0000 0000 0001 0000 0000 0000  (31-0)
+	//   dec: -2
+	//   hex: 0xfe
+	//   oct: 0376
+	//   bin: 1111 1110  (7-0)
+}
+
+func Example_full() {
+	stack := &stackType{}
+	ops := newOpsType(decimal.Context128, stack)
+	ops.strict = true
+
+	for _, line := range []string{"2 3 / full", "1e40 =!"} {
+		execute(ops, line)
+	}
+	// Output:
+	// = 0.6666666666666666666666666666666667
+	// = 10000000000000000000000000000000000000000
+}
+
+func Example_currency() {
+	stack := &stackType{}
+	ops := newOpsType(decimal.Context128, stack)
+	ops.strict = true
+
+	for _, line := range []string{"currency dollar 1234.5 =", "-0.001 =", "-1234.567 =", "currency euro sep period 1234567.891 =", "currency off 2.5 ="} {
+		execute(ops, line)
+	}
+	// Output:
+	// = $1,234.50
+	// = $0.00
+	// = -$1,234.57
+	// = €1.234.567,89
+	// = 2.5 (2,5)
+}
+
+func Example_human() {
+	stack := &stackType{}
+	ops := newOpsType(decimal.Context128, stack)
+	ops.strict = true
+
+	for _, line := range []string{"5 =", "human always 5 =", "human never 5000 =", "human auto 5000 ="} {
+		execute(ops, line)
+	}
+	// Output:
+	// = 5
+	// = 5 (5)
+	// = 5000
+	// = 5000 (5,000)
+}
+
+func Example_plain() {
+	stack := &stackType{}
+	ops := newOpsType(decimal.Context128, stack)
+	ops.strict = true
+	ops.plain = true
+
+	for _, line := range []string{"5000 =", "2 3 / full", "pbases 255 ="} {
+		execute(ops, line)
+	}
+	// Output:
+	// 5000
+	// 0.6666666666666666666666666666666667
+	// 255
+}
+
+func Example_infix() {
+	stack := &stackType{}
+	ops := newOpsType(decimal.Context128, stack)
+	ops.strict = true
+	ops.infix = true
+
+	for _, line := range []string{"(2+3)*4^2", "2 *", "sqr(16) + 1"} {
+		if autoprint, _ := execute(ops, line); autoprint {
+			stack.printTop(ops.out, ops.ctx, ops.displayType)
+		}
+	}
+	// Output:
+	// = 80
+	// = 160
+	// = 5
+}
+
+func Example_floatBits() {
+	stack := &stackType{}
+	ops := newOpsType(decimal.Context128, stack)
+	ops.strict = true
+
+	execute(ops, "-2.5 f32bits")
+	// Output:
+	//   sign:     1
+	//   exponent: 10000000 (128 - 127 = 1)
+	//   mantissa: 01000000000000000000000
+	//   hex:      0xc0200000
+}
+
+func Example_stackPrintAltBase() {
+	stack := &stackType{}
+	stack.push(bigFloat("-255"), bigUint(4096), bigFloat("3.5"))
+
+	// Print twice to make sure formatting doesn't change the values in the stack.
+	stack.print(os.Stdout, decimal.Context128, displayType{base: 16, decimals: 6, altBase: 10}, false)
+	stack.print(os.Stdout, decimal.Context128, displayType{base: 16, decimals: 6, altBase: 10}, false)
+	// Output:
+	// ===== Stack =====
+	//  x: 0x3 (truncated from 3.5)  3.5
+	//  y: 0x1000                    4096 (4,096)
+	//  0: 0xffffffffffffff01        -255
+	// ===== Stack =====
+	//  x: 0x3 (truncated from 3.5)  3.5
+	//  y: 0x1000                    4096 (4,096)
+	//  0: 0xffffffffffffff01        -255
+}
+
+func TestCalculator(t *testing.T) {
+	c := New()
+	if _, err := c.Top(); err == nil {
+		t.Fatalf("Top on an empty stack: got no error, want error")
+	}
+	if err := c.Eval("1 2 3 +"); err != nil {
+		t.Fatalf("got error: %v", err)
+	}
+	if err := c.Eval("foo"); err == nil {
+		t.Fatalf("Eval of unknown token: got no error, want error")
+	}
+	c.Push(bigUint(10))
+	if got := c.Stack(); len(got) != 3 || got[0].Cmp(bigUint(1)) != 0 || got[1].Cmp(bigUint(5)) != 0 || got[2].Cmp(bigUint(10)) != 0 {
+		t.Fatalf("diff: want stack: [1 5 10], got: %v", got)
+	}
+	if err := c.Eval("hex *"); err != nil {
+		t.Fatalf("got error: %v", err)
+	}
+	x, _ := c.Top()
+	if got := c.Format(x); got != "0x32" {
+		t.Fatalf("diff: want: %q, got: %q", "0x32", got)
+	}
+}
+
+func TestCalculatorOutput(t *testing.T) {
+	// Notes from operations go to the calculator output.
+	var buf bytes.Buffer
+	c := New()
+	c.SetOutput(&buf)
+	if err := c.Eval("1.5 3 and"); err != nil {
+		t.Fatalf("got error: %v", err)
+	}
+	if want := "Note: 1.5 truncated to 1 (64 bits)\n"; buf.String() != want {
+		t.Fatalf("diff: want: %q, got: %q", want, buf.String())
+	}
+
+	// Status queries are not warnings, so they're printed without colors.
+	oldNoColor := color.NoColor
+	defer func() { color.NoColor = oldNoColor }()
+	color.NoColor = false
+	buf.Reset()
+	if err := c.Eval("prec"); err != nil {
+		t.Fatalf("got error: %v", err)
+	}
+	if want := "Precision: 34 digits\n"; buf.String() != want {
+		t.Fatalf("diff: want: %q, got: %q", want, buf.String())
+	}
+}
+
+// fakeClipboard is a Clipboard holding its contents in memory.
+type fakeClipboard struct {
+	s string
+}
+
+func (x *fakeClipboard) Copy(s string) error {
+	x.s = s
+	return nil
+}
+
+func (x *fakeClipboard) Paste() (string, error) {
+	return x.s, nil
+}
+
+func TestCalculatorSettings(t *testing.T) {
+	c := New()
+	if err := c.SetBase(37); err == nil {
+		t.Fatalf("SetBase(37): got no error, want error")
+	}
+	if err := c.SetInputBase(1); err == nil {
+		t.Fatalf("SetInputBase(1): got no error, want error")
+	}
+	if err := c.SetDecimals(-1); err == nil {
+		t.Fatalf("SetDecimals(-1): got no error, want error")
+	}
+	if err := c.SetPrecision(0); err == nil {
+		t.Fatalf("SetPrecision(0): got no error, want error")
+	}
+	if err := c.SetInputBase(16); err != nil {
+		t.Fatalf("got error: %v", err)
+	}
+	c.SetDegrees(true)
+	if err := c.SetPrecision(50); err != nil {
+		t.Fatalf("got error: %v", err)
+	}
+	if got := c.Prompt("%i %a %P"); got != "16 deg 50" {
+		t.Fatalf("diff: want: %q, got: %q", "16 deg 50", got)
+	}
+
+	// Numbers honor the input base.
+	nums, err := c.ParseNumbers("ff 0x10")
+	if err != nil || len(nums) != 2 || nums[0].Cmp(bigUint(255)) != 0 || nums[1].Cmp(bigUint(16)) != 0 {
+		t.Fatalf("diff: want: [255 16], got: %v (error: %v)", nums, err)
+	}
+	if _, err := c.ParseNumbers("1 +"); err == nil {
+		t.Fatalf("ParseNumbers with an operator: got no error, want error")
+	}
+	if n, ok := c.NumArgs("+"); !ok || n != 2 {
+		t.Fatalf("diff: NumArgs(\"+\"): want: 2, true, got: %d, %v", n, ok)
+	}
+	if _, ok := c.NumArgs("foo"); ok {
+		t.Fatalf("NumArgs of an unknown operation: got true, want false")
+	}
+
+	if err := c.Define("half", "2 /"); err != nil {
+		t.Fatalf("got error: %v", err)
+	}
+	if err := c.Eval("a half"); err != nil {
+		t.Fatalf("got error: %v", err)
+	}
+	if x, _ := c.Top(); x.Cmp(bigUint(5)) != 0 {
+		t.Fatalf("diff: want: 5, got: %s", x)
+	}
+
+	// Copy and paste need a clipboard.
+	if err := c.Eval("copy"); err == nil {
+		t.Fatalf("copy without a clipboard: got no error, want error")
+	}
+	c.SetOutput(io.Discard)
+	c.SetClipboard(&fakeClipboard{})
+	if err := c.Eval("copy c paste"); err != nil {
+		t.Fatalf("got error: %v", err)
+	}
+	if x, _ := c.Top(); c.Len() != 1 || x.Cmp(bigUint(5)) != 0 {
+		t.Fatalf("diff: want stack: [5], got: %v", c.Stack())
+	}
+
+	c.Clear()
+	if err := c.PrintResult(io.Discard, ""); err == nil {
+		t.Fatalf("PrintResult on an empty stack: got no error, want error")
+	}
+}
+
+func ExampleCalculator() {
+	c := New()
+	c.Eval("2 3 + 4 *")
+	x, _ := c.Top()
+	fmt.Println(x)
+
+	c.Eval("3 fmt 1 3 /")
+	x, _ = c.Top()
+	fmt.Println(c.Format(x), len(c.Stack()))
+	// Output:
+	// 20
+	// 0.333 2
+}
+
+func ExampleEval() {
+	x, _ := Eval("2 sqr 2 ^")
+	fmt.Println(x)
+	// Output: 2.000000000000000000000000000000000
+}
+
+func TestRegisterOp(t *testing.T) {
+	t.Cleanup(func() { userOps = nil })
+
+	half := func(a []*decimal.Big) ([]*decimal.Big, error) {
+		return []*decimal.Big{big().Quo(a[0], bigUint(2))}, nil
+	}
+	// sum adds all its arguments, so it only works if it gets NumArgs.
+	sum := func(a []*decimal.Big) ([]*decimal.Big, error) {
+		ret := big()
+		for _, n := range a {
+			ret.Add(ret, n)
+		}
+		return []*decimal.Big{ret}, nil
+	}
+	caseTests := []struct {
+		op      Op
+		wantErr bool
+	}{
+		{Op{Name: "half", Desc: "Half of x", NumArgs: 1, Fn: half}, false},
+		{Op{Name: "half", Desc: "Duplicate", NumArgs: 1, Fn: half}, true},
+		{Op{Name: "sqr", Desc: "Builtin", NumArgs: 1, Fn: half}, true},
+		{Op{Name: "fmt", Desc: "Builtin command", NumArgs: 1, Fn: half}, true},
+		{Op{Name: "two words", NumArgs: 1, Fn: half}, true},
+		{Op{Name: "nofn", NumArgs: 1}, true},
+		{Op{Name: "neg", NumArgs: -1, Fn: half}, true},
+		{Op{Name: "add2", Desc: "Sum of x and y", NumArgs: 2, Fn: sum}, false},
+		{Op{Name: "10", NumArgs: 1, Fn: half}, true},
+		{Op{Name: "0x10", NumArgs: 1, Fn: half}, true},
+		{Op{Name: "1e3", NumArgs: 1, Fn: half}, true},
+		{Op{Name: "Inf", NumArgs: 1, Fn: half}, true},
+		{Op{Name: "h", NumArgs: 1, Fn: half}, true},
+		{Op{Name: "quit", NumArgs: 1, Fn: half}, true},
+		{Op{Name: "ans", NumArgs: 1, Fn: half}, true},
+		{Op{Name: "ans2", NumArgs: 1, Fn: half}, true},
+		{Op{Name: "[x]", NumArgs: 1, Fn: half}, true},
+	}
+	for _, tt := range caseTests {
+		err := RegisterOp(tt.op)
+		if !tt.wantErr && err != nil {
+			t.Fatalf("RegisterOp(%q): got error: %v", tt.op.Name, err)
+		}
+		if tt.wantErr && err == nil {
+			t.Fatalf("RegisterOp(%q): got no error, want error", tt.op.Name)
+		}
+	}
+
+	x, err := Eval("3 half half")
+	if err != nil {
+		t.Fatalf("got error: %v", err)
+	}
+	if x.Cmp(big().SetFloat64(0.75)) != 0 {
+		t.Fatalf("diff: want: 0.75, got: %v", x)
+	}
+	x, err = Eval("1 2 3 add2")
+	if err != nil {
+		t.Fatalf("got error: %v", err)
+	}
+	if x.Cmp(bigUint(5)) != 0 {
+		t.Fatalf("diff: want: 5, got: %v", x)
+	}
+	if _, err := Eval("half"); err == nil {
+		t.Fatalf("half on an empty stack: got no error, want error")
+	}
+}
+
+func TestExecPanic(t *testing.T) {
+	t.Cleanup(func() { userOps = nil })
+
+	// Operations on an empty stack return an error.
+	c := New()
+	if err := c.Eval("fmt"); err == nil {
+		t.Fatalf("fmt on an empty stack: got no error, want error")
+	}
+
+	// Panics in operations are returned as errors, restoring the stack.
+	crash := func(_ []*decimal.Big) ([]*decimal.Big, error) {
+		panic("boom")
+	}
+	if err := RegisterOp(Op{Name: "crash", Desc: "Panic", NumArgs: 1, Fn: crash}); err != nil {
+		t.Fatal(err)
+	}
+	c = New()
+	if err := c.Eval("1 2 crash"); err == nil || !strings.Contains(err.Error(), "boom") {
+		t.Fatalf("diff: want error with %q, got: %v", "boom", err)
+	}
+	if c.Len() != 0 {
+		t.Fatalf("diff: want empty stack, got: %v", c.Stack())
+	}
+	if err := c.Eval("1 2 +"); err != nil {
+		t.Fatalf("got error: %v", err)
+	}
+}
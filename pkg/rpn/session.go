@@ -0,0 +1,181 @@
+// This file is part of rpn, a simple and useful CLI RPN calculator.
+// For further information, check https://github.com/marcopaganini/rpn
+//
+// (C) Sep/2024 by Marco Paganini <paganini AT paganini DOT net>
+
+package rpn
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/ericlagergren/decimal"
+	"github.com/marcopaganini/rpn/internal/xdg"
+)
+
+// sessionType holds the stack and modes saved between interactive sessions.
+type sessionType struct {
+	Base     int      `json:"base"`
+	Decimals int      `json:"decimals"`
+	Degmode  bool     `json:"degmode"`
+	WordSize int      `json:"wordsize,omitempty"`
+	Signed   bool     `json:"signed,omitempty"`
+	Stack    []string `json:"stack"`
+	Labels   []string `json:"labels,omitempty"` // Labels of stack items, in the same order
+}
+
+// saveSession writes the stack and modes in ops to w, in JSON.
+func saveSession(ops *opsType, w io.Writer) error {
+	session := sessionType{
+		Base:     ops.base,
+		Decimals: ops.decimals,
+		Degmode:  ops.degmode,
+		WordSize: ops.wordSize,
+		Signed:   ops.signed,
+		Stack:    []string{},
+	}
+	for _, n := range ops.stack.list {
+		session.Stack = append(session.Stack, n.String())
+	}
+	if labels := ops.stack.labelList(); slices.ContainsFunc(labels, func(s string) bool { return s != "" }) {
+		session.Labels = labels
+	}
+	data, err := json.MarshalIndent(session, "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(append(data, '\n'))
+	return err
+}
+
+// loadSession restores the stack and modes in ops from a session saved by
+// saveSession.
+func loadSession(ops *opsType, r io.Reader) error {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+
+	var session sessionType
+	if err := json.Unmarshal(data, &session); err != nil {
+		return err
+	}
+	if !validBase(session.Base) {
+		return fmt.Errorf("invalid base: %d", session.Base)
+	}
+	if session.Decimals < 0 {
+		return fmt.Errorf("invalid number of decimals: %d", session.Decimals)
+	}
+	// Sessions saved by older versions have no word size.
+	if session.WordSize == 0 {
+		session.WordSize = defaultWordSize
+	}
+	if !validWordSize(session.WordSize) {
+		return fmt.Errorf("invalid word size: %d", session.WordSize)
+	}
+	list := []*decimal.Big{}
+	for _, s := range session.Stack {
+		// Anything that is not a number parses as NaN.
+		n, ok := big().SetString(s)
+		if !ok || (n.IsNaN(0) && s != "NaN") {
+			return fmt.Errorf("invalid number: %q", s)
+		}
+		list = append(list, n)
+	}
+	if session.Labels != nil && len(session.Labels) != len(list) {
+		return fmt.Errorf("got %d labels for %d stack items", len(session.Labels), len(list))
+	}
+	ops.base = session.Base
+	ops.decimals = session.Decimals
+	ops.degmode = session.Degmode
+	ops.wordSize = session.WordSize
+	ops.signed = session.Signed
+	ops.stack.list = list
+	ops.stack.setLabelList(session.Labels)
+	return nil
+}
+
+// varsFile returns the path of the file holding the saved registers.
+func varsFile() (string, error) {
+	return xdg.StateFile("vars")
+}
+
+// saveVars saves the registers to the vars file, one "name value" per line.
+func saveVars(registers map[string]*decimal.Big) error {
+	fname, err := varsFile()
+	if err != nil {
+		return err
+	}
+	names := []string{}
+	for name := range registers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var sb strings.Builder
+	for _, name := range names {
+		fmt.Fprintf(&sb, "%s %s\n", name, registers[name])
+	}
+	if err := os.MkdirAll(filepath.Dir(fname), 0o755); err != nil {
+		return err
+	}
+	return os.WriteFile(fname, []byte(sb.String()), 0o644)
+}
+
+// loadVars returns the registers saved in the vars file. A missing file
+// returns no registers.
+func loadVars() (map[string]*decimal.Big, error) {
+	registers := map[string]*decimal.Big{}
+
+	fname, err := varsFile()
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.Open(fname)
+	if errors.Is(err, fs.ErrNotExist) {
+		return registers, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for lineno := 1; scanner.Scan(); lineno++ {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 {
+			continue
+		}
+		var n *decimal.Big
+		ok := len(fields) == 2
+		if ok {
+			n, ok = big().SetString(fields[1])
+		}
+		if !ok || (n.IsNaN(0) && fields[1] != "NaN") {
+			return nil, fmt.Errorf("%s:%d: invalid register definition", fname, lineno)
+		}
+		registers[fields[0]] = n
+	}
+	return registers, scanner.Err()
+}
+
+// purgeVars removes the vars file.
+func purgeVars() error {
+	fname, err := varsFile()
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(fname); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	return nil
+}
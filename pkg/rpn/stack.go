@@ -0,0 +1,467 @@
+// This file is part of rpn, a simple and useful CLI RPN calculator.
+// For further information, check https://github.com/marcopaganini/rpn
+//
+// (C) Sep/2024 by Marco Paganini <paganini AT paganini DOT net>
+package rpn
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/ericlagergren/decimal"
+	"github.com/fatih/color"
+)
+
+type (
+	// stackType holds the representation of the RPN stack in "list".
+	// "printedList" holds a copy of the stack as of the last time it was
+	// displayed, and is used to highlight values that changed since then.
+	// "labels" holds optional labels attached to entries in the stack. Each
+	// entry is a distinct number (operations push copies of numbers that are
+	// still in the stack), so labels follow their entry as it moves around
+	// the stack. Use labelList and setLabelList to save and restore them.
+	stackType struct {
+		list        []*decimal.Big
+		printedList []*decimal.Big
+		labels      map[*decimal.Big]string
+	}
+
+	// humanStyle selects how decimal numbers are humanized.
+	humanStyle int
+
+	// humanMode selects when the humanized form of decimal numbers is shown.
+	humanMode int
+
+	// displayType holds the options used to display numbers and the stack.
+	displayType struct {
+		base      int        // Base for printing (default = 10)
+		decimals  int        // How many decimals to use when printing
+		altBase   int        // Base for a second column in print (0 = none)
+		window    int        // Maximum number of entries displayed by print (0 = all)
+		bottomUp  bool       // Display the stack with x at the bottom
+		pretty    bool       // Use Unicode characters to display decimal numbers
+		wordSize  int        // Word size in bits for bitwise ops and non-decimal bases
+		signed    bool       // Bitwise ops and non-decimal bases use two's complement
+		showBases bool       // Show results in all bases (programmer view)
+		group     int        // Digit group size for non-decimal bases (0 = none)
+		human     humanStyle // Style of the humanized form of decimal numbers
+		showHuman humanMode  // When to show the humanized form of decimal numbers
+		plain     bool       // Print results without the "= " prefix and humanized form
+		sep       string     // Separator style of the humanized form (see sep)
+		fracDen   int        // Maximum denominator of fractions in print (0 = none)
+		currency  bool       // Display decimal numbers as money (E.g. $1,234.50)
+		symbol    string     // Currency symbol (see currency)
+	}
+
+	// stackColorsType holds the colors used to display each element of the
+	// stack, results and messages. A nil color means "print without color".
+	stackColorsType struct {
+		x       *color.Color // x register (tag and value)
+		y       *color.Color // y register (tag and value)
+		index   *color.Color // Index of other stack elements
+		value   *color.Color // Value of other stack elements
+		changed *color.Color // Values changed since the last display
+		result  *color.Color // Results (top of the stack after each line)
+		err     *color.Color // Error messages
+		warn    *color.Color // Warnings
+		bold    *color.Color // Headers and names in help and listings
+	}
+)
+
+// stackColors contains the colors used by stack.print, results and messages.
+// It can be changed by setting the RPN_COLORS environment variable or with
+// the colors command (see parseStackColors).
+var stackColors = stackColorsType{
+	changed: color.New(color.Bold),
+	result:  color.New(color.FgCyan),
+	err:     color.New(color.FgRed),
+	warn:    color.New(color.FgMagenta),
+	bold:    color.New(color.Bold),
+}
+
+// These are functions to be used to print in color.
+var (
+	errorMsg = colorFunc(&stackColors.err)
+	warnMsg  = colorFunc(&stackColors.warn)
+	bold     = colorFunc(&stackColors.bold)
+)
+
+// ErrorMsg returns its arguments, formatted as by fmt.Sprint, in the color
+// used for error messages.
+func ErrorMsg(a ...any) string {
+	return errorMsg(a...)
+}
+
+// WarnMsg returns its arguments, formatted as by fmt.Sprint, in the color
+// used for warnings.
+func WarnMsg(a ...any) string {
+	return warnMsg(a...)
+}
+
+// SetColors changes the colors used to display the stack, results and
+// messages, as specified by spec (see parseStackColors). Colors are shared
+// by all calculators. Invalid specifications leave the colors unchanged.
+func SetColors(spec string) error {
+	colors, err := parseStackColors(stackColors, spec)
+	if err != nil {
+		return err
+	}
+	stackColors = colors
+	return nil
+}
+
+// colorFunc returns a function that paints its arguments with the color in
+// *c. The color is read on every call, so changes take effect immediately.
+func colorFunc(c **color.Color) func(a ...any) string {
+	return func(a ...any) string {
+		return paint(*c, fmt.Sprint(a...))
+	}
+}
+
+// parseStackColors parses a color specification in the same format used by
+// GREP_COLORS: a colon separated list of key=SGR entries, where SGR is a
+// semicolon separated list of ANSI SGR codes. Valid keys are "x", "y", "idx",
+// "val", "chg", "res", "err", "warn", and "bold". An empty SGR disables
+// coloring for that element.  Example: "x=1;36:y=36:idx=33:chg=1;31".
+// Unspecified keys keep their previous values.
+func parseStackColors(colors stackColorsType, spec string) (stackColorsType, error) {
+	for _, entry := range strings.Split(spec, ":") {
+		if entry == "" {
+			continue
+		}
+		key, sgr, ok := strings.Cut(entry, "=")
+		if !ok {
+			return colors, fmt.Errorf("invalid color entry: %q", entry)
+		}
+
+		var c *color.Color
+		if sgr != "" {
+			c = color.New()
+			for _, code := range strings.Split(sgr, ";") {
+				n, err := strconv.Atoi(code)
+				if err != nil {
+					return colors, fmt.Errorf("invalid SGR code in color entry: %q", entry)
+				}
+				c.Add(color.Attribute(n))
+			}
+		}
+
+		switch key {
+		case "x":
+			colors.x = c
+		case "y":
+			colors.y = c
+		case "idx":
+			colors.index = c
+		case "val":
+			colors.value = c
+		case "chg":
+			colors.changed = c
+		case "res":
+			colors.result = c
+		case "err":
+			colors.err = c
+		case "warn":
+			colors.warn = c
+		case "bold":
+			colors.bold = c
+		default:
+			return colors, fmt.Errorf("invalid color key: %q", key)
+		}
+	}
+	return colors, nil
+}
+
+// paint returns the string painted with the color passed. Nil colors return
+// the original string.
+func paint(c *color.Color, s string) string {
+	if c == nil {
+		return s
+	}
+	return c.Sprint(s)
+}
+
+// push adds a new element to the stack.
+func (x *stackType) push(n ...*decimal.Big) {
+	x.list = append(x.list, n...)
+}
+
+// clear clears the stack.
+func (x *stackType) clear() {
+	x.list = []*decimal.Big{}
+}
+
+// top returns the topmost element on the stack (without popping it).
+func (x *stackType) top() *decimal.Big {
+	if len(x.list) == 0 {
+		return big()
+	}
+	return x.list[len(x.list)-1]
+}
+
+// setLabel attaches a label to the top of the stack. An empty label removes
+// the existing label. Labels of values no longer in the stack are discarded.
+func (x *stackType) setLabel(label string) {
+	labels := map[*decimal.Big]string{}
+	for _, v := range x.list {
+		if l, ok := x.labels[v]; ok {
+			labels[v] = l
+		}
+	}
+	delete(labels, x.top())
+	if label != "" && len(x.list) > 0 {
+		labels[x.top()] = label
+	}
+	x.labels = labels
+}
+
+// labelList returns the labels of all entries in the stack, in the same
+// order as list. Entries without a label have an empty label.
+func (x *stackType) labelList() []string {
+	ret := make([]string, len(x.list))
+	for i, v := range x.list {
+		ret[i] = x.labels[v]
+	}
+	return ret
+}
+
+// setLabelList replaces the labels of all entries in the stack with labels,
+// in the same order as list (as returned by labelList).
+func (x *stackType) setLabelList(labels []string) {
+	x.labels = map[*decimal.Big]string{}
+	for i, v := range x.list {
+		if i < len(labels) && labels[i] != "" {
+			x.labels[v] = labels[i]
+		}
+	}
+}
+
+// Humanized number styles.
+const (
+	humanComma humanStyle = iota // Thousands separators (E.g. 1,500,000)
+	humanSI                      // SI prefixes (E.g. 1.5M)
+	humanBytes                   // Byte sizes with binary prefixes (E.g. 1.5 KiB)
+)
+
+// Humanized number display modes.
+const (
+	humanAuto   humanMode = iota // Only when different from the number
+	humanAlways                  // Always
+	humanNever                   // Never
+)
+
+// humanModes maps the modes accepted by the human command to their values.
+var humanModes = map[string]humanMode{
+	"auto":   humanAuto,
+	"always": humanAlways,
+	"never":  humanNever,
+}
+
+// format formats a number using the display options.
+func (x displayType) format(ctx decimal.Context, n *decimal.Big) string {
+	if x.pretty && x.base == 10 && fixedPoint(n) {
+		return prettyNumber(n, x.decimals)
+	}
+	s := formatNumber(ctx, n, x)
+	if x.base != 10 && x.group > 0 {
+		s = groupDigits(s, x.group)
+	}
+	return s
+}
+
+// groupDigits separates the digits of a number formatted in a non-decimal
+// base (E.g. 0xdeadbeef) in groups of size digits, counting from the right
+// (E.g. 0xdead_beef).
+func groupDigits(s string, size int) string {
+	sign := ""
+	if strings.HasPrefix(s, "-") {
+		sign, s = "-", s[1:]
+	}
+	prefix := ""
+	for _, p := range []string{"0x", "0b", "0"} {
+		if strings.HasPrefix(s, p) {
+			prefix, s = p, s[len(p):]
+			break
+		}
+	}
+	// Other bases use the base#digits notation.
+	if i := strings.Index(s, "#"); prefix == "" && i > 0 {
+		prefix, s = s[:i+1], s[i+1:]
+	}
+	// Digits end at the first non-alphanumeric character (E.g. the
+	// "truncated from" suffix). Invalid numbers are returned as is.
+	end := strings.IndexFunc(s, func(r rune) bool {
+		return !unicode.IsDigit(r) && !unicode.IsLetter(r)
+	})
+	if end < 0 {
+		end = len(s)
+	}
+	digits, suffix := s[:end], s[end:]
+	if prefix == "" || digits == "" || len(digits) <= size {
+		return sign + prefix + s
+	}
+
+	var sb strings.Builder
+	for ix, r := range digits {
+		if ix > 0 && (len(digits)-ix)%size == 0 {
+			sb.WriteByte('_')
+		}
+		sb.WriteRune(r)
+	}
+	return sign + prefix + sb.String() + suffix
+}
+
+// printTop displays the top of the stack in w using the display options
+// indicated.
+func (x *stackType) printTop(w io.Writer, ctx decimal.Context, disp displayType) {
+	s := disp.format(ctx, x.top())
+	if disp.plain {
+		fmt.Fprintln(w, s)
+		return
+	}
+	if disp.fracDen > 0 && x.top().IsFinite() && !x.top().IsInt() {
+		num, den, exact := nearestFraction(x.top(), int64(disp.fracDen))
+		sym := "≈"
+		if exact {
+			sym = "="
+		}
+		s += fmt.Sprintf(" %s %s/%s", sym, num, den)
+	}
+	fmt.Fprintln(w, paint(stackColors.result, "= "+s))
+	if disp.showBases {
+		printBases(w, ctx, disp, x.top())
+	}
+}
+
+// printFull displays the top of the stack with all the digits it holds,
+// ignoring the number of decimals and the humanized form.
+func (x *stackType) printFull(w io.Writer, disp displayType) {
+	if disp.plain {
+		fmt.Fprintf(w, "%f\n", x.top())
+		return
+	}
+	fmt.Fprintln(w, paint(stackColors.result, fmt.Sprintf("= %f", x.top())))
+}
+
+// printBases displays n in decimal, hexadecimal, octal and binary. Binary
+// digits are grouped in nibbles, 32 bits per line, with the bit numbers of
+// each line on the right.
+func printBases(w io.Writer, ctx decimal.Context, disp displayType, n *decimal.Big) {
+	for _, b := range []struct {
+		name string
+		base int
+	}{{"dec", 10}, {"hex", 16}, {"oct", 8}} {
+		d := disp
+		d.base = b.base
+		fmt.Fprintf(w, "  %s: %s\n", b.name, d.format(ctx, n))
+	}
+
+	wordSize := disp.wordSize
+	if wordSize == 0 {
+		wordSize = defaultWordSize
+	}
+	// Values that can't be shown as words use the regular binary format,
+	// which explains why.
+	if !n.IsFinite() || !inWord(n.Int(nil), wordSize) {
+		d := disp
+		d.base = 2
+		fmt.Fprintf(w, "  bin: %s\n", d.format(ctx, n))
+		return
+	}
+	word := n.Int(nil)
+	word.And(word, wordMask(wordSize))
+	digits := fmt.Sprintf("%0*b", wordSize, word)
+
+	tag := "bin:"
+	for hi := wordSize - 1; hi >= 0; hi -= 32 {
+		lo := max(hi-31, 0)
+		line := digits[wordSize-1-hi : wordSize-lo]
+		nibbles := []string{}
+		for ix := 0; ix < len(line); ix += 4 {
+			nibbles = append(nibbles, line[ix:ix+4])
+		}
+		fmt.Fprintf(w, "  %-4s %s  (%d-%d)\n", tag, strings.Join(nibbles, " "), hi, lo)
+		tag = ""
+	}
+}
+
+// changed returns true if the element at position ix in the stack differs
+// from the same position the last time the stack was displayed.
+func (x *stackType) changed(ix int) bool {
+	if ix >= len(x.printedList) {
+		return true
+	}
+	return x.list[ix].CmpTotal(x.printedList[ix]) != 0
+}
+
+// print displays the contents of the stack in w using the display options
+// indicated. Only the top "window" entries are displayed, unless all is set.
+func (x *stackType) print(w io.Writer, ctx decimal.Context, disp displayType, all bool) {
+	last := len(x.list) - 1
+
+	// Index of the first (bottommost) element to be displayed.
+	first := 0
+	if !all && disp.window > 0 && len(x.list) > disp.window {
+		first = len(x.list) - disp.window
+	}
+	hidden := fmt.Sprintf("    (%d more, use \"p all\" to see all)", first)
+
+	fmt.Fprintln(w, bold("===== Stack ====="))
+	if disp.bottomUp && first > 0 {
+		fmt.Fprintln(w, hidden)
+	}
+	// Format all values first so the second column (if any) can be aligned.
+	vals := map[int]string{}
+	width := 0
+	for ix := first; ix <= last; ix++ {
+		vals[ix] = disp.format(ctx, x.list[ix])
+		width = max(width, utf8.RuneCountInString(vals[ix]))
+	}
+
+	for n := first; n <= last; n++ {
+		// Top-down displays x first.
+		ix := n
+		if !disp.bottomUp {
+			ix = last - (n - first)
+		}
+
+		tag := fmt.Sprintf("%2d", ix)
+		tagColor, valColor := stackColors.index, stackColors.value
+		switch ix {
+		case last:
+			tag = " x"
+			tagColor, valColor = stackColors.x, stackColors.x
+		case last - 1:
+			tag = " y"
+			tagColor, valColor = stackColors.y, stackColors.y
+		}
+		if x.changed(ix) {
+			valColor = stackColors.changed
+		}
+		val := vals[ix]
+		if disp.altBase != 0 {
+			alt := disp
+			alt.base = disp.altBase
+			pad := strings.Repeat(" ", width-utf8.RuneCountInString(val))
+			val = val + pad + "  " + alt.format(ctx, x.list[ix])
+		}
+		if label, ok := x.labels[x.list[ix]]; ok {
+			val += " (" + label + ")"
+		}
+		fmt.Fprintf(w, "%s: %s\n", paint(tagColor, tag), paint(valColor, val))
+	}
+	if !disp.bottomUp && first > 0 {
+		fmt.Fprintln(w, hidden)
+	}
+
+	// Save a copy of the values displayed. Copies are needed since some
+	// operations change the numbers in the stack in place.
+	x.printedList = []*decimal.Big{}
+	for _, v := range x.list {
+		x.printedList = append(x.printedList, big().Copy(v))
+	}
+}
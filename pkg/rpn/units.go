@@ -0,0 +1,419 @@
+// This file is part of rpn, a simple and useful CLI RPN calculator.
+// For further information, check https://github.com/marcopaganini/rpn
+//
+// (C) Sep/2024 by Marco Paganini <paganini AT paganini DOT net>
+
+package rpn
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/ericlagergren/decimal"
+)
+
+// builtinUnits contains a small set of unit definitions in GNU units format.
+// More units can be loaded from GNU units data files (see unitFiles).
+const builtinUnits = `
+# Primitive units.
+m       !
+kg      !
+s       !
+A       !
+K       !
+mol     !
+cd      !
+bit     !
+
+# SI prefixes.
+yotta-  1e24
+zetta-  1e21
+exa-    1e18
+peta-   1e15
+tera-   1e12
+giga-   1e9
+mega-   1e6
+kilo-   1e3
+hecto-  1e2
+deka-   1e1
+deci-   1e-1
+centi-  1e-2
+milli-  1e-3
+micro-  1e-6
+nano-   1e-9
+pico-   1e-12
+femto-  1e-15
+Y-      yotta
+Z-      zetta
+E-      exa
+P-      peta
+T-      tera
+G-      giga
+M-      mega
+k-      kilo
+h-      hecto
+da-     deka
+d-      deci
+c-      centi
+m-      milli
+u-      micro
+n-      nano
+p-      pico
+f-      femto
+
+# Binary prefixes.
+kibi-   1024
+mebi-   kibi kibi
+gibi-   kibi mebi
+tebi-   kibi gibi
+Ki-     kibi
+Mi-     mebi
+Gi-     gibi
+Ti-     tebi
+
+# Common units.
+meter   m
+metre   m
+gram    1|1000 kg
+g       gram
+second  s
+sec     s
+min     60 s
+minute  min
+hour    60 min
+hr      hour
+h       hour
+day     24 hour
+week    7 day
+year    365.25 day
+liter   1e-3 m^3
+litre   liter
+l       liter
+L       liter
+inch    2.54 cm
+in      inch
+foot    12 inch
+feet    foot
+ft      foot
+yard    3 ft
+yd      yard
+mile    5280 ft
+mi      mile
+nmile   1852 m
+knot    nmile/hour
+mph     mile/hour
+kph     km/hour
+acre    43560 ft^2
+hectare 1e4 m^2
+ha      hectare
+pound   0.45359237 kg
+lb      pound
+ounce   1|16 lb
+oz      ounce
+ton     2000 lb
+tonne   1000 kg
+t       tonne
+gallon  231 in^3
+gal     gallon
+quart   1|4 gallon
+pint    1|2 quart
+cup     1|2 pint
+floz    1|8 cup
+newton  kg m / s^2
+N       newton
+joule   N m
+J       joule
+watt    J/s
+W       watt
+calorie 4.184 J
+cal     calorie
+kWh     kW hour
+pascal  N/m^2
+Pa      pascal
+bar     1e5 Pa
+atm     101325 Pa
+psi     lb 9.80665 m/s^2 / in^2
+hp      550 ft lb 9.80665 m/s^2 / s
+byte    8 bit
+B       byte
+`
+
+type (
+	// unitType represents a quantity as a factor and the exponents of each
+	// primitive unit. E.g. km/h = 1000/3600 * m^1 * s^-1.
+	unitType struct {
+		factor *decimal.Big
+		dims   map[string]int
+	}
+
+	// unitsDB holds unit and prefix definitions in GNU units format.
+	unitsDB struct {
+		ctx      decimal.Context
+		units    map[string]string    // Unit name -> definition
+		prefixes map[string]string    // Prefix name -> definition
+		cache    map[string]*unitType // Resolved units
+		loading  map[string]bool      // Units being resolved (loop detection)
+	}
+)
+
+// unitFiles returns the GNU units data files to load, in order. Later files
+// override definitions in earlier files. The RPN_UNITS environment variable
+// points to an additional user provided file.
+func unitFiles() []string {
+	files := []string{
+		"/usr/share/units/definitions.units",
+		"/usr/local/share/units/definitions.units",
+	}
+	if dir, err := os.UserConfigDir(); err == nil {
+		files = append(files, filepath.Join(dir, "rpn", "units"))
+	}
+	if f := os.Getenv("RPN_UNITS"); f != "" {
+		files = append(files, f)
+	}
+	return files
+}
+
+// newUnitsDB returns a new unit database containing the builtin units.
+func newUnitsDB(ctx decimal.Context) *unitsDB {
+	db := &unitsDB{
+		ctx:      ctx,
+		units:    map[string]string{},
+		prefixes: map[string]string{},
+	}
+	db.load(strings.NewReader(builtinUnits))
+	return db
+}
+
+// loadUnits returns a new unit database with the builtin units and all unit
+// files found in the system (see unitFiles).
+func loadUnits(ctx decimal.Context) (*unitsDB, error) {
+	db := newUnitsDB(ctx)
+	for _, fname := range unitFiles() {
+		if err := db.loadFile(fname); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return nil, err
+		}
+	}
+	return db, nil
+}
+
+// loadFile loads definitions from a GNU units data file.
+func (x *unitsDB) loadFile(fname string) error {
+	f, err := os.Open(fname)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return x.load(f)
+}
+
+// load reads definitions in GNU units format. Only the subset of the format
+// needed for linear conversions is supported: directives, functions, and
+// tables are silently ignored, as are definitions we can't evaluate.
+func (x *unitsDB) load(r io.Reader) error {
+	// Invalidate previously resolved units.
+	x.cache = map[string]*unitType{}
+
+	scanner := bufio.NewScanner(r)
+	line := ""
+	for scanner.Scan() {
+		line += scanner.Text()
+		// Backslash continues the line.
+		if strings.HasSuffix(line, "\\") {
+			line = strings.TrimSuffix(line, "\\")
+			continue
+		}
+		if i := strings.Index(line, "#"); i >= 0 {
+			line = line[:i]
+		}
+		fields := strings.Fields(line)
+		line = ""
+
+		// Skip directives (!include, !locale, etc), functions and tables.
+		if len(fields) < 2 || strings.HasPrefix(fields[0], "!") || strings.ContainsAny(fields[0], "()[]") {
+			continue
+		}
+		name, def := fields[0], strings.Join(fields[1:], " ")
+		if strings.HasSuffix(name, "-") {
+			x.prefixes[strings.TrimSuffix(name, "-")] = def
+			continue
+		}
+		x.units[name] = def
+	}
+	return scanner.Err()
+}
+
+// lookup resolves a unit name, trying plural forms and prefixes when the
+// name is not directly defined.
+func (x *unitsDB) lookup(name string) (*unitType, error) {
+	if u, ok := x.cache[name]; ok {
+		return u, nil
+	}
+	if x.loading[name] {
+		return nil, fmt.Errorf("circular definition for unit %q", name)
+	}
+
+	def, ok := x.units[name]
+	if !ok {
+		// Plurals.
+		for _, suffix := range []string{"s", "es"} {
+			if singular, found := strings.CutSuffix(name, suffix); found && len(singular) > 1 {
+				if u, err := x.lookup(singular); err == nil {
+					return u, nil
+				}
+			}
+		}
+		// Prefixes, longest first. A prefix alone (E.g. "kilo") is also valid.
+		prefixes := []string{}
+		for p := range x.prefixes {
+			if strings.HasPrefix(name, p) {
+				prefixes = append(prefixes, p)
+			}
+		}
+		sort.Slice(prefixes, func(i, j int) bool { return len(prefixes[i]) > len(prefixes[j]) })
+		for _, p := range prefixes {
+			rest := strings.TrimPrefix(name, p)
+			if rest == "" {
+				return x.eval(x.prefixes[p])
+			}
+			if _, err := x.lookup(rest); err != nil {
+				continue
+			}
+			return x.eval(x.prefixes[p] + " " + rest)
+		}
+		return nil, fmt.Errorf("unknown unit: %q", name)
+	}
+
+	// Primitive unit.
+	if strings.HasPrefix(def, "!") {
+		u := &unitType{factor: bigUint(1), dims: map[string]int{}}
+		if def != "!dimensionless" {
+			u.dims[name] = 1
+		}
+		x.cache[name] = u
+		return u, nil
+	}
+
+	if x.loading == nil {
+		x.loading = map[string]bool{}
+	}
+	x.loading[name] = true
+	u, err := x.eval(def)
+	delete(x.loading, name)
+	if err != nil {
+		return nil, fmt.Errorf("unit %q: %v", name, err)
+	}
+	x.cache[name] = u
+	return u, nil
+}
+
+// number parses a number in GNU units format (E.g. 1.5, 1e3, or 1|3).
+func (x *unitsDB) number(s string) (*decimal.Big, error) {
+	num, den, isFraction := strings.Cut(s, "|")
+	n, ok := big().SetString(num)
+	if !ok || n.IsNaN(0) {
+		return nil, fmt.Errorf("invalid number: %q", s)
+	}
+	if isFraction {
+		d, ok := big().SetString(den)
+		if !ok || d.IsNaN(0) || d.Sign() == 0 {
+			return nil, fmt.Errorf("invalid number: %q", s)
+		}
+		x.ctx.Quo(n, n, d)
+	}
+	return n, nil
+}
+
+// eval evaluates a unit expression: a list of factors (numbers or units,
+// optionally raised to an integer power with ^) multiplied together. A slash
+// divides the product by all the factors following it.
+func (x *unitsDB) eval(expr string) (*unitType, error) {
+	if strings.ContainsAny(expr, "()+") {
+		return nil, errors.New("unsupported unit expression")
+	}
+	expr = strings.NewReplacer("/", " / ", "*", " ", " per ", " / ").Replace(expr)
+
+	ret := &unitType{factor: bigUint(1), dims: map[string]int{}}
+	sign := 1
+	for _, term := range strings.Fields(expr) {
+		if term == "/" {
+			sign = -1
+			continue
+		}
+
+		// Exponents (m^2, s^-1, or cm3).
+		exp := 1
+		base, e, hasExp := strings.Cut(term, "^")
+		if hasExp {
+			n, err := strconv.Atoi(e)
+			if err != nil {
+				return nil, fmt.Errorf("invalid exponent: %q", term)
+			}
+			exp = n
+		} else if n := len(term); n > 1 && unicode.IsLetter(rune(term[0])) && term[n-1] >= '2' && term[n-1] <= '9' && unicode.IsLetter(rune(term[n-2])) {
+			if _, err := x.lookup(term); err != nil {
+				base, exp = term[:n-1], int(term[n-1]-'0')
+			}
+		}
+		exp *= sign
+
+		var u *unitType
+		if c := base[0]; (c >= '0' && c <= '9') || c == '.' {
+			n, err := x.number(base)
+			if err != nil {
+				return nil, err
+			}
+			u = &unitType{factor: n, dims: map[string]int{}}
+		} else {
+			var err error
+			if u, err = x.lookup(base); err != nil {
+				return nil, err
+			}
+		}
+
+		// ret = ret * u^exp
+		for i := 0; i < exp; i++ {
+			ret.factor.Mul(ret.factor, u.factor)
+		}
+		for i := 0; i > exp; i-- {
+			x.ctx.Quo(ret.factor, ret.factor, u.factor)
+		}
+		for dim, n := range u.dims {
+			ret.dims[dim] += n * exp
+			if ret.dims[dim] == 0 {
+				delete(ret.dims, dim)
+			}
+		}
+	}
+	return ret, nil
+}
+
+// convert converts the value n from unit "from" into unit "to".
+func (x *unitsDB) convert(n *decimal.Big, from, to string) (*decimal.Big, error) {
+	f, err := x.eval(from)
+	if err != nil {
+		return nil, err
+	}
+	t, err := x.eval(to)
+	if err != nil {
+		return nil, err
+	}
+	if len(f.dims) != len(t.dims) {
+		return nil, fmt.Errorf("incompatible units: %s and %s", from, to)
+	}
+	for dim, n := range f.dims {
+		if t.dims[dim] != n {
+			return nil, fmt.Errorf("incompatible units: %s and %s", from, to)
+		}
+	}
+	z := big().Mul(n, f.factor)
+	return x.ctx.Quo(z, z, t.factor), nil
+}
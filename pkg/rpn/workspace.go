@@ -0,0 +1,88 @@
+// This file is part of rpn, a simple and useful CLI RPN calculator.
+// For further information, check https://github.com/marcopaganini/rpn
+//
+// (C) Sep/2024 by Marco Paganini <paganini AT paganini DOT net>
+
+package rpn
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/ericlagergren/decimal"
+)
+
+// defaultWorkspace is the name of the workspace used at startup.
+const defaultWorkspace = "default"
+
+// workspaceType holds the stack, labels and undo history of an inactive
+// workspace.
+type workspaceType struct {
+	list     []*decimal.Big
+	labels   []string
+	undoList []stateType
+	redoList []stateType
+}
+
+// switchWorkspace makes the named workspace the current one. If create is
+// true, a new (empty) workspace is created. The contents of the current
+// workspace are kept until we switch back to it.
+func (x *opsType) switchWorkspace(name string, create bool) error {
+	ws, ok := x.workspaces[name]
+	exists := ok || name == x.workspace
+	if create && exists {
+		return fmt.Errorf("workspace %q already exists", name)
+	}
+	if !create && !exists {
+		return fmt.Errorf("no such workspace: %q", name)
+	}
+	if name == x.workspace {
+		return nil
+	}
+	if create {
+		ws = &workspaceType{list: []*decimal.Big{}}
+	}
+
+	if x.workspaces == nil {
+		x.workspaces = map[string]*workspaceType{}
+	}
+	x.workspaces[x.workspace] = &workspaceType{
+		list:     x.stack.list,
+		labels:   x.stack.labelList(),
+		undoList: x.undoList,
+		redoList: x.redoList,
+	}
+	delete(x.workspaces, name)
+
+	x.workspace = name
+	x.stack.list = ws.list
+	x.stack.setLabelList(ws.labels)
+	x.undoList = ws.undoList
+	x.redoList = ws.redoList
+
+	// Errors and undo in the same line apply to the new workspace.
+	x.lineStart = x.state()
+	return nil
+}
+
+// deleteWorkspace removes a workspace. The current workspace can't be removed.
+func (x *opsType) deleteWorkspace(name string) error {
+	if name == x.workspace {
+		return fmt.Errorf("can't delete the current workspace %q", name)
+	}
+	if _, ok := x.workspaces[name]; !ok {
+		return fmt.Errorf("no such workspace: %q", name)
+	}
+	delete(x.workspaces, name)
+	return nil
+}
+
+// workspaceNames returns the sorted names of all workspaces.
+func (x *opsType) workspaceNames() []string {
+	names := []string{x.workspace}
+	for name := range x.workspaces {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
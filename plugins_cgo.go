@@ -0,0 +1,60 @@
+// This file is part of rpn, a simple and useful CLI RPN calculator.
+// For further information, check https://github.com/marcopaganini/rpn
+//
+// (C) Sep/2024 by Marco Paganini <paganini AT paganini DOT net>
+
+//go:build cgo && (linux || darwin || freebsd)
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"plugin"
+	"sort"
+
+	"github.com/marcopaganini/rpn/pkg/rpn"
+)
+
+// loadPlugins opens all Go plugins (*.so) in dir and registers the
+// operations exported by them in a variable named "Ops", of type []rpn.Op.
+// A missing directory is not an error. Errors in individual plugins don't
+// prevent the others from loading and are returned together.
+func loadPlugins(dir string) error {
+	files, err := filepath.Glob(filepath.Join(dir, "*.so"))
+	if err != nil {
+		return err
+	}
+	sort.Strings(files)
+
+	var errs []error
+	for _, fname := range files {
+		if err := loadPlugin(fname); err != nil {
+			errs = append(errs, fmt.Errorf("plugin %s: %w", filepath.Base(fname), err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// loadPlugin opens a single plugin and registers its operations.
+func loadPlugin(fname string) error {
+	p, err := plugin.Open(fname)
+	if err != nil {
+		return err
+	}
+	sym, err := p.Lookup("Ops")
+	if err != nil {
+		return err
+	}
+	ops, ok := sym.(*[]rpn.Op)
+	if !ok {
+		return fmt.Errorf("symbol Ops has type %T, want *[]rpn.Op", sym)
+	}
+	for _, op := range *ops {
+		if err := rpn.RegisterOp(op); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,24 @@
+// This file is part of rpn, a simple and useful CLI RPN calculator.
+// For further information, check https://github.com/marcopaganini/rpn
+//
+// (C) Sep/2024 by Marco Paganini <paganini AT paganini DOT net>
+
+//go:build !cgo || !(linux || darwin || freebsd)
+
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+)
+
+// loadPlugins reports an error if dir contains plugins, since Go plugins
+// can only be loaded by binaries built with cgo (the release binaries are
+// not). A missing or empty directory is not an error.
+func loadPlugins(dir string) error {
+	files, err := filepath.Glob(filepath.Join(dir, "*.so"))
+	if err != nil || len(files) == 0 {
+		return err
+	}
+	return fmt.Errorf("%d plugin(s) in %s not loaded: plugins require rpn built from source with CGO_ENABLED=1", len(files), dir)
+}
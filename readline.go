@@ -0,0 +1,84 @@
+// This file is part of rpn, a simple and useful CLI RPN calculator.
+// For further information, check https://github.com/marcopaganini/rpn
+//
+// (C) Sep/2024 by Marco Paganini <paganini AT paganini DOT net>
+
+//go:build !js
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/chzyer/readline"
+	"github.com/marcopaganini/rpn/pkg/rpn"
+)
+
+// interactive reads and executes lines from the terminal until EOF (Ctrl-D)
+// or quit. The prompt can be changed with RPN_PROMPT (see
+// rpn.Calculator.Prompt).
+func interactive(c *rpn.Calculator, opts optionsType) error {
+	promptFmt := os.Getenv("RPN_PROMPT")
+	rl, err := readline.NewEx(&readline.Config{
+		Prompt:       c.Prompt(promptFmt),
+		HistoryLimit: opts.cfg.history,
+		Stdin:        os.Stdin,
+		Stdout:       os.Stdout,
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer rl.Close()
+
+	// Wait for the user between operations in step mode.
+	c.SetStepWait(func() (string, error) {
+		rl.SetPrompt(rpn.WarnMsg("step (Enter = next, q = stop)> "))
+		defer rl.SetPrompt(c.Prompt(promptFmt))
+		return rl.Readline()
+	})
+
+	// Wait for entry until Ctrl-D or q is issued
+	for {
+		if c.Debug() {
+			c.PrintStack(os.Stdout)
+		}
+
+		line, err := rl.Readline()
+		if err != nil { // io.EOF
+			break
+		}
+
+		// History expansion. Print the expanded line before executing it.
+		expanded, err := c.ExpandHistory(line)
+		if err != nil {
+			fmt.Printf(rpn.ErrorMsg("ERROR: %v\n"), err)
+			continue
+		}
+		if expanded != line {
+			fmt.Println(expanded)
+		}
+		if strings.TrimSpace(expanded) != "" {
+			c.AddHistory(expanded)
+		}
+
+		autoprint, err := c.Exec(expanded)
+		if errors.Is(err, rpn.ErrQuit) {
+			if !quiet && isTerminal(os.Stdout) {
+				fmt.Printf("Bye.\n")
+			}
+			break
+		}
+		if err != nil {
+			fmt.Printf(rpn.ErrorMsg("ERROR: %v\n"), err)
+		}
+		if autoprint {
+			c.PrintTop(os.Stdout) // pretty print to terminal
+		}
+		rl.SetPrompt(c.Prompt(promptFmt))
+	}
+	return nil
+}
@@ -0,0 +1,20 @@
+// This file is part of rpn, a simple and useful CLI RPN calculator.
+// For further information, check https://github.com/marcopaganini/rpn
+//
+// (C) Sep/2024 by Marco Paganini <paganini AT paganini DOT net>
+
+//go:build js
+
+package main
+
+import (
+	"errors"
+
+	"github.com/marcopaganini/rpn/pkg/rpn"
+)
+
+// interactive is not available in the browser: there is no terminal to read
+// from. Use rpn.Calculator.Eval instead.
+func interactive(_ *rpn.Calculator, _ optionsType) error {
+	return errors.New("interactive mode is not supported on this platform")
+}
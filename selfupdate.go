@@ -0,0 +1,298 @@
+// This file is part of rpn, a simple and useful CLI RPN calculator.
+// For further information, check https://github.com/marcopaganini/rpn
+//
+// (C) Sep/2024 by Marco Paganini <paganini AT paganini DOT net>
+
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"cmp"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// releasesURL points to the GitHub API URL for the latest release.
+const releasesURL = "https://api.github.com/repos/marcopaganini/rpn/releases/latest"
+
+// releaseType holds the fields we need from the GitHub releases API.
+type releaseType struct {
+	TagName string `json:"tag_name"`
+	Assets  []struct {
+		Name string `json:"name"`
+		URL  string `json:"browser_download_url"`
+	} `json:"assets"`
+}
+
+// httpClient is used for all downloads.
+var httpClient = &http.Client{Timeout: 5 * time.Minute}
+
+// download returns the contents of the URL passed.
+func download(url string) ([]byte, error) {
+	resp, err := httpClient.Get(url)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("error downloading %s: %s", url, resp.Status)
+	}
+	return io.ReadAll(resp.Body)
+}
+
+// assetURL returns the download URL for the release asset with the given name.
+func (x releaseType) assetURL(name string) (string, error) {
+	for _, asset := range x.Assets {
+		if asset.Name == name {
+			return asset.URL, nil
+		}
+	}
+	return "", fmt.Errorf("release %s has no file named %s", x.TagName, name)
+}
+
+// checksum returns the sha256 checksum for file from a checksum file in the
+// format generated by sha256sum (and goreleaser).
+func checksum(sums []byte, file string) (string, error) {
+	scanner := bufio.NewScanner(bytes.NewReader(sums))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 2 && fields[1] == file {
+			return fields[0], nil
+		}
+	}
+	return "", fmt.Errorf("no checksum found for %s", file)
+}
+
+// extractBinary returns the contents of the file named binary from a tar.gz
+// or zip archive.
+func extractBinary(archive []byte, binary string, isZip bool) ([]byte, error) {
+	if isZip {
+		zr, err := zip.NewReader(bytes.NewReader(archive), int64(len(archive)))
+		if err != nil {
+			return nil, err
+		}
+		for _, f := range zr.File {
+			if filepath.Base(f.Name) != binary {
+				continue
+			}
+			r, err := f.Open()
+			if err != nil {
+				return nil, err
+			}
+			defer r.Close()
+			return io.ReadAll(r)
+		}
+		return nil, fmt.Errorf("%s not found in archive", binary)
+	}
+
+	gz, err := gzip.NewReader(bytes.NewReader(archive))
+	if err != nil {
+		return nil, err
+	}
+	tr := tar.NewReader(gz)
+	for {
+		hdr, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			return nil, fmt.Errorf("%s not found in archive", binary)
+		}
+		if err != nil {
+			return nil, err
+		}
+		if hdr.Typeflag == tar.TypeReg && filepath.Base(hdr.Name) == binary {
+			return io.ReadAll(tr)
+		}
+	}
+}
+
+// replaceExecutable atomically replaces the file at path with data. The new
+// file is written to a temporary file in the same directory and renamed.
+func replaceExecutable(path string, data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+"-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), 0755); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// semverType holds a parsed semantic version (https://semver.org).
+type semverType struct {
+	core [3]int   // Major, minor and patch
+	pre  []string // Pre-release identifiers (E.g. rc.1), if any
+}
+
+// parseSemver parses a semantic version (E.g. 1.2.3, v1.2.3-rc.1+build).
+// Build metadata is ignored.
+func parseSemver(s string) (semverType, error) {
+	var ret semverType
+	v, _, _ := strings.Cut(strings.TrimPrefix(s, "v"), "+")
+	v, pre, hasPre := strings.Cut(v, "-")
+	if hasPre {
+		ret.pre = strings.Split(pre, ".")
+		if slices.Contains(ret.pre, "") {
+			return ret, fmt.Errorf("invalid version: %q", s)
+		}
+	}
+	parts := strings.Split(v, ".")
+	if len(parts) != 3 {
+		return ret, fmt.Errorf("invalid version: %q", s)
+	}
+	for i, p := range parts {
+		n, err := strconv.Atoi(p)
+		if err != nil || n < 0 || (len(p) > 1 && p[0] == '0') {
+			return ret, fmt.Errorf("invalid version: %q", s)
+		}
+		ret.core[i] = n
+	}
+	return ret, nil
+}
+
+// compare returns -1, 0 or +1 depending on whether x precedes, equals or
+// follows v, using the semantic versioning precedence rules: pre-releases
+// precede the release, and pre-release identifiers are compared one by one,
+// numerically when possible.
+func (x semverType) compare(v semverType) int {
+	if c := slices.Compare(x.core[:], v.core[:]); c != 0 {
+		return c
+	}
+	switch {
+	case len(x.pre) == 0 && len(v.pre) == 0:
+		return 0
+	case len(x.pre) == 0:
+		return 1
+	case len(v.pre) == 0:
+		return -1
+	}
+	for i := 0; i < len(x.pre) && i < len(v.pre); i++ {
+		a, aerr := strconv.Atoi(x.pre[i])
+		b, berr := strconv.Atoi(v.pre[i])
+		var c int
+		switch {
+		case aerr == nil && berr == nil:
+			c = cmp.Compare(a, b)
+		case aerr == nil:
+			c = -1 // Numeric identifiers precede alphanumeric ones
+		case berr == nil:
+			c = 1
+		default:
+			c = strings.Compare(x.pre[i], v.pre[i])
+		}
+		if c != 0 {
+			return c
+		}
+	}
+	return cmp.Compare(len(x.pre), len(v.pre))
+}
+
+// selfUpdate checks the latest release using the GitHub releases API at url,
+// downloads the binary for the current OS and architecture, verifies its
+// checksum and replaces the executable at exe. Progress goes to w. The
+// running version (current) is never downgraded, and development builds
+// (without a release version) are not replaced, unless force is set.
+func selfUpdate(w io.Writer, url, exe, current string, force bool) error {
+	data, err := download(url)
+	if err != nil {
+		return err
+	}
+	var release releaseType
+	if err := json.Unmarshal(data, &release); err != nil {
+		return fmt.Errorf("error parsing release information: %v", err)
+	}
+
+	version := strings.TrimPrefix(release.TagName, "v")
+	if version == "" {
+		return errors.New("unable to determine the latest release version")
+	}
+	latest, err := parseSemver(version)
+	if err != nil {
+		return fmt.Errorf("latest release: %v", err)
+	}
+	running, err := parseSemver(current)
+	switch {
+	case err != nil:
+		if !force {
+			return fmt.Errorf("not a release build, use --force to replace it with v%s", version)
+		}
+	case latest.compare(running) == 0:
+		fmt.Fprintf(w, "Already running the latest version (v%s).\n", version)
+		return nil
+	case latest.compare(running) < 0 && !force:
+		return fmt.Errorf("running v%s, newer than the latest release (v%s), use --force to downgrade", current, version)
+	}
+	fmt.Fprintf(w, "Latest version: v%s\n", version)
+
+	// File names follow the goreleaser defaults.
+	isZip := runtime.GOOS == "windows"
+	binary := "rpn"
+	archiveName := fmt.Sprintf("rpn_%s_%s_%s.tar.gz", version, runtime.GOOS, runtime.GOARCH)
+	if isZip {
+		binary = "rpn.exe"
+		archiveName = strings.TrimSuffix(archiveName, ".tar.gz") + ".zip"
+	}
+	sumsName := fmt.Sprintf("rpn_%s_checksums.txt", version)
+
+	archiveURL, err := release.assetURL(archiveName)
+	if err != nil {
+		return err
+	}
+	sumsURL, err := release.assetURL(sumsName)
+	if err != nil {
+		return err
+	}
+
+	sums, err := download(sumsURL)
+	if err != nil {
+		return err
+	}
+	want, err := checksum(sums, archiveName)
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(w, "Downloading %s\n", archiveURL)
+	archive, err := download(archiveURL)
+	if err != nil {
+		return err
+	}
+	sum := sha256.Sum256(archive)
+	if got := hex.EncodeToString(sum[:]); got != want {
+		return fmt.Errorf("checksum mismatch for %s: want %s, got %s", archiveName, want, got)
+	}
+
+	bin, err := extractBinary(archive, binary, isZip)
+	if err != nil {
+		return err
+	}
+	if err := replaceExecutable(exe, bin); err != nil {
+		return err
+	}
+	fmt.Fprintf(w, "Updated %s to v%s.\n", exe, version)
+	return nil
+}
@@ -0,0 +1,108 @@
+// This file is part of rpn, a simple and useful CLI RPN calculator.
+// For further information, check https://github.com/marcopaganini/rpn
+//
+// (C) Sep/2024 by Marco Paganini <paganini AT paganini DOT net>
+
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"net"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+
+	"github.com/fatih/color"
+	"github.com/marcopaganini/rpn/pkg/rpn"
+)
+
+// server contains information about a calculator server listening on a unix
+// domain socket. All connections share the same calculator.
+type server struct {
+	mu   sync.Mutex // Serializes access to the calculator
+	calc *rpn.Calculator
+}
+
+// listen listens on the unix domain socket at path and serves line oriented
+// connections until interrupted by a signal. Stale socket files are removed.
+func listen(c *rpn.Calculator, path string) error {
+	// Remove stale sockets left by previous runs.
+	if fi, err := os.Stat(path); err == nil && fi.Mode().Type() == fs.ModeSocket {
+		if err := os.Remove(path); err != nil {
+			return err
+		}
+	}
+
+	l, err := net.Listen("unix", path)
+	if err != nil {
+		return err
+	}
+
+	// Close the listener (and remove the socket) on termination signals.
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-sigs
+		l.Close()
+	}()
+
+	// Output goes to the network, so no colors.
+	color.NoColor = true
+
+	srv := &server{calc: c}
+	for {
+		conn, err := l.Accept()
+		if errors.Is(err, net.ErrClosed) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		go srv.serve(conn)
+	}
+}
+
+// serve reads lines from a connection, executes them and writes the results
+// back, the same way an interactive session would.
+func (x *server) serve(conn net.Conn) {
+	defer conn.Close()
+
+	scanner := bufio.NewScanner(conn)
+	for scanner.Scan() {
+		if quit := x.run(conn, scanner.Text()); quit {
+			fmt.Fprintln(conn, "Bye.")
+			return
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		log.Printf("Error reading from connection: %v", err)
+	}
+}
+
+// run executes a line of input sending all output to conn. It returns true
+// if the user asked to quit.
+func (x *server) run(conn net.Conn, line string) bool {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+
+	c := x.calc
+	c.SetOutput(conn)
+	defer c.SetOutput(os.Stdout)
+
+	autoprint, err := c.Exec(line)
+	if errors.Is(err, rpn.ErrQuit) {
+		return true
+	}
+	if err != nil {
+		fmt.Fprintf(conn, "ERROR: %v\n", err)
+	}
+	if autoprint {
+		c.PrintTop(conn)
+	}
+	return false
+}
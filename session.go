@@ -0,0 +1,63 @@
+// This file is part of rpn, a simple and useful CLI RPN calculator.
+// For further information, check https://github.com/marcopaganini/rpn
+//
+// (C) Sep/2024 by Marco Paganini <paganini AT paganini DOT net>
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+
+	"github.com/marcopaganini/rpn/internal/xdg"
+	"github.com/marcopaganini/rpn/pkg/rpn"
+)
+
+// sessionFile returns the path of the file holding the saved session.
+func sessionFile() (string, error) {
+	return xdg.StateFile("session.json")
+}
+
+// saveSession saves the stack and modes of c to the session file.
+func saveSession(c *rpn.Calculator) error {
+	fname, err := sessionFile()
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(fname), 0o755); err != nil {
+		return err
+	}
+	f, err := os.Create(fname)
+	if err != nil {
+		return err
+	}
+	if err := c.SaveSession(f); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// loadSession restores the stack and modes of c from the session file. A
+// missing session file is not an error.
+func loadSession(c *rpn.Calculator) error {
+	fname, err := sessionFile()
+	if err != nil {
+		return err
+	}
+	f, err := os.Open(fname)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if err := c.LoadSession(f); err != nil {
+		return fmt.Errorf("%s: %w", fname, err)
+	}
+	return nil
+}